@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+)
+
+const cliUsage = `usage: spotdl-wapper dead-letters <command>
+
+commands:
+  list            list the dead-lettered requests, newest first
+  show <id>       show the error, downloader output and skipped tracks of a request
+  requeue <id>    put a request back into the queue with fresh sync, retry and track budgets`
+
+var errUsage = errors.New("invalid command")
+
+// runCLI runs a one-off command against the database instead of the processing loop
+func runCLI(ctx context.Context, database db.Database, out io.Writer, args []string) error {
+	if len(args) < 2 || args[0] != "dead-letters" {
+		return errUsage
+	}
+
+	switch command := args[1]; {
+	case command == "list" && len(args) == 2:
+		return listDeadLetters(ctx, database, out)
+	case command == "show" && len(args) == 3:
+		return showDeadLetter(ctx, database, out, args[2])
+	case command == "requeue" && len(args) == 3:
+		request, err := db.Requeue(ctx, database, args[2])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "requeued %s %s\n", request.ID, request.SpotifyURL)
+		return nil
+	default:
+		return errUsage
+	}
+}
+
+func listDeadLetters(ctx context.Context, database db.Database, out io.Writer) error {
+	letters, err := database.ListDeadLetters(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDEAD SINCE\tTYPE\tNAME\tSKIPPED\tERROR")
+	for _, letter := range letters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", letter.RequestID, formatUnix(letter.CreatedAt), letter.ObjectType,
+			letter.Name, len(letter.SkippedTracks), firstLine(letter.Error))
+	}
+	return w.Flush()
+}
+
+func showDeadLetter(ctx context.Context, database db.Database, out io.Writer, id string) error {
+	letter, err := database.GetDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "request:     %s\n", letter.RequestID)
+	fmt.Fprintf(out, "url:         %s\n", letter.SpotifyURL)
+	fmt.Fprintf(out, "name:        %s\n", letter.Name)
+	fmt.Fprintf(out, "type:        %s\n", letter.ObjectType)
+	fmt.Fprintf(out, "dead since:  %s\n", formatUnix(letter.CreatedAt))
+	fmt.Fprintf(out, "syncs:       %d\n", letter.SyncCount)
+	fmt.Fprintf(out, "retries:     %d\n", letter.RetryCount)
+	fmt.Fprintf(out, "error:       %s\n", letter.Error)
+
+	fmt.Fprintf(out, "\nskipped tracks (%d):\n", len(letter.SkippedTracks))
+	for _, track := range letter.SkippedTracks {
+		fmt.Fprintf(out, "  %s - %s (%s), %d failed attempts", track.Artist, track.Title, track.SpotifyURL, track.FailedAttempts)
+		if track.Reason != "" {
+			fmt.Fprintf(out, ": %s", track.Reason)
+		}
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintf(out, "\ndownloader output (last %d lines):\n", len(letter.OutputTail))
+	for _, line := range letter.OutputTail {
+		fmt.Fprintf(out, "  %s\n", line)
+	}
+	return nil
+}
+
+func formatUnix(sec int64) string {
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
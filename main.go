@@ -2,61 +2,254 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"sync"
+	"syscall"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/api"
 	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/config"
 	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/health"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/indexer"
 	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/loki"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/match"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/mediaserver"
 	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/service"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/subsonic"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/telegram"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/utils"
 	"github.com/supperdoggy/spot-models/spotify"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 func main() {
-	run()
-	time.Sleep(5 * time.Second)
-}
-
-func run() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	cfg, err := config.NewConfig()
 	if err != nil {
 		panic(err)
 	}
 
-	log := buildLogger(cfg)
-	defer log.Sync()
+	log, flushLogs := buildLogger(cfg)
+	defer flushLogs()
 
 	log.Info("loaded config", zap.Any("config", cfg))
 
 	spotifyService := spotify.NewSpotifyService(ctx, cfg.Spotify.ClientID, cfg.Spotify.ClientSecret, log)
 
-	database, err := db.NewDatabase(ctx, log, cfg.DatabaseURL, cfg.DatabaseName)
+	database, err := db.Open(ctx, log, cfg.DatabaseDriver, cfg.DatabaseURL, cfg.DatabaseName)
 	if err != nil {
 		log.Fatal("failed to connect to database", zap.Error(err))
 	}
 
-	log.Info("connected to database")
+	log.Info("connected to database", zap.String("driver", cfg.DatabaseDriver))
+
+	// e.g. spotdl-wapper dead-letters list, the processing loop is not started
+	if args := os.Args[1:]; len(args) > 0 {
+		err := runCLI(ctx, database, os.Stdout, args)
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, cliUsage)
+			flushLogs()
+			os.Exit(2)
+		}
+		if err != nil {
+			log.Fatal("command failed", zap.Strings("args", args), zap.Error(err))
+		}
+		return
+	}
+
+	downloader, err := service.NewDownloader(cfg.Download.Backend, log, cfg.Destination, cfg.Download.TrackTimeout, cfg.Download.BulkTimeout)
+	if err != nil {
+		log.Fatal("failed to create downloader", zap.Error(err))
+	}
+
+	playlistPaths, err := utils.NewPathMapper(cfg.Playlist.PathMode, cfg.Playlist.PathPrefixes)
+	if err != nil {
+		log.Fatal("invalid playlist path mapping", zap.Error(err))
+	}
+
+	playlistFormats := make([]utils.PlaylistFormat, 0, len(cfg.Playlist.Formats))
+	for _, format := range cfg.Playlist.Formats {
+		if _, err := utils.NewPlaylistWriter(utils.PlaylistFormat(format)); err != nil {
+			log.Fatal("invalid playlist format", zap.Error(err))
+		}
+		playlistFormats = append(playlistFormats, utils.PlaylistFormat(format))
+	}
+
+	var playlistPublisher service.PlaylistPublisher
+	if cfg.Subsonic.Enabled {
+		client := subsonic.NewClient(cfg.Subsonic.URL, cfg.Subsonic.Username, cfg.Subsonic.Password, cfg.Subsonic.Timeout)
+		if err := client.Ping(ctx); err != nil {
+			log.Error("subsonic server not reachable, playlists are pushed once it is", zap.Error(err))
+		}
+		playlistPublisher = subsonic.NewPublisher(client, match.NewMatcher(cfg.Match.Threshold, cfg.Match.DurationTolerance), log)
+	}
+
+	var wg sync.WaitGroup
+
+	var rescanner service.LibraryRescanner
+	if servers := rescanServers(cfg); len(servers) > 0 {
+		hooks := mediaserver.NewHooks(log, servers...)
+		rescanner = hooks
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hooks.Run(ctx)
+		}()
+	}
+
+	var libraryIndexer service.LibraryIndexer
+	if cfg.Indexer.Enabled {
+		fullIndexer := indexer.NewIndexer(database, log, cfg.MusicLibraryPath, cfg.Destination)
+		libraryIndexer = fullIndexer
+		if cfg.Indexer.Watch {
+			watcher := indexer.NewWatcher(fullIndexer, log, cfg.Indexer.Debounce, cfg.MusicLibraryPath, cfg.Destination)
+			libraryIndexer = watcher
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := watcher.Watch(ctx); err != nil {
+					log.Error("library watcher failed, falling back to full rescans", zap.Error(err))
+				}
+			}()
+		}
+	}
 
-	srv := service.NewService(database, log, spotifyService, cfg.Destination, cfg.MusicLibraryPath, cfg.SleepInMinutes)
+	sinks := eventSinks(cfg)
+	if cfg.Telegram.Enabled {
+		notifier := telegram.NewNotifier(telegram.NewClient(cfg.Telegram.APIURL, cfg.Telegram.BotToken, cfg.Telegram.Timeout),
+			log, cfg.Telegram.AdminChatID, cfg.Telegram.DigestHour)
+		sinks = append(sinks, notifier)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			notifier.Run(ctx)
+		}()
+	}
 
-	if err := srv.StartProcessing(ctx); err != nil {
-		log.Fatal("failed to start processing", zap.Error(err))
+	var events service.EventPublisher
+	if len(sinks) > 0 {
+		bus := service.NewEventBus(log, cfg.Events.BufferSize, sinks...)
+		events = bus
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bus.Run(ctx)
+		}()
 	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			log.Error("recovered from panic", zap.Any("panic", r))
-			run()
+	var queueWatcher service.QueueWatcher
+	if cfg.Scheduler.WatchQueues {
+		if w, ok := database.(service.QueueWatcher); ok {
+			queueWatcher = w
+		} else {
+			log.Warn("the database driver can't watch the queues, polling only", zap.String("driver", cfg.DatabaseDriver))
 		}
-	}()
+	}
+
+	srv := service.NewService(database, log, spotifyService, downloader, service.Options{
+		Destination:         cfg.Destination,
+		LibraryPath:         cfg.MusicLibraryPath,
+		SleepInMinutes:      cfg.SleepInMinutes,
+		ProcessInterval:     cfg.Scheduler.ProcessInterval,
+		ShutdownGracePeriod: cfg.Scheduler.ShutdownGracePeriod,
+		DownloadWorkers:     cfg.Download.Workers,
+		InstanceID:          cfg.Scheduler.InstanceID,
+		LeaseDuration:       cfg.Scheduler.LeaseDuration,
+		Indexer:             libraryIndexer,
+
+		MatchThreshold:         cfg.Match.Threshold,
+		MatchDurationTolerance: cfg.Match.DurationTolerance,
+		PlaylistPaths:          playlistPaths,
+		PlaylistFormats:        playlistFormats,
+		PlaylistResyncInterval: cfg.Playlist.ResyncInterval,
+		PlaylistPublisher:      playlistPublisher,
+		Rescanner:              rescanner,
+		Events:                 events,
+		Watcher:                queueWatcher,
+		Retry: service.RetryPolicy{
+			BaseDelay: cfg.Retry.BaseDelay,
+			MaxDelay:  cfg.Retry.MaxDelay,
+			MaxAttempts: map[spotify.SpotifyObjectType]int{
+				spotify.SpotifyObjectTypeTrack:    cfg.Retry.TrackAttempts,
+				spotify.SpotifyObjectTypeAlbum:    cfg.Retry.AlbumAttempts,
+				spotify.SpotifyObjectTypePlaylist: cfg.Retry.PlaylistAttempts,
+			},
+			PlaylistMaxAttempts: cfg.Retry.PlaylistFileAttempts,
+		},
+	})
+
+	if cfg.HTTP.Enabled {
+		apiServer := api.NewServer(database, spotifyService, log, cfg.HTTP.Addr)
+		apiServer.Handle("GET /metrics", promhttp.Handler())
+
+		checker := health.NewChecker(database, srv, log, downloader.Name(), cfg.Destination, cfg.MusicLibraryPath, cfg.Health.MaxTickAge)
+		apiServer.Handle("GET /healthz", http.HandlerFunc(checker.Liveness))
+		apiServer.Handle("GET /readyz", http.HandlerFunc(checker.Readiness))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := apiServer.ListenAndServe(ctx); err != nil {
+				log.Error("http server failed", zap.Error(err))
+			}
+		}()
+	}
+
+	if err := srv.Run(ctx); err != nil {
+		log.Error("processing loop exited with error", zap.Error(err))
+	}
+
+	wg.Wait()
+
+	log.Info("shutdown complete")
 }
 
-func buildLogger(cfg *config.Config) *zap.Logger {
+// eventSinks returns the configured destinations of the lifecycle events
+func eventSinks(cfg *config.Config) []service.EventSink {
+	var sinks []service.EventSink
+	for _, url := range cfg.Events.WebhookURLs {
+		sinks = append(sinks, service.NewWebhookSink(url, cfg.Events.WebhookSecret, cfg.Events.WebhookAttempts, cfg.Events.WebhookTimeout))
+	}
+	for _, command := range cfg.Events.HookCommands {
+		sinks = append(sinks, service.NewExecSink(command, cfg.Events.HookTimeout))
+	}
+	return sinks
+}
+
+// rescanServers returns the media servers to rescan after downloads
+func rescanServers(cfg *config.Config) []mediaserver.Server {
+	var servers []mediaserver.Server
+	if cfg.Rescan.JellyfinURL != "" {
+		servers = append(servers, mediaserver.Server{
+			Scanner:  mediaserver.NewJellyfin(cfg.Rescan.JellyfinURL, cfg.Rescan.JellyfinAPIKey, cfg.Rescan.Timeout),
+			Debounce: cfg.Rescan.JellyfinDebounce,
+		})
+	}
+	if cfg.Rescan.NavidromeURL != "" {
+		client := subsonic.NewClient(cfg.Rescan.NavidromeURL, cfg.Rescan.NavidromeUsername, cfg.Rescan.NavidromePassword, cfg.Rescan.Timeout)
+		servers = append(servers, mediaserver.Server{
+			Scanner:  mediaserver.NewNavidrome(client),
+			Debounce: cfg.Rescan.NavidromeDebounce,
+		})
+	}
+	if cfg.Rescan.PlexURL != "" {
+		servers = append(servers, mediaserver.Server{
+			Scanner:  mediaserver.NewPlex(cfg.Rescan.PlexURL, cfg.Rescan.PlexToken, cfg.Rescan.PlexSection, cfg.Rescan.Timeout),
+			Debounce: cfg.Rescan.PlexDebounce,
+		})
+	}
+	return servers
+}
+
+// buildLogger returns the logger and a function flushing it, call it before exiting
+func buildLogger(cfg *config.Config) (*zap.Logger, func()) {
 	// Console core (always enabled)
 	consoleEncoderConfig := zap.NewDevelopmentEncoderConfig()
 	consoleEncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
@@ -74,9 +267,16 @@ func buildLogger(cfg *config.Config) *zap.Logger {
 			"job":     "music-services",
 		}, zapcore.InfoLevel)
 
-		return zap.New(zapcore.NewTee(consoleCore, lokiCore))
+		log := zap.New(zapcore.NewTee(consoleCore, lokiCore))
+		return log, func() {
+			_ = log.Sync()
+			lokiCore.Stop()
+		}
 	}
 
 	fmt.Println("[loki] disabled (LOKI_ENABLED=false or LOKI_URL not set)")
-	return zap.New(consoleCore)
+	log := zap.New(consoleCore)
+	return log, func() {
+		_ = log.Sync()
+	}
 }
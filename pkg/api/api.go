@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+// shutdownTimeout bounds how long in-flight HTTP requests may take once the server is stopped
+const shutdownTimeout = 5 * time.Second
+
+// Server is the embedded HTTP server used by dashboards and scripts to manage the queue
+type Server struct {
+	database       db.Database
+	spotifyService spotify.SpotifyService
+	log            *zap.Logger
+
+	addr string
+	mux  *http.ServeMux
+}
+
+func NewServer(database db.Database, spotifyService spotify.SpotifyService, log *zap.Logger, addr string) *Server {
+	s := &Server{
+		database:       database,
+		spotifyService: spotifyService,
+		log:            log,
+		addr:           addr,
+		mux:            http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("POST /api/requests", s.createRequest)
+	s.mux.HandleFunc("GET /api/requests", s.listRequests)
+	s.mux.HandleFunc("GET /api/requests/{id}", s.getRequest)
+	s.mux.HandleFunc("POST /api/requests/{id}/cancel", s.cancelRequest)
+	s.mux.HandleFunc("POST /api/requests/{id}/activate", s.activateRequest)
+	s.mux.HandleFunc("GET /api/dead-letters", s.listDeadLetters)
+	s.mux.HandleFunc("GET /api/dead-letters/{id}", s.getDeadLetter)
+	s.mux.HandleFunc("POST /api/dead-letters/{id}/requeue", s.requeueDeadLetter)
+
+	return s
+}
+
+// Handle registers an additional handler on the server
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Handler returns the root handler of the server
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// ListenAndServe serves until ctx is cancelled
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			s.log.Warn("failed to shut down http server", zap.Error(err))
+		}
+	}()
+
+	s.log.Info("starting http server", zap.String("addr", s.addr))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.log.Warn("failed to write response", zap.Error(err))
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	s.writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+type deadLetterTrackResponse struct {
+	Artist         string `json:"artist"`
+	Title          string `json:"title"`
+	SpotifyURL     string `json:"spotify_url"`
+	FailedAttempts int    `json:"failed_attempts"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+type deadLetterResponse struct {
+	RequestID  string                    `json:"request_id"`
+	SpotifyURL string                    `json:"spotify_url"`
+	Name       string                    `json:"name"`
+	ObjectType spotify.SpotifyObjectType `json:"object_type"`
+	CreatorID  int64                     `json:"creator_id"`
+	SyncCount  int                       `json:"sync_count"`
+	RetryCount int                       `json:"retry_count"`
+	Error      string                    `json:"error"`
+	CreatedAt  int64                     `json:"created_at"`
+
+	// OutputTail and SkippedTracks are only returned for a single dead letter
+	OutputTail    []string                  `json:"output_tail,omitempty"`
+	SkippedTracks []deadLetterTrackResponse `json:"skipped_tracks,omitempty"`
+}
+
+func newDeadLetterResponse(letter db.DeadLetter) deadLetterResponse {
+	return deadLetterResponse{
+		RequestID:  letter.RequestID,
+		SpotifyURL: letter.SpotifyURL,
+		Name:       letter.Name,
+		ObjectType: letter.ObjectType,
+		CreatorID:  letter.CreatorID,
+		SyncCount:  letter.SyncCount,
+		RetryCount: letter.RetryCount,
+		Error:      letter.Error,
+		CreatedAt:  letter.CreatedAt,
+	}
+}
+
+// withDetails adds the downloader output and the skipped tracks
+func (r deadLetterResponse) withDetails(letter db.DeadLetter) deadLetterResponse {
+	r.OutputTail = letter.OutputTail
+	r.SkippedTracks = make([]deadLetterTrackResponse, 0, len(letter.SkippedTracks))
+	for _, track := range letter.SkippedTracks {
+		r.SkippedTracks = append(r.SkippedTracks, deadLetterTrackResponse{
+			Artist:         track.Artist,
+			Title:          track.Title,
+			SpotifyURL:     track.SpotifyURL,
+			FailedAttempts: track.FailedAttempts,
+			Reason:         track.Reason,
+		})
+	}
+	return r
+}
+
+// listDeadLetters lists the requests that were given up, newest first
+func (s *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := s.database.ListDeadLetters(r.Context())
+	if err != nil {
+		s.log.Error("failed to list dead letters", zap.Error(err))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := make([]deadLetterResponse, 0, len(letters))
+	for _, letter := range letters {
+		response = append(response, newDeadLetterResponse(letter))
+	}
+
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// getDeadLetter returns a dead letter with the downloader output and the skipped tracks
+func (s *Server) getDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	letter, err := s.database.GetDeadLetter(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		s.log.Error("failed to get dead letter", zap.Error(err), zap.String("request_id", id))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, newDeadLetterResponse(letter).withDetails(letter))
+}
+
+// requeueDeadLetter puts a dead-lettered request back into the queue with fresh sync, retry and track budgets
+func (s *Server) requeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	request, err := db.Requeue(r.Context(), s.database, id)
+	if errors.Is(err, db.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		s.log.Error("failed to requeue dead letter", zap.Error(err), zap.String("request_id", id))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.log.Info("requeued dead letter via api", zap.String("request_id", id))
+	s.writeJSON(w, http.StatusOK, newRequestResponse(request))
+}
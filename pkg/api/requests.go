@@ -0,0 +1,316 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/spotdl"
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+type createRequestBody struct {
+	URL       string `json:"url"`
+	Name      string `json:"name"`
+	CreatorID int64  `json:"creator_id"`
+}
+
+type trackResponse struct {
+	Artist         string `json:"artist"`
+	Title          string `json:"title"`
+	SpotifyURL     string `json:"spotify_url"`
+	Found          bool   `json:"found"`
+	Skipped        bool   `json:"skipped"`
+	FailedAttempts int    `json:"failed_attempts"`
+
+	// LastResult and FailureReason come from the downloader output of the last attempt
+	LastResult    string `json:"last_result,omitempty"`
+	FailureReason string `json:"failure_reason,omitempty"`
+}
+
+type requestResponse struct {
+	ID                 string                    `json:"id"`
+	SpotifyURL         string                    `json:"spotify_url"`
+	Name               string                    `json:"name"`
+	ObjectType         spotify.SpotifyObjectType `json:"object_type"`
+	CreatorID          int64                     `json:"creator_id"`
+	Active             bool                      `json:"active"`
+	Errored            bool                      `json:"errored"`
+	SyncCount          int                       `json:"sync_count"`
+	RetryCount         int                       `json:"retry_count"`
+	ExpectedTrackCount int                       `json:"expected_track_count"`
+	FoundTrackCount    int                       `json:"found_track_count"`
+	CreatedAt          int64                     `json:"created_at"`
+	UpdatedAt          int64                     `json:"updated_at"`
+
+	LastOutcome   db.RequestOutcome `json:"last_outcome,omitempty"`
+	LastError     string            `json:"last_error,omitempty"`
+	LastAttemptAt int64             `json:"last_attempt_at,omitempty"`
+	NextAttemptAt int64             `json:"next_attempt_at,omitempty"`
+
+	// LeaseOwner is the instance currently processing the request, LeaseExpiresAt is in unix milliseconds
+	LeaseOwner     string `json:"lease_owner,omitempty"`
+	LeaseExpiresAt int64  `json:"lease_expires_at,omitempty"`
+
+	Tracks []trackResponse `json:"tracks,omitempty"`
+}
+
+func newRequestResponse(request models.DownloadQueueRequest) requestResponse {
+	return requestResponse{
+		ID:                 request.ID,
+		SpotifyURL:         request.SpotifyURL,
+		Name:               request.Name,
+		ObjectType:         request.ObjectType,
+		CreatorID:          request.CreatorID,
+		Active:             request.Active,
+		Errored:            request.Errored,
+		SyncCount:          request.SyncCount,
+		RetryCount:         request.RetryCount,
+		ExpectedTrackCount: request.ExpectedTrackCount,
+		FoundTrackCount:    request.FoundTrackCount,
+		CreatedAt:          request.CreatedAt,
+		UpdatedAt:          request.UpdatedAt,
+	}
+}
+
+// withDetails adds the per track progress and the last processing outcome
+func (r requestResponse) withDetails(request models.DownloadQueueRequest, state db.RequestState) requestResponse {
+	r.LastOutcome = state.LastOutcome
+	r.LastError = state.LastError
+	r.LastAttemptAt = state.LastAttemptAt
+	r.NextAttemptAt = state.NextAttemptAt
+	r.LeaseOwner = state.LeaseOwner
+	r.LeaseExpiresAt = state.LeaseExpiresAt
+
+	results := make(map[string]db.TrackResult, len(state.TrackResults))
+	for _, result := range state.TrackResults {
+		results[result.SpotifyURL+"|"+result.Artist+"|"+result.Title] = result
+	}
+
+	r.Tracks = make([]trackResponse, 0, len(request.TrackMetadata))
+	for _, track := range request.TrackMetadata {
+		response := trackResponse{
+			Artist:         track.Artist,
+			Title:          track.Title,
+			SpotifyURL:     track.SpotifyURL,
+			Found:          track.Found,
+			Skipped:        track.Skipped,
+			FailedAttempts: track.FailedAttempts,
+		}
+		if result, ok := results[track.SpotifyURL+"|"+track.Artist+"|"+track.Title]; ok {
+			response.LastResult = result.Status
+			if (spotdl.Event{Kind: spotdl.EventKind(result.Status)}).Failed() {
+				response.FailureReason = result.Reason
+			}
+		}
+		r.Tracks = append(r.Tracks, response)
+	}
+	return r
+}
+
+// createRequest enqueues a download request for a Spotify URL
+func (s *Server) createRequest(w http.ResponseWriter, r *http.Request) {
+	var body createRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+		return
+	}
+
+	spotifyURL, err := normalizeSpotifyURL(body.URL)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	existing, err := s.database.GetActiveRequest(ctx, spotifyURL)
+	if err == nil {
+		s.writeJSON(w, http.StatusConflict, newRequestResponse(existing))
+		return
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		s.log.Error("failed to check for active request", zap.Error(err), zap.String("url", spotifyURL))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	objectType, err := s.spotifyService.GetObjectType(ctx, spotifyURL)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("failed to detect object type: %w", err))
+		return
+	}
+
+	name := body.Name
+	if name == "" {
+		name, err = s.spotifyService.GetObjectName(ctx, spotifyURL)
+		if err != nil {
+			s.log.Warn("failed to get object name", zap.Error(err), zap.String("url", spotifyURL))
+			name = spotifyURL
+		}
+	}
+
+	if err := s.database.NewDownloadRequest(ctx, spotifyURL, name, body.CreatorID, objectType); err != nil {
+		s.log.Error("failed to create download request", zap.Error(err), zap.String("url", spotifyURL))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	created, err := s.database.GetActiveRequest(ctx, spotifyURL)
+	if err != nil {
+		s.log.Error("failed to fetch created request", zap.Error(err), zap.String("url", spotifyURL))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.log.Info("created download request via api", zap.String("url", spotifyURL), zap.String("object_type", string(objectType)))
+	s.writeJSON(w, http.StatusCreated, newRequestResponse(created))
+}
+
+// listRequests lists requests, optionally filtered by ?status=active|completed|errored
+func (s *Server) listRequests(w http.ResponseWriter, r *http.Request) {
+	status := db.RequestStatus(r.URL.Query().Get("status"))
+	switch status {
+	case db.RequestStatusAll, db.RequestStatusActive, db.RequestStatusCompleted, db.RequestStatusErrored:
+	default:
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("unknown status %q", status))
+		return
+	}
+
+	requests, err := s.database.ListRequests(r.Context(), status)
+	if err != nil {
+		s.log.Error("failed to list requests", zap.Error(err))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := make([]requestResponse, 0, len(requests))
+	for _, request := range requests {
+		response = append(response, newRequestResponse(request))
+	}
+
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// getRequest returns a single request with its track progress
+func (s *Server) getRequest(w http.ResponseWriter, r *http.Request) {
+	request, ok := s.lookupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := s.database.GetRequestState(r.Context(), request.ID)
+	if err != nil {
+		s.log.Error("failed to get request state", zap.Error(err), zap.String("request_id", request.ID))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, newRequestResponse(request).withDetails(request, state))
+}
+
+// cancelRequest deactivates a request so it is not synced again
+func (s *Server) cancelRequest(w http.ResponseWriter, r *http.Request) {
+	request, ok := s.lookupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	request.Active = false
+	s.saveRequest(w, r, request)
+}
+
+// activateRequest puts a finished or cancelled request back into the queue with a fresh sync budget.
+// A dead-lettered request is requeued, so its dead letter goes away with it.
+func (s *Server) activateRequest(w http.ResponseWriter, r *http.Request) {
+	request, ok := s.lookupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	existing, err := s.database.GetActiveRequest(r.Context(), request.SpotifyURL)
+	if err == nil && existing.ID != request.ID {
+		s.writeJSON(w, http.StatusConflict, newRequestResponse(existing))
+		return
+	}
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		s.log.Error("failed to check for active request", zap.Error(err), zap.String("url", request.SpotifyURL))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = s.database.GetDeadLetter(r.Context(), request.ID)
+	if err == nil {
+		requeued, err := db.Requeue(r.Context(), s.database, request.ID)
+		if err != nil {
+			s.log.Error("failed to requeue dead letter", zap.Error(err), zap.String("request_id", request.ID))
+			s.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.log.Info("requeued dead letter via api", zap.String("request_id", request.ID))
+		s.writeJSON(w, http.StatusOK, newRequestResponse(requeued))
+		return
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		s.log.Error("failed to get dead letter", zap.Error(err), zap.String("request_id", request.ID))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	request = db.Reactivate(request)
+	if err := s.database.SetNextAttempt(r.Context(), request.ID, time.Time{}); err != nil {
+		s.log.Error("failed to clear next attempt", zap.Error(err), zap.String("request_id", request.ID))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.saveRequest(w, r, request)
+}
+
+func (s *Server) lookupRequest(w http.ResponseWriter, r *http.Request) (models.DownloadQueueRequest, bool) {
+	id := r.PathValue("id")
+	request, err := s.database.GetRequest(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, err)
+		return models.DownloadQueueRequest{}, false
+	}
+	if err != nil {
+		s.log.Error("failed to get request", zap.Error(err), zap.String("request_id", id))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return models.DownloadQueueRequest{}, false
+	}
+
+	return request, true
+}
+
+func (s *Server) saveRequest(w http.ResponseWriter, r *http.Request, request models.DownloadQueueRequest) {
+	request.UpdatedAt = time.Now().Unix()
+	if err := s.database.UpdateActiveRequest(r.Context(), request); err != nil {
+		s.log.Error("failed to update request", zap.Error(err), zap.String("request_id", request.ID))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.log.Info("updated request via api", zap.String("request_id", request.ID), zap.Bool("active", request.Active))
+	s.writeJSON(w, http.StatusOK, newRequestResponse(request))
+}
+
+// normalizeSpotifyURL validates the url and strips tracking query parameters like ?si=
+func normalizeSpotifyURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host != "open.spotify.com" {
+		return "", fmt.Errorf("not a spotify url: %q", raw)
+	}
+
+	u.Scheme = "https"
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+const testURL = "https://open.spotify.com/album/1"
+
+// fakeSpotify answers the lookups of createRequest
+type fakeSpotify struct {
+	spotify.SpotifyService
+}
+
+func (f *fakeSpotify) GetObjectType(ctx context.Context, url string) (spotify.SpotifyObjectType, error) {
+	return spotify.SpotifyObjectTypeAlbum, nil
+}
+
+func (f *fakeSpotify) GetObjectName(ctx context.Context, url string) (string, error) {
+	return "Album", nil
+}
+
+func newTestServer() (*Server, db.Database) {
+	database := db.NewMemoryDatabase()
+	return NewServer(database, &fakeSpotify{}, zap.NewNop(), ""), database
+}
+
+// do sends a request to the server and decodes a JSON response into out
+func do(t *testing.T, srv *Server, method, path, body string, out any) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if out != nil {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestCreateRequest(t *testing.T) {
+	srv, _ := newTestServer()
+
+	var created requestResponse
+	if code := do(t, srv, http.MethodPost, "/api/requests", `{"url": "`+testURL+`?si=abc"}`, &created); code != http.StatusCreated {
+		t.Fatalf("create returned %d, want %d", code, http.StatusCreated)
+	}
+	if created.SpotifyURL != testURL || created.Name != "Album" || !created.Active {
+		t.Errorf("unexpected created request %+v", created)
+	}
+
+	var existing requestResponse
+	if code := do(t, srv, http.MethodPost, "/api/requests", `{"url": "`+testURL+`"}`, &existing); code != http.StatusConflict {
+		t.Fatalf("duplicate create returned %d, want %d", code, http.StatusConflict)
+	}
+	if existing.ID != created.ID {
+		t.Errorf("conflict returned request %s, want %s", existing.ID, created.ID)
+	}
+}
+
+func TestCreateRequest_BadInput(t *testing.T) {
+	srv, _ := newTestServer()
+
+	for _, body := range []string{`not json`, `{"url": ""}`, `{"url": "https://example.com/album/1"}`} {
+		var response errorResponse
+		if code := do(t, srv, http.MethodPost, "/api/requests", body, &response); code != http.StatusBadRequest {
+			t.Errorf("create with %s returned %d, want %d", body, code, http.StatusBadRequest)
+		}
+		if response.Error == "" {
+			t.Errorf("create with %s returned no error message", body)
+		}
+	}
+}
+
+func TestGetRequest(t *testing.T) {
+	srv, database := newTestServer()
+	ctx := context.Background()
+
+	if err := database.NewDownloadRequest(ctx, testURL, "Album", 1, spotify.SpotifyObjectTypeAlbum); err != nil {
+		t.Fatalf("NewDownloadRequest failed: %v", err)
+	}
+	request, err := database.GetActiveRequest(ctx, testURL)
+	if err != nil {
+		t.Fatalf("GetActiveRequest failed: %v", err)
+	}
+	request.TrackMetadata = []spotify.TrackMetadata{{Artist: "Artist", Title: "Song", Found: true}}
+	if err := database.UpdateActiveRequest(ctx, request); err != nil {
+		t.Fatalf("UpdateActiveRequest failed: %v", err)
+	}
+
+	var got requestResponse
+	if code := do(t, srv, http.MethodGet, "/api/requests/"+request.ID, "", &got); code != http.StatusOK {
+		t.Fatalf("get returned %d, want %d", code, http.StatusOK)
+	}
+	if got.ID != request.ID || len(got.Tracks) != 1 || !got.Tracks[0].Found {
+		t.Errorf("unexpected request %+v", got)
+	}
+
+	if code := do(t, srv, http.MethodGet, "/api/requests/missing", "", nil); code != http.StatusNotFound {
+		t.Errorf("get of a missing request returned %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestCancelAndActivateRequest(t *testing.T) {
+	srv, database := newTestServer()
+	ctx := context.Background()
+
+	if err := database.NewDownloadRequest(ctx, testURL, "Album", 1, spotify.SpotifyObjectTypeAlbum); err != nil {
+		t.Fatalf("NewDownloadRequest failed: %v", err)
+	}
+	request, err := database.GetActiveRequest(ctx, testURL)
+	if err != nil {
+		t.Fatalf("GetActiveRequest failed: %v", err)
+	}
+
+	var cancelled requestResponse
+	if code := do(t, srv, http.MethodPost, "/api/requests/"+request.ID+"/cancel", "", &cancelled); code != http.StatusOK {
+		t.Fatalf("cancel returned %d, want %d", code, http.StatusOK)
+	}
+	if cancelled.Active {
+		t.Fatal("expected the cancelled request to be inactive")
+	}
+
+	// a given-up request with a skipped track and a dead letter
+	request.Active = false
+	request.Errored = true
+	request.SyncCount = 3
+	request.TrackMetadata = []spotify.TrackMetadata{
+		{Artist: "Artist", Title: "Found", Found: true},
+		{Artist: "Artist", Title: "Missing", Skipped: true, FailedAttempts: 3},
+	}
+	if err := database.UpdateActiveRequest(ctx, request); err != nil {
+		t.Fatalf("UpdateActiveRequest failed: %v", err)
+	}
+	if err := database.AddDeadLetter(ctx, db.DeadLetter{RequestID: request.ID, SpotifyURL: testURL}); err != nil {
+		t.Fatalf("AddDeadLetter failed: %v", err)
+	}
+
+	var activated requestResponse
+	if code := do(t, srv, http.MethodPost, "/api/requests/"+request.ID+"/activate", "", &activated); code != http.StatusOK {
+		t.Fatalf("activate returned %d, want %d", code, http.StatusOK)
+	}
+	if !activated.Active || activated.Errored || activated.SyncCount != 0 {
+		t.Errorf("unexpected activated request %+v", activated)
+	}
+
+	got, err := database.GetRequest(ctx, request.ID)
+	if err != nil {
+		t.Fatalf("GetRequest failed: %v", err)
+	}
+	if missing := got.TrackMetadata[1]; missing.Skipped || missing.FailedAttempts != 0 {
+		t.Errorf("expected the missing track to get a fresh budget, got %+v", missing)
+	}
+	if _, err := database.GetDeadLetter(ctx, request.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expected the dead letter to be dropped, got %v", err)
+	}
+}
+
+func TestActivateRequest_Conflict(t *testing.T) {
+	srv, database := newTestServer()
+	ctx := context.Background()
+
+	if err := database.NewDownloadRequest(ctx, testURL, "Album", 1, spotify.SpotifyObjectTypeAlbum); err != nil {
+		t.Fatalf("NewDownloadRequest failed: %v", err)
+	}
+	old, err := database.GetActiveRequest(ctx, testURL)
+	if err != nil {
+		t.Fatalf("GetActiveRequest failed: %v", err)
+	}
+	old.Active = false
+	if err := database.UpdateActiveRequest(ctx, old); err != nil {
+		t.Fatalf("UpdateActiveRequest failed: %v", err)
+	}
+
+	var created requestResponse
+	if code := do(t, srv, http.MethodPost, "/api/requests", `{"url": "`+testURL+`"}`, &created); code != http.StatusCreated {
+		t.Fatalf("create returned %d, want %d", code, http.StatusCreated)
+	}
+
+	var existing requestResponse
+	if code := do(t, srv, http.MethodPost, "/api/requests/"+old.ID+"/activate", "", &existing); code != http.StatusConflict {
+		t.Fatalf("activate returned %d, want %d", code, http.StatusConflict)
+	}
+	if existing.ID != created.ID {
+		t.Errorf("conflict returned request %s, want %s", existing.ID, created.ID)
+	}
+
+	got, err := database.GetRequest(ctx, old.ID)
+	if err != nil {
+		t.Fatalf("GetRequest failed: %v", err)
+	}
+	if got.Active {
+		t.Error("expected the old request to stay inactive")
+	}
+}
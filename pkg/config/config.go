@@ -1,6 +1,11 @@
 package config
 
-import "github.com/kelseyhightower/envconfig"
+import (
+	"errors"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
 
 type SpotifyConfig struct {
 	ClientID     string `envconfig:"SPOTIFY_CLIENT_ID" required:"true"`
@@ -12,12 +17,148 @@ type LokiConfig struct {
 	URL     string `envconfig:"LOKI_URL"`
 }
 
+type SchedulerConfig struct {
+	ProcessInterval     time.Duration `envconfig:"PROCESS_INTERVAL" default:"5m"`
+	ShutdownGracePeriod time.Duration `envconfig:"SHUTDOWN_GRACE_PERIOD" default:"2m"`
+	// InstanceID names this instance in request leases, empty uses hostname and pid
+	InstanceID string `envconfig:"INSTANCE_ID"`
+	// LeaseDuration is how long a claimed request stays with an instance that stopped renewing it
+	LeaseDuration time.Duration `envconfig:"LEASE_DURATION" default:"5m"`
+	// WatchQueues starts a pass as soon as a request is queued, it needs a MongoDB replica set
+	WatchQueues bool `envconfig:"WATCH_QUEUES" default:"false"`
+}
+
+type DownloadConfig struct {
+	// Backend is the downloader to use, "spotdl" or "yt-dlp"
+	Backend      string        `envconfig:"DOWNLOADER" default:"spotdl"`
+	TrackTimeout time.Duration `envconfig:"TRACK_DOWNLOAD_TIMEOUT" default:"15m"`
+	BulkTimeout  time.Duration `envconfig:"BULK_DOWNLOAD_TIMEOUT" default:"2h"`
+	Workers      int           `envconfig:"DOWNLOAD_WORKERS" default:"1"`
+}
+
+// RetryConfig schedules failed requests with exponential backoff
+type RetryConfig struct {
+	BaseDelay time.Duration `envconfig:"RETRY_BASE_DELAY" default:"10m"`
+	MaxDelay  time.Duration `envconfig:"RETRY_MAX_DELAY" default:"12h"`
+	// The failed attempts per download request object type before the request is given up
+	TrackAttempts    int `envconfig:"RETRY_MAX_ATTEMPTS_TRACK" default:"5"`
+	AlbumAttempts    int `envconfig:"RETRY_MAX_ATTEMPTS_ALBUM" default:"5"`
+	PlaylistAttempts int `envconfig:"RETRY_MAX_ATTEMPTS_PLAYLIST" default:"5"`
+	// PlaylistFileAttempts are the failed attempts to generate a playlist file
+	PlaylistFileAttempts int `envconfig:"RETRY_MAX_ATTEMPTS_PLAYLIST_FILE" default:"5"`
+}
+
+type HTTPConfig struct {
+	Enabled bool   `envconfig:"HTTP_ENABLED" default:"false"`
+	Addr    string `envconfig:"HTTP_ADDR" default:":8080"`
+}
+
+type HealthConfig struct {
+	// MaxTickAge is how long the processing loop may go without progress before liveness fails
+	MaxTickAge time.Duration `envconfig:"HEALTH_MAX_TICK_AGE" default:"3h"`
+}
+
+type IndexerConfig struct {
+	// Enabled indexes MUSIC_LIBRARY_PATH after every pass and downloaded files right away
+	Enabled bool `envconfig:"INDEXER_ENABLED" default:"true"`
+	// Watch indexes changes from filesystem events instead of rescanning after every pass
+	Watch    bool          `envconfig:"INDEXER_WATCH" default:"true"`
+	Debounce time.Duration `envconfig:"INDEXER_DEBOUNCE" default:"10s"`
+}
+
+type MatchConfig struct {
+	// Threshold is the minimum similarity score in [0, 1] for a library file to match a track
+	Threshold         float64       `envconfig:"MATCH_THRESHOLD" default:"0.85"`
+	DurationTolerance time.Duration `envconfig:"MATCH_DURATION_TOLERANCE" default:"5s"`
+}
+
+type PlaylistConfig struct {
+	// PathMode is how library paths are written into playlists: absolute, prefix or relative
+	PathMode string `envconfig:"PLAYLIST_PATH_MODE" default:"prefix"`
+	// PathPrefixes are the "from=to" rewrites of the prefix mode, tried in order
+	PathPrefixes []string `envconfig:"PLAYLIST_PATH_PREFIXES" default:"/mnt/music=/music"`
+	// ResyncInterval is how often synced playlists are refreshed, 0 disables refreshing
+	ResyncInterval time.Duration `envconfig:"PLAYLIST_RESYNC_INTERVAL" default:"0"`
+	// Formats are the playlist file formats written when a request doesn't ask for its own: m3u8, xspf, pls, json
+	Formats []string `envconfig:"PLAYLIST_FORMATS" default:"m3u8"`
+}
+
+type SubsonicConfig struct {
+	// Enabled pushes generated playlists to a Subsonic compatible server like Navidrome
+	Enabled  bool   `envconfig:"SUBSONIC_ENABLED" default:"false"`
+	URL      string `envconfig:"SUBSONIC_URL"`
+	Username string `envconfig:"SUBSONIC_USERNAME"`
+	// Password is kept out of the logged config
+	Password string        `envconfig:"SUBSONIC_PASSWORD" json:"-"`
+	Timeout  time.Duration `envconfig:"SUBSONIC_TIMEOUT" default:"30s"`
+}
+
+// RescanConfig configures the media servers rescanned after downloads, a server is enabled by its URL
+type RescanConfig struct {
+	Timeout time.Duration `envconfig:"RESCAN_TIMEOUT" default:"30s"`
+
+	JellyfinURL      string        `envconfig:"JELLYFIN_URL"`
+	JellyfinAPIKey   string        `envconfig:"JELLYFIN_API_KEY" json:"-"`
+	JellyfinDebounce time.Duration `envconfig:"JELLYFIN_RESCAN_DEBOUNCE" default:"2m"`
+
+	NavidromeURL      string        `envconfig:"NAVIDROME_URL"`
+	NavidromeUsername string        `envconfig:"NAVIDROME_USERNAME"`
+	NavidromePassword string        `envconfig:"NAVIDROME_PASSWORD" json:"-"`
+	NavidromeDebounce time.Duration `envconfig:"NAVIDROME_RESCAN_DEBOUNCE" default:"2m"`
+
+	PlexURL      string        `envconfig:"PLEX_URL"`
+	PlexToken    string        `envconfig:"PLEX_TOKEN" json:"-"`
+	PlexSection  string        `envconfig:"PLEX_SECTION"`
+	PlexDebounce time.Duration `envconfig:"PLEX_RESCAN_DEBOUNCE" default:"2m"`
+}
+
+type EventsConfig struct {
+	// WebhookURLs receive every lifecycle event as a JSON POST
+	WebhookURLs []string `envconfig:"EVENT_WEBHOOK_URLS"`
+	// WebhookSecret signs the webhook bodies, kept out of the logged config
+	WebhookSecret   string        `envconfig:"EVENT_WEBHOOK_SECRET" json:"-"`
+	WebhookAttempts int           `envconfig:"EVENT_WEBHOOK_ATTEMPTS" default:"5"`
+	WebhookTimeout  time.Duration `envconfig:"EVENT_WEBHOOK_TIMEOUT" default:"10s"`
+	// HookCommands are executables run per event with the event JSON on stdin
+	HookCommands []string      `envconfig:"EVENT_HOOK_COMMANDS"`
+	HookTimeout  time.Duration `envconfig:"EVENT_HOOK_TIMEOUT" default:"30s"`
+	// BufferSize is the number of events queued per sink before new ones are dropped
+	BufferSize int `envconfig:"EVENT_BUFFER_SIZE" default:"100"`
+}
+
+type TelegramConfig struct {
+	// Enabled messages request creators, their CreatorID is the Telegram chat id
+	Enabled bool `envconfig:"TELEGRAM_ENABLED" default:"false"`
+	// BotToken is kept out of the logged config
+	BotToken string `envconfig:"TELEGRAM_BOT_TOKEN" json:"-"`
+	APIURL   string `envconfig:"TELEGRAM_API_URL" default:"https://api.telegram.org"`
+	// AdminChatID receives the daily digest, 0 disables it
+	AdminChatID int64         `envconfig:"TELEGRAM_ADMIN_CHAT_ID" default:"0"`
+	DigestHour  int           `envconfig:"TELEGRAM_DIGEST_HOUR" default:"9"`
+	Timeout     time.Duration `envconfig:"TELEGRAM_TIMEOUT" default:"10s"`
+}
+
 type Config struct {
-	Spotify SpotifyConfig
-	Loki    LokiConfig
+	Spotify   SpotifyConfig
+	Loki      LokiConfig
+	Scheduler SchedulerConfig
+	Download  DownloadConfig
+	Retry     RetryConfig
+	HTTP      HTTPConfig
+	Health    HealthConfig
+	Indexer   IndexerConfig
+	Match     MatchConfig
+	Playlist  PlaylistConfig
+	Subsonic  SubsonicConfig
+	Rescan    RescanConfig
+	Events    EventsConfig
+	Telegram  TelegramConfig
 
-	DatabaseURL      string `envconfig:"DATABASE_URL" required:"true"`
-	DatabaseName     string `envconfig:"DATABASE_NAME" required:"true"`
+	// DatabaseDriver is mongo, sqlite or memory
+	DatabaseDriver string `envconfig:"DATABASE_DRIVER" default:"mongo"`
+	// DatabaseURL is the Mongo connection string or the SQLite file path
+	DatabaseURL      string `envconfig:"DATABASE_URL"`
+	DatabaseName     string `envconfig:"DATABASE_NAME"`
 	Destination      string `envconfig:"DESTINATION" required:"true"`
 	MusicLibraryPath string `envconfig:"MUSIC_LIBRARY_PATH" required:"true"`
 	SleepInMinutes   int    `envconfig:"SLEEP_IN_MINUTES" required:"true"`
@@ -30,5 +171,24 @@ func NewConfig() (*Config, error) {
 		return nil, err
 	}
 
+	// both drive tickers, a non-positive duration would panic at runtime
+	if cfg.Scheduler.ProcessInterval <= 0 {
+		return nil, errors.New("PROCESS_INTERVAL must be positive")
+	}
+	if cfg.Scheduler.LeaseDuration <= 0 {
+		return nil, errors.New("LEASE_DURATION must be positive")
+	}
+
+	switch cfg.DatabaseDriver {
+	case "mongo":
+		if cfg.DatabaseURL == "" || cfg.DatabaseName == "" {
+			return nil, errors.New("DATABASE_URL and DATABASE_NAME are required for the mongo driver")
+		}
+	case "sqlite":
+		if cfg.DatabaseURL == "" {
+			return nil, errors.New("DATABASE_URL is required for the sqlite driver")
+		}
+	}
+
 	return cfg, nil
 }
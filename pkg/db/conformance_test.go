@@ -0,0 +1,725 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+// The conformance suite runs against every Database implementation, the Mongo one only
+// when MONGO_TEST_URL points at a server that may get throwaway databases.
+
+func TestMemoryDatabase(t *testing.T) {
+	runConformance(t, func(t *testing.T) Database {
+		return NewMemoryDatabase()
+	})
+}
+
+func TestSQLiteDatabase(t *testing.T) {
+	runConformance(t, func(t *testing.T) Database {
+		database, err := NewSQLiteDatabase(context.Background(), zap.NewNop(), filepath.Join(t.TempDir(), "spotdl.db"))
+		if err != nil {
+			t.Fatalf("NewSQLiteDatabase failed: %v", err)
+		}
+		t.Cleanup(func() { database.(*sqliteDatabase).conn.Close() })
+		return database
+	})
+}
+
+func TestSQLiteDatabase_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "spotdl.db")
+
+	first, err := NewSQLiteDatabase(ctx, zap.NewNop(), path)
+	if err != nil {
+		t.Fatalf("NewSQLiteDatabase failed: %v", err)
+	}
+	if err := first.NewDownloadRequest(ctx, "https://open.spotify.com/album/a", "A", 1, spotify.SpotifyObjectTypeAlbum); err != nil {
+		t.Fatalf("NewDownloadRequest failed: %v", err)
+	}
+	first.(*sqliteDatabase).conn.Close()
+
+	// migrations are not applied twice and the data survives
+	second, err := NewSQLiteDatabase(ctx, zap.NewNop(), path)
+	if err != nil {
+		t.Fatalf("reopening failed: %v", err)
+	}
+	defer second.(*sqliteDatabase).conn.Close()
+
+	if _, err := second.GetActiveRequest(ctx, "https://open.spotify.com/album/a"); err != nil {
+		t.Fatalf("request lost after reopening: %v", err)
+	}
+}
+
+func TestMongoDatabase(t *testing.T) {
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL not set")
+	}
+
+	runConformance(t, func(t *testing.T) Database {
+		ctx := context.Background()
+		name := "spotdl_conformance_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+		database, err := NewDatabase(ctx, zap.NewNop(), url, name)
+		if err != nil {
+			t.Fatalf("NewDatabase failed: %v", err)
+		}
+		t.Cleanup(func() {
+			conn := database.(*db).conn
+			_ = conn.Database(name).Drop(ctx)
+			_ = conn.Disconnect(ctx)
+		})
+		return database
+	})
+}
+
+func runConformance(t *testing.T, open func(t *testing.T) Database) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, database Database)
+	}{
+		{"DownloadRequests", testDownloadRequests},
+		{"ListRequests", testListRequests},
+		{"RequestState", testRequestState},
+		{"Leases", testLeases},
+		{"ExpiredLeases", testExpiredLeases},
+		{"NextAttempt", testNextAttempt},
+		{"DeadLetters", testDeadLetters},
+		{"Playlists", testPlaylists},
+		{"Library", testLibrary},
+		{"IndexStatus", testIndexStatus},
+		{"NotFound", testNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, open(t))
+		})
+	}
+}
+
+// newRequest queues a download request and returns it
+func newRequest(t *testing.T, database Database, url string) models.DownloadQueueRequest {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := database.NewDownloadRequest(ctx, url, "name of "+url, 42, spotify.SpotifyObjectTypePlaylist); err != nil {
+		t.Fatalf("NewDownloadRequest failed: %v", err)
+	}
+	request, err := database.GetActiveRequest(ctx, url)
+	if err != nil {
+		t.Fatalf("GetActiveRequest failed: %v", err)
+	}
+	return request
+}
+
+func testDownloadRequests(t *testing.T, database Database) {
+	ctx := context.Background()
+	url := "https://open.spotify.com/playlist/p1"
+
+	request := newRequest(t, database, url)
+	if request.ID == "" || !request.Active || request.Name != "name of "+url || request.CreatorID != 42 ||
+		request.ObjectType != spotify.SpotifyObjectTypePlaylist || request.CreatedAt == 0 {
+		t.Fatalf("unexpected new request %+v", request)
+	}
+
+	request.SyncCount = 2
+	request.RetryCount = 1
+	request.Errored = true
+	request.ExpectedTrackCount = 2
+	request.FoundTrackCount = 1
+	request.UpdatedAt = 1760000000
+	request.TrackMetadata = []spotify.TrackMetadata{
+		{Artist: "Björk", Title: "Jóga", SpotifyURL: "https://open.spotify.com/track/1", Found: true},
+		{Artist: "Björk", Title: "Bachelorette", SpotifyURL: "https://open.spotify.com/track/2", FailedAttempts: 2},
+	}
+	if err := database.UpdateActiveRequest(ctx, request); err != nil {
+		t.Fatalf("UpdateActiveRequest failed: %v", err)
+	}
+
+	got, err := database.GetRequest(ctx, request.ID)
+	if err != nil {
+		t.Fatalf("GetRequest failed: %v", err)
+	}
+	if got.SyncCount != 2 || got.RetryCount != 1 || !got.Errored || got.ExpectedTrackCount != 2 || got.FoundTrackCount != 1 ||
+		got.UpdatedAt != 1760000000 || !slices.Equal(got.TrackMetadata, request.TrackMetadata) {
+		t.Errorf("update not persisted, got %+v", got)
+	}
+
+	active, err := database.GetActiveRequests(ctx)
+	if err != nil || len(active) != 1 || active[0].ID != request.ID {
+		t.Fatalf("GetActiveRequests = %v, %v", active, err)
+	}
+
+	synced, err := database.CheckIfRequestAlreadySynced(ctx, url)
+	if err != nil || synced {
+		t.Errorf("active request reported as synced: %v, %v", synced, err)
+	}
+
+	request.Active = false
+	if err := database.UpdateActiveRequest(ctx, request); err != nil {
+		t.Fatalf("UpdateActiveRequest failed: %v", err)
+	}
+	if _, err := database.GetActiveRequest(ctx, url); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a deactivated request, got %v", err)
+	}
+	synced, err = database.CheckIfRequestAlreadySynced(ctx, url)
+	if err != nil || !synced {
+		t.Errorf("deactivated request not reported as synced: %v, %v", synced, err)
+	}
+	if active, err := database.GetActiveRequests(ctx); err != nil || len(active) != 0 {
+		t.Errorf("expected no active requests, got %v, %v", active, err)
+	}
+}
+
+func testListRequests(t *testing.T, database Database) {
+	ctx := context.Background()
+
+	active := newRequest(t, database, "https://open.spotify.com/album/active")
+	completed := newRequest(t, database, "https://open.spotify.com/album/completed")
+	errored := newRequest(t, database, "https://open.spotify.com/album/errored")
+
+	completed.Active = false
+	errored.Active = false
+	errored.Errored = true
+	for _, request := range []models.DownloadQueueRequest{completed, errored} {
+		if err := database.UpdateActiveRequest(ctx, request); err != nil {
+			t.Fatalf("UpdateActiveRequest failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		status RequestStatus
+		want   []string
+	}{
+		{RequestStatusAll, []string{active.ID, completed.ID, errored.ID}},
+		{RequestStatusActive, []string{active.ID}},
+		{RequestStatusCompleted, []string{completed.ID}},
+		{RequestStatusErrored, []string{errored.ID}},
+	}
+
+	for _, tt := range tests {
+		requests, err := database.ListRequests(ctx, tt.status)
+		if err != nil {
+			t.Fatalf("ListRequests(%q) failed: %v", tt.status, err)
+		}
+
+		ids := make([]string, 0, len(requests))
+		for i, request := range requests {
+			ids = append(ids, request.ID)
+			if i > 0 && requests[i-1].CreatedAt < request.CreatedAt {
+				t.Errorf("ListRequests(%q) is not sorted newest first", tt.status)
+			}
+		}
+		sort.Strings(ids)
+		sort.Strings(tt.want)
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("ListRequests(%q) = %v, want %v", tt.status, ids, tt.want)
+		}
+	}
+}
+
+func testRequestState(t *testing.T, database Database) {
+	ctx := context.Background()
+	request := newRequest(t, database, "https://open.spotify.com/album/state")
+
+	state, err := database.GetRequestState(ctx, request.ID)
+	if err != nil {
+		t.Fatalf("GetRequestState failed: %v", err)
+	}
+	if state.LastOutcome != "" || len(state.TrackResults) != 0 {
+		t.Errorf("expected an empty state, got %+v", state)
+	}
+
+	if err := database.SetRequestOutcome(ctx, request.ID, RequestOutcomeFailed, "exit status 1"); err != nil {
+		t.Fatalf("SetRequestOutcome failed: %v", err)
+	}
+	results := []TrackResult{
+		{SpotifyURL: "https://open.spotify.com/track/1", Artist: "Björk", Title: "Jóga", Status: "downloaded", UpdatedAt: 1},
+		{SpotifyURL: "https://open.spotify.com/track/2", Artist: "Björk", Title: "Hunter", Status: "no_match", Reason: "no results", UpdatedAt: 2},
+	}
+	if err := database.SetTrackResults(ctx, request.ID, results); err != nil {
+		t.Fatalf("SetTrackResults failed: %v", err)
+	}
+
+	state, err = database.GetRequestState(ctx, request.ID)
+	if err != nil {
+		t.Fatalf("GetRequestState failed: %v", err)
+	}
+	if state.LastOutcome != RequestOutcomeFailed || state.LastError != "exit status 1" || state.LastAttemptAt == 0 {
+		t.Errorf("outcome not persisted, got %+v", state)
+	}
+	if !slices.Equal(state.TrackResults, results) {
+		t.Errorf("track results = %+v, want %+v", state.TrackResults, results)
+	}
+
+	// the state is kept when the shared fields are updated
+	request.SyncCount++
+	if err := database.UpdateActiveRequest(ctx, request); err != nil {
+		t.Fatalf("UpdateActiveRequest failed: %v", err)
+	}
+	if state, err := database.GetRequestState(ctx, request.ID); err != nil || state.LastOutcome != RequestOutcomeFailed {
+		t.Errorf("state lost on update: %+v, %v", state, err)
+	}
+}
+
+func testLeases(t *testing.T, database Database) {
+	ctx := context.Background()
+
+	first := newRequest(t, database, "https://open.spotify.com/album/first")
+	errored := newRequest(t, database, "https://open.spotify.com/album/errored")
+	done := newRequest(t, database, "https://open.spotify.com/album/done")
+	errored.Errored = true
+	done.Active = false
+	for _, request := range []models.DownloadQueueRequest{errored, done} {
+		if err := database.UpdateActiveRequest(ctx, request); err != nil {
+			t.Fatalf("UpdateActiveRequest failed: %v", err)
+		}
+	}
+
+	passStarted := time.Now()
+
+	// errored requests are claimed last, inactive ones never
+	claimed, err := database.ClaimNextRequest(ctx, "a", time.Minute, passStarted)
+	if err != nil || claimed.ID != first.ID {
+		t.Fatalf("first claim = %+v, %v, want %s", claimed, err, first.ID)
+	}
+	claimed, err = database.ClaimNextRequest(ctx, "b", time.Minute, passStarted)
+	if err != nil || claimed.ID != errored.ID {
+		t.Fatalf("second claim = %+v, %v, want %s", claimed, err, errored.ID)
+	}
+	if _, err := database.ClaimNextRequest(ctx, "c", time.Minute, passStarted); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound with everything leased, got %v", err)
+	}
+
+	state, err := database.GetRequestState(ctx, first.ID)
+	if err != nil || state.LeaseOwner != "a" || state.LeaseExpiresAt <= time.Now().UnixMilli() || state.ClaimedAt == 0 {
+		t.Fatalf("unexpected lease state %+v, %v", state, err)
+	}
+
+	if err := database.RenewLease(ctx, first.ID, "a", time.Hour); err != nil {
+		t.Fatalf("RenewLease failed: %v", err)
+	}
+	if renewed, err := database.GetRequestState(ctx, first.ID); err != nil || renewed.LeaseExpiresAt <= state.LeaseExpiresAt {
+		t.Errorf("lease not extended, %d -> %d, %v", state.LeaseExpiresAt, renewed.LeaseExpiresAt, err)
+	}
+	if err := database.RenewLease(ctx, first.ID, "b", time.Hour); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost renewing a foreign lease, got %v", err)
+	}
+	if err := database.ReleaseRequest(ctx, first.ID, "b"); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost releasing a foreign lease, got %v", err)
+	}
+
+	if err := database.ReleaseRequest(ctx, first.ID, "a"); err != nil {
+		t.Fatalf("ReleaseRequest failed: %v", err)
+	}
+	if state, err := database.GetRequestState(ctx, first.ID); err != nil || state.LeaseOwner != "" || state.LeaseExpiresAt != 0 {
+		t.Errorf("lease not released, %+v, %v", state, err)
+	}
+	if err := database.RenewLease(ctx, first.ID, "a", time.Hour); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost renewing a released lease, got %v", err)
+	}
+
+	// a released request isn't claimed again in the same pass, but in the next one
+	if _, err := database.ClaimNextRequest(ctx, "a", time.Minute, passStarted); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a request claimed in this pass, got %v", err)
+	}
+	claimed, err = database.ClaimNextRequest(ctx, "a", time.Minute, time.Now().Add(time.Second))
+	if err != nil || claimed.ID != first.ID {
+		t.Errorf("claim in the next pass = %+v, %v, want %s", claimed, err, first.ID)
+	}
+}
+
+func testExpiredLeases(t *testing.T, database Database) {
+	ctx := context.Background()
+	request := newRequest(t, database, "https://open.spotify.com/album/crashed")
+
+	if _, err := database.ClaimNextRequest(ctx, "crashed", 50*time.Millisecond, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("ClaimNextRequest failed: %v", err)
+	}
+	if n, err := database.ReleaseExpiredLeases(ctx); err != nil || n != 0 {
+		t.Errorf("ReleaseExpiredLeases released a live lease: %d, %v", n, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// an expired lease can be claimed right away, before it was released
+	claimed, err := database.ClaimNextRequest(ctx, "other", time.Minute, time.Now().Add(time.Hour))
+	if err != nil || claimed.ID != request.ID {
+		t.Fatalf("claim of an expired lease = %+v, %v", claimed, err)
+	}
+	if err := database.RenewLease(ctx, request.ID, "crashed", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost for the previous owner, got %v", err)
+	}
+
+	if err := database.RenewLease(ctx, request.ID, "other", -time.Second); err != nil {
+		t.Fatalf("RenewLease failed: %v", err)
+	}
+	if n, err := database.ReleaseExpiredLeases(ctx); err != nil || n != 1 {
+		t.Errorf("ReleaseExpiredLeases = %d, %v", n, err)
+	}
+	if state, err := database.GetRequestState(ctx, request.ID); err != nil || state.LeaseOwner != "" {
+		t.Errorf("expired lease not released, %+v, %v", state, err)
+	}
+}
+
+func testNextAttempt(t *testing.T, database Database) {
+	ctx := context.Background()
+	request := newRequest(t, database, "https://open.spotify.com/album/backoff")
+	later := time.Now().Add(time.Hour)
+
+	if err := database.SetNextAttempt(ctx, request.ID, later); err != nil {
+		t.Fatalf("SetNextAttempt failed: %v", err)
+	}
+	if state, err := database.GetRequestState(ctx, request.ID); err != nil || state.NextAttemptAt != later.Unix() {
+		t.Fatalf("next attempt = %+v, %v, want %d", state, err, later.Unix())
+	}
+	if _, err := database.ClaimNextRequest(ctx, "a", time.Minute, time.Now()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a waiting request not to be claimed, got %v", err)
+	}
+
+	if err := database.SetNextAttempt(ctx, request.ID, time.Time{}); err != nil {
+		t.Fatalf("SetNextAttempt failed: %v", err)
+	}
+	if claimed, err := database.ClaimNextRequest(ctx, "a", time.Minute, time.Now()); err != nil || claimed.ID != request.ID {
+		t.Fatalf("claim after clearing the next attempt = %+v, %v", claimed, err)
+	}
+
+	// playlists
+	if err := database.NewPlaylistRequest(ctx, "https://open.spotify.com/playlist/backoff", 1); err != nil {
+		t.Fatalf("NewPlaylistRequest failed: %v", err)
+	}
+	playlists, err := database.GetActivePlaylists(ctx)
+	if err != nil || len(playlists) != 1 {
+		t.Fatalf("GetActivePlaylists = %v, %v", playlists, err)
+	}
+	playlist := playlists[0]
+
+	if err := database.SetPlaylistNextAttempt(ctx, playlist.ID, later); err != nil {
+		t.Fatalf("SetPlaylistNextAttempt failed: %v", err)
+	}
+	// the playlist state is written without touching the next attempt
+	synced := time.Now().Add(-time.Hour).Unix()
+	if err := database.SetPlaylistState(ctx, playlist.ID, PlaylistState{LastSyncedAt: synced}); err != nil {
+		t.Fatalf("SetPlaylistState failed: %v", err)
+	}
+	if state, err := database.GetPlaylistState(ctx, playlist.ID); err != nil || state.NextAttemptAt != later.Unix() {
+		t.Fatalf("playlist next attempt = %+v, %v, want %d", state, err, later.Unix())
+	}
+
+	// and cleared by a re-sync
+	playlist.Active = false
+	if err := database.UpdatePlaylistRequest(ctx, playlist); err != nil {
+		t.Fatalf("UpdatePlaylistRequest failed: %v", err)
+	}
+	if n, err := database.ReactivateSyncedPlaylists(ctx, time.Now()); err != nil || n != 1 {
+		t.Fatalf("ReactivateSyncedPlaylists = %d, %v", n, err)
+	}
+	if state, err := database.GetPlaylistState(ctx, playlist.ID); err != nil || state.NextAttemptAt != 0 {
+		t.Errorf("next attempt not cleared by the re-sync, %+v, %v", state, err)
+	}
+
+	if err := database.SetNextAttempt(ctx, "missing", later); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetNextAttempt: expected ErrNotFound, got %v", err)
+	}
+	if err := database.SetPlaylistNextAttempt(ctx, "missing", later); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetPlaylistNextAttempt: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testDeadLetters(t *testing.T, database Database) {
+	ctx := context.Background()
+	request := newRequest(t, database, "https://open.spotify.com/playlist/dead")
+	older := newRequest(t, database, "https://open.spotify.com/album/dead")
+
+	// a request given up with a skipped track and one still missing
+	request.Active = false
+	request.Errored = true
+	request.SyncCount = 2
+	request.RetryCount = 5
+	request.TrackMetadata = []spotify.TrackMetadata{
+		{Artist: "A", Title: "found", SpotifyURL: "https://open.spotify.com/track/1", Found: true},
+		{Artist: "B", Title: "skipped", SpotifyURL: "https://open.spotify.com/track/2", Skipped: true, FailedAttempts: 3},
+		{Artist: "C", Title: "missing", SpotifyURL: "https://open.spotify.com/track/3", FailedAttempts: 1},
+	}
+	if err := database.UpdateActiveRequest(ctx, request); err != nil {
+		t.Fatalf("UpdateActiveRequest failed: %v", err)
+	}
+	if err := database.SetNextAttempt(ctx, request.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SetNextAttempt failed: %v", err)
+	}
+
+	letter := DeadLetter{
+		RequestID:  request.ID,
+		SpotifyURL: request.SpotifyURL,
+		Name:       request.Name,
+		ObjectType: request.ObjectType,
+		CreatorID:  request.CreatorID,
+		SyncCount:  request.SyncCount,
+		RetryCount: request.RetryCount,
+		Error:      "no results found",
+		OutputTail: []string{"Processing query", "LookupError: No results found"},
+		SkippedTracks: []DeadLetterTrack{
+			{Artist: "B", Title: "skipped", SpotifyURL: "https://open.spotify.com/track/2", FailedAttempts: 3, Reason: "no match"},
+		},
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := database.AddDeadLetter(ctx, letter); err != nil {
+		t.Fatalf("AddDeadLetter failed: %v", err)
+	}
+	if err := database.AddDeadLetter(ctx, DeadLetter{RequestID: older.ID, SpotifyURL: older.SpotifyURL, CreatedAt: letter.CreatedAt - 60}); err != nil {
+		t.Fatalf("AddDeadLetter failed: %v", err)
+	}
+
+	got, err := database.GetDeadLetter(ctx, request.ID)
+	if err != nil {
+		t.Fatalf("GetDeadLetter failed: %v", err)
+	}
+	if got.Error != letter.Error || got.SyncCount != 2 || got.RetryCount != 5 || got.ObjectType != letter.ObjectType ||
+		!slices.Equal(got.OutputTail, letter.OutputTail) || !slices.Equal(got.SkippedTracks, letter.SkippedTracks) {
+		t.Errorf("GetDeadLetter = %+v, want %+v", got, letter)
+	}
+
+	// adding again replaces the letter
+	letter.Error = "timed out"
+	if err := database.AddDeadLetter(ctx, letter); err != nil {
+		t.Fatalf("AddDeadLetter failed: %v", err)
+	}
+	letters, err := database.ListDeadLetters(ctx)
+	if err != nil || len(letters) != 2 {
+		t.Fatalf("ListDeadLetters = %+v, %v", letters, err)
+	}
+	if letters[0].RequestID != request.ID || letters[0].Error != "timed out" || letters[1].RequestID != older.ID {
+		t.Errorf("dead letters not replaced or not newest first: %+v", letters)
+	}
+
+	requeued, err := Requeue(ctx, database, request.ID)
+	if err != nil {
+		t.Fatalf("Requeue failed: %v", err)
+	}
+	active, err := database.GetActiveRequest(ctx, request.SpotifyURL)
+	if err != nil {
+		t.Fatalf("requeued request is not active: %v", err)
+	}
+	for _, r := range []models.DownloadQueueRequest{requeued, active} {
+		if r.Errored || r.SyncCount != 0 || r.RetryCount != 0 {
+			t.Errorf("requeued request = %+v, want fresh counts", r)
+		}
+		found, skipped, missing := r.TrackMetadata[0], r.TrackMetadata[1], r.TrackMetadata[2]
+		if !found.Found || skipped.Skipped || skipped.FailedAttempts != 0 || missing.FailedAttempts != 0 {
+			t.Errorf("requeued tracks = %+v, want the not found tracks reset", r.TrackMetadata)
+		}
+	}
+	if state, err := database.GetRequestState(ctx, request.ID); err != nil || state.NextAttemptAt != 0 {
+		t.Errorf("next attempt not cleared by the requeue, %+v, %v", state, err)
+	}
+	if _, err := database.GetDeadLetter(ctx, request.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("dead letter kept after the requeue, got %v", err)
+	}
+
+	if _, err := Requeue(ctx, database, request.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Requeue without a dead letter: expected ErrNotFound, got %v", err)
+	}
+	if err := database.DeleteDeadLetter(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteDeadLetter: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testPlaylists(t *testing.T, database Database) {
+	ctx := context.Background()
+
+	if err := database.NewPlaylistRequest(ctx, "https://open.spotify.com/playlist/a", 7); err != nil {
+		t.Fatalf("NewPlaylistRequest failed: %v", err)
+	}
+	playlists, err := database.GetActivePlaylists(ctx)
+	if err != nil || len(playlists) != 1 {
+		t.Fatalf("GetActivePlaylists = %v, %v", playlists, err)
+	}
+	playlist := playlists[0]
+	if playlist.SpotifyURL != "https://open.spotify.com/playlist/a" || playlist.CreatorID != 7 || !playlist.Active {
+		t.Errorf("unexpected playlist %+v", playlist)
+	}
+
+	state, err := database.GetPlaylistState(ctx, playlist.ID)
+	if err != nil || len(state.Tracks) != 0 || state.LastDiff != nil {
+		t.Fatalf("expected an empty playlist state, got %+v, %v", state, err)
+	}
+
+	synced := time.Now().Add(-48 * time.Hour).Unix()
+	want := PlaylistState{
+		Tracks: []PlaylistTrack{{SpotifyID: "1", Artist: "Björk", Title: "Jóga"}, {Artist: "Björk", Title: "Hunter"}},
+		LastDiff: &PlaylistDiff{
+			Added:   []PlaylistTrack{{Artist: "Björk", Title: "Hunter"}},
+			Removed: []PlaylistTrack{{SpotifyID: "3", Artist: "Björk", Title: "Alarm Call"}},
+			At:      synced,
+		},
+		LastSyncedAt: synced,
+	}
+	if err := database.SetPlaylistState(ctx, playlist.ID, want); err != nil {
+		t.Fatalf("SetPlaylistState failed: %v", err)
+	}
+	state, err = database.GetPlaylistState(ctx, playlist.ID)
+	if err != nil {
+		t.Fatalf("GetPlaylistState failed: %v", err)
+	}
+	if !slices.Equal(state.Tracks, want.Tracks) || state.LastSyncedAt != synced || state.LastDiff == nil ||
+		!slices.Equal(state.LastDiff.Added, want.LastDiff.Added) || !slices.Equal(state.LastDiff.Removed, want.LastDiff.Removed) ||
+		state.LastDiff.At != synced {
+		t.Errorf("playlist state = %+v, want %+v", state, want)
+	}
+
+	playlist.Active = false
+	playlist.Errored = true
+	playlist.RetryCount = 5
+	if err := database.UpdatePlaylistRequest(ctx, playlist); err != nil {
+		t.Fatalf("UpdatePlaylistRequest failed: %v", err)
+	}
+	if playlists, err := database.GetActivePlaylists(ctx); err != nil || len(playlists) != 0 {
+		t.Fatalf("expected no active playlists, got %v, %v", playlists, err)
+	}
+
+	// a playlist given up after an error is not re-synced
+	if n, err := database.ReactivateSyncedPlaylists(ctx, time.Now()); err != nil || n != 0 {
+		t.Errorf("ReactivateSyncedPlaylists of an errored playlist = %d, %v", n, err)
+	}
+
+	playlist.Errored = false
+	if err := database.UpdatePlaylistRequest(ctx, playlist); err != nil {
+		t.Fatalf("UpdatePlaylistRequest failed: %v", err)
+	}
+
+	// only playlists synced before the cutoff are reactivated
+	if n, err := database.ReactivateSyncedPlaylists(ctx, time.Unix(synced, 0)); err != nil || n != 0 {
+		t.Errorf("ReactivateSyncedPlaylists before the sync = %d, %v", n, err)
+	}
+	if n, err := database.ReactivateSyncedPlaylists(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("ReactivateSyncedPlaylists = %d, %v", n, err)
+	}
+	playlists, err = database.GetActivePlaylists(ctx)
+	if err != nil || len(playlists) != 1 || playlists[0].Errored || playlists[0].RetryCount != 0 {
+		t.Errorf("expected the playlist reactivated and reset, got %+v, %v", playlists, err)
+	}
+}
+
+func testLibrary(t *testing.T, database Database) {
+	ctx := context.Background()
+
+	jogaEntry := LibraryEntry{Path: "/music/Björk/Jóga.flac", Hash: "h1", Size: 100, ModTime: 10, Album: "Homogenic", DurationMs: 305000, ISRC: "GBAAA9700001", SpotifyID: "sp1"}
+	hunterEntry := LibraryEntry{Path: "/music/Björk/Hunter.flac", Hash: "h2", Size: 200, ModTime: 20}
+	if err := database.IndexMusicFile(ctx, models.MusicFile{Artist: "Björk", Title: "Jóga (Remastered)"}, jogaEntry); err != nil {
+		t.Fatalf("IndexMusicFile failed: %v", err)
+	}
+	if err := database.IndexMusicFile(ctx, models.MusicFile{Artist: "Björk", Title: "Hunter"}, hunterEntry); err != nil {
+		t.Fatalf("IndexMusicFile failed: %v", err)
+	}
+
+	candidates, err := database.FindMatchCandidates(ctx, []string{"Jóga", "Unknown"})
+	if err != nil || len(candidates) != 1 {
+		t.Fatalf("FindMatchCandidates = %+v, %v", candidates, err)
+	}
+	joga := candidates[0]
+	if joga.ID == "" || joga.Path != jogaEntry.Path || joga.Artist != "Björk" || joga.Album != "Homogenic" ||
+		joga.DurationMs != 305000 || joga.ISRC != "GBAAA9700001" || joga.SpotifyID != "sp1" || joga.CreatedAt == 0 {
+		t.Errorf("unexpected library file %+v", joga)
+	}
+
+	if files, err := database.FindMusicFilesByISRC(ctx, []string{"GBAAA9700001", ""}); err != nil || len(files) != 1 || files[0].ID != joga.ID {
+		t.Errorf("FindMusicFilesByISRC = %+v, %v", files, err)
+	}
+	if files, err := database.FindMusicFilesBySpotifyID(ctx, []string{"sp1"}); err != nil || len(files) != 1 || files[0].ID != joga.ID {
+		t.Errorf("FindMusicFilesBySpotifyID = %+v, %v", files, err)
+	}
+	// files without identifiers are never found by an empty one
+	if files, err := database.FindMusicFilesBySpotifyID(ctx, []string{""}); err != nil || len(files) != 0 {
+		t.Errorf("FindMusicFilesBySpotifyID(\"\") = %+v, %v", files, err)
+	}
+
+	// reindexing keeps the id
+	jogaEntry.ModTime = 11
+	if err := database.IndexMusicFile(ctx, models.MusicFile{Artist: "Björk", Title: "Jóga"}, jogaEntry); err != nil {
+		t.Fatalf("IndexMusicFile failed: %v", err)
+	}
+	// and so does moving
+	if err := database.MoveMusicFile(ctx, jogaEntry.Path, "/music/Björk/Homogenic/Jóga.flac"); err != nil {
+		t.Fatalf("MoveMusicFile failed: %v", err)
+	}
+	candidates, err = database.FindMatchCandidates(ctx, []string{"joga"})
+	if err != nil || len(candidates) != 1 || candidates[0].ID != joga.ID || candidates[0].Path != "/music/Björk/Homogenic/Jóga.flac" {
+		t.Fatalf("expected the moved file with its id, got %+v, %v", candidates, err)
+	}
+
+	entries, err := database.ListLibraryEntries(ctx)
+	if err != nil {
+		t.Fatalf("ListLibraryEntries failed: %v", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	wantEntries := []LibraryEntry{
+		{Path: "/music/Björk/Homogenic/Jóga.flac", Hash: "h1", Size: 100, ModTime: 11},
+		{Path: hunterEntry.Path, Hash: "h2", Size: 200, ModTime: 20},
+	}
+	if !slices.Equal(entries, wantEntries) {
+		t.Errorf("ListLibraryEntries = %+v, want %+v", entries, wantEntries)
+	}
+
+	deleted, err := database.DeleteMusicFiles(ctx, []string{hunterEntry.Path, "/music/missing.flac"})
+	if err != nil || deleted != 1 {
+		t.Errorf("DeleteMusicFiles = %d, %v", deleted, err)
+	}
+	if entries, err := database.ListLibraryEntries(ctx); err != nil || len(entries) != 1 {
+		t.Errorf("expected one entry left, got %+v, %v", entries, err)
+	}
+}
+
+func testIndexStatus(t *testing.T, database Database) {
+	ctx := context.Background()
+
+	status, err := database.GetIndexStatus(ctx)
+	if err != nil || status != (models.IndexStatus{}) {
+		t.Fatalf("expected the zero index status, got %+v, %v", status, err)
+	}
+
+	for _, want := range []models.IndexStatus{{LastUpdated: 10, LastIndexed: 5}, {LastUpdated: 10, LastIndexed: 12}} {
+		if err := database.UpdateIndexStatus(ctx, want); err != nil {
+			t.Fatalf("UpdateIndexStatus failed: %v", err)
+		}
+		if got, err := database.GetIndexStatus(ctx); err != nil || got != want {
+			t.Errorf("GetIndexStatus = %+v, %v, want %+v", got, err, want)
+		}
+	}
+}
+
+func testNotFound(t *testing.T, database Database) {
+	ctx := context.Background()
+	const id = "missing"
+
+	checks := map[string]error{
+		"GetActiveRequest": func() error {
+			_, err := database.GetActiveRequest(ctx, "https://open.spotify.com/album/missing")
+			return err
+		}(),
+		"GetRequest":            func() error { _, err := database.GetRequest(ctx, id); return err }(),
+		"GetRequestState":       func() error { _, err := database.GetRequestState(ctx, id); return err }(),
+		"UpdateActiveRequest":   database.UpdateActiveRequest(ctx, models.DownloadQueueRequest{ID: id}),
+		"SetRequestOutcome":     database.SetRequestOutcome(ctx, id, RequestOutcomeSucceeded, ""),
+		"SetTrackResults":       database.SetTrackResults(ctx, id, nil),
+		"UpdatePlaylistRequest": database.UpdatePlaylistRequest(ctx, models.PlaylistRequest{ID: id}),
+		"GetPlaylistState":      func() error { _, err := database.GetPlaylistState(ctx, id); return err }(),
+		"SetPlaylistState":      database.SetPlaylistState(ctx, id, PlaylistState{}),
+		"MoveMusicFile":         database.MoveMusicFile(ctx, "/missing.flac", "/other.flac"),
+	}
+
+	for name, err := range checks {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", name, err)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package db
 import (
 	"context"
 	"errors"
-	"regexp"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
 	models "github.com/supperdoggy/spot-models"
 	"github.com/supperdoggy/spot-models/spotify"
 	"go.mongodb.org/mongo-driver/bson"
@@ -18,23 +20,125 @@ import (
 type Database interface {
 	GetActiveRequests(ctx context.Context) ([]models.DownloadQueueRequest, error)
 	GetActiveRequest(ctx context.Context, url string) (models.DownloadQueueRequest, error)
+	GetRequest(ctx context.Context, id string) (models.DownloadQueueRequest, error)
+	ListRequests(ctx context.Context, status RequestStatus) ([]models.DownloadQueueRequest, error)
+	GetRequestState(ctx context.Context, id string) (RequestState, error)
 	CheckIfRequestAlreadySynced(ctx context.Context, url string) (bool, error)
 	NewDownloadRequest(ctx context.Context, url, name string, creatorID int64, objectType spotify.SpotifyObjectType) error
 	UpdateActiveRequest(ctx context.Context, request models.DownloadQueueRequest) error
+	SetRequestOutcome(ctx context.Context, id string, outcome RequestOutcome, message string) error
+	SetTrackResults(ctx context.Context, id string, results []TrackResult) error
+	SetNextAttempt(ctx context.Context, id string, at time.Time) error
+
+	ClaimNextRequest(ctx context.Context, owner string, lease time.Duration, claimedBefore time.Time) (models.DownloadQueueRequest, error)
+	RenewLease(ctx context.Context, id, owner string, lease time.Duration) error
+	ReleaseRequest(ctx context.Context, id, owner string) error
+	ReleaseExpiredLeases(ctx context.Context) (int64, error)
+
+	AddDeadLetter(ctx context.Context, letter DeadLetter) error
+	GetDeadLetter(ctx context.Context, id string) (DeadLetter, error)
+	ListDeadLetters(ctx context.Context) ([]DeadLetter, error)
+	DeleteDeadLetter(ctx context.Context, id string) error
 
 	GetActivePlaylists(ctx context.Context) ([]models.PlaylistRequest, error)
+	NewPlaylistRequest(ctx context.Context, url string, creatorID int64) error
 	UpdatePlaylistRequest(ctx context.Context, request models.PlaylistRequest) error
-
-	FindMusicFiles(ctx context.Context, artists, titles []string) ([]models.MusicFile, error)
-	IndexMusicFile(ctx context.Context, file models.MusicFile) error
+	GetPlaylistState(ctx context.Context, id string) (PlaylistState, error)
+	SetPlaylistState(ctx context.Context, id string, state PlaylistState) error
+	SetPlaylistNextAttempt(ctx context.Context, id string, at time.Time) error
+	ReactivateSyncedPlaylists(ctx context.Context, syncedBefore time.Time) (int64, error)
+
+	FindMatchCandidates(ctx context.Context, titles []string) ([]LibraryFile, error)
+	FindMusicFilesByISRC(ctx context.Context, isrcs []string) ([]LibraryFile, error)
+	FindMusicFilesBySpotifyID(ctx context.Context, ids []string) ([]LibraryFile, error)
+	IndexMusicFile(ctx context.Context, file models.MusicFile, entry LibraryEntry) error
+	MoveMusicFile(ctx context.Context, from, to string) error
+	DeleteMusicFiles(ctx context.Context, paths []string) (int64, error)
+	ListLibraryEntries(ctx context.Context) ([]LibraryEntry, error)
 
 	GetIndexStatus(ctx context.Context) (models.IndexStatus, error)
 	UpdateIndexStatus(ctx context.Context, status models.IndexStatus) error
+
+	Ping(ctx context.Context) error
+}
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrUnknownDriver = errors.New("unknown database driver")
+)
+
+// Drivers selectable with Open
+const (
+	DriverMongo  = "mongo"
+	DriverSQLite = "sqlite"
+	DriverMemory = "memory"
+)
+
+// RequestStatus selects requests by their lifecycle state
+type RequestStatus string
+
+const (
+	RequestStatusAll       RequestStatus = ""
+	RequestStatusActive    RequestStatus = "active"
+	RequestStatusCompleted RequestStatus = "completed"
+	RequestStatusErrored   RequestStatus = "errored"
+)
+
+// matches reports whether request is in the status, it mirrors the filters of ListRequests
+func (s RequestStatus) matches(request models.DownloadQueueRequest) bool {
+	switch s {
+	case RequestStatusActive:
+		return request.Active
+	case RequestStatusCompleted:
+		return !request.Active && !request.Errored
+	case RequestStatusErrored:
+		return request.Errored
+	default:
+		return true
+	}
+}
+
+// RequestOutcome is the result of the last processing attempt of a download request
+type RequestOutcome string
+
+const (
+	RequestOutcomeSucceeded RequestOutcome = "succeeded"
+	RequestOutcomeFailed    RequestOutcome = "failed"
+	RequestOutcomeTimedOut  RequestOutcome = "timed_out"
+)
+
+// RequestState is the bookkeeping this service stores on a download request
+// document next to the fields of the shared models.DownloadQueueRequest
+type RequestState struct {
+	LastOutcome   RequestOutcome `bson:"last_outcome,omitempty"`
+	LastError     string         `bson:"last_error,omitempty"`
+	LastAttemptAt int64          `bson:"last_attempt_at,omitempty"`
+	TrackResults  []TrackResult  `bson:"track_results,omitempty"`
+	// NextAttemptAt is the unix time before which a failed request is not claimed again
+	NextAttemptAt int64 `bson:"next_attempt_at,omitempty"`
+
+	// LeaseOwner is the instance processing the request, see ClaimNextRequest
+	LeaseOwner string `bson:"lease_owner,omitempty"`
+	// LeaseExpiresAt and ClaimedAt are unix milliseconds
+	LeaseExpiresAt int64 `bson:"lease_expires_at,omitempty"`
+	ClaimedAt      int64 `bson:"claimed_at,omitempty"`
+}
+
+// TrackResult is the downloader's verdict on the last download attempt of a single track
+type TrackResult struct {
+	SpotifyURL string `bson:"spotify_url"`
+	Artist     string `bson:"artist"`
+	Title      string `bson:"title"`
+	// Status is the downloader event kind, e.g. downloaded, skipped, no_match, provider_error, rate_limited
+	Status    string `bson:"status"`
+	Reason    string `bson:"reason,omitempty"`
+	UpdatedAt int64  `bson:"updated_at"`
 }
 
 type db struct {
-	conn *mongo.Client
-	log  *zap.Logger
+	conn   *mongo.Client
+	connMu sync.Mutex
+	log    *zap.Logger
 
 	url    string
 	dbname string
@@ -46,13 +150,35 @@ func NewDatabase(ctx context.Context, log *zap.Logger, url, dbname string) (Data
 		return nil, err
 	}
 
-	return &db{
+	d := &db{
 		conn: conn,
 		log:  log,
 
 		url:    url,
 		dbname: dbname,
-	}, nil
+	}
+
+	if err := d.ensureLibraryIndexes(ctx); err != nil {
+		log.Error("failed to prepare music files collection", zap.Error(err))
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Open returns the database of driver. url is the Mongo connection string or the SQLite file path,
+// name is the Mongo database name. The memory driver keeps nothing across restarts.
+func Open(ctx context.Context, log *zap.Logger, driver, url, name string) (Database, error) {
+	switch driver {
+	case DriverMongo, "":
+		return NewDatabase(ctx, log, url, name)
+	case DriverSQLite:
+		return NewSQLiteDatabase(ctx, log, url)
+	case DriverMemory:
+		return NewMemoryDatabase(), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownDriver, driver)
+	}
 }
 
 func (d *db) NewDownloadRequest(ctx context.Context, url, name string, creatorID int64, objectType spotify.SpotifyObjectType) error {
@@ -128,18 +254,39 @@ func (d *db) GetActivePlaylists(ctx context.Context) ([]models.PlaylistRequest,
 	return requests, nil
 }
 
+// NewPlaylistRequest queues an active playlist request for url
+func (d *db) NewPlaylistRequest(ctx context.Context, url string, creatorID int64) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	request := models.PlaylistRequest{
+		ID:         id.String(),
+		SpotifyURL: url,
+		Active:     true,
+		CreatorID:  creatorID,
+		CreatedAt:  time.Now().Unix(),
+	}
+
+	_, err = d.playlistsCollection().InsertOne(ctx, request)
+	return err
+}
+
 func (d *db) UpdatePlaylistRequest(ctx context.Context, request models.PlaylistRequest) error {
 	info, err := d.playlistsCollection().UpdateOne(ctx, bson.M{"_id": request.ID}, bson.M{"$set": bson.M{
 		"active":      request.Active,
 		"errored":     request.Errored,
 		"retry_count": request.RetryCount,
 	}})
+	if err != nil {
+		return err
+	}
 
 	if info.MatchedCount == 0 {
-		return errors.New("not found")
+		return ErrNotFound
 	}
-
-	return err
+	return nil
 }
 
 func (d *db) UpdateActiveRequest(ctx context.Context, request models.DownloadQueueRequest) error {
@@ -159,17 +306,65 @@ func (d *db) UpdateActiveRequest(ctx context.Context, request models.DownloadQue
 	}
 
 	if info.MatchedCount == 0 {
-		return errors.New("not found")
+		return ErrNotFound
 	}
 	return nil
 }
 
-// IndexMusicFile indexes a music file in the database
-func (d *db) IndexMusicFile(ctx context.Context, file models.MusicFile) error {
-	file.ID = uuid.Must(uuid.NewV4()).String()
-	file.CreatedAt = time.Now().Unix()
-	_, err := d.musicFilesCollection().InsertOne(ctx, file)
-	return err
+// SetRequestOutcome records the outcome of the last processing attempt of a request.
+// The fields live next to the shared request model and are owned by this service.
+func (d *db) SetRequestOutcome(ctx context.Context, id string, outcome RequestOutcome, message string) error {
+	info, err := d.downloadQueueRequestCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"last_outcome":    outcome,
+		"last_error":      message,
+		"last_attempt_at": time.Now().Unix(),
+	}})
+	if err != nil {
+		return err
+	}
+
+	if info.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetTrackResults replaces the per track download results of a request
+func (d *db) SetTrackResults(ctx context.Context, id string, results []TrackResult) error {
+	info, err := d.downloadQueueRequestCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"track_results": results,
+	}})
+	if err != nil {
+		return err
+	}
+
+	if info.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetNextAttempt holds a request back from ClaimNextRequest until at, the zero time clears it
+func (d *db) SetNextAttempt(ctx context.Context, id string, at time.Time) error {
+	info, err := d.downloadQueueRequestCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"next_attempt_at": unixOrZero(at),
+	}})
+	if err != nil {
+		return err
+	}
+
+	if info.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// unixOrZero returns the unix time of t, 0 for the zero time
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
 }
 
 // MusicFileExist checks if a music file exists in the database
@@ -183,6 +378,15 @@ func (d *db) MusicFileExist(ctx context.Context, title string) (bool, error) {
 	return count > 0, nil
 }
 
+// Ping checks the connection to the database without reconnecting
+func (d *db) Ping(ctx context.Context) error {
+	d.connMu.Lock()
+	conn := d.conn
+	d.connMu.Unlock()
+
+	return conn.Ping(ctx, nil)
+}
+
 func (d *db) reconnectToDB() error {
 	if err := d.conn.Disconnect(context.Background()); err != nil {
 		d.log.Warn("error disconnecting from database", zap.Error(err))
@@ -199,111 +403,127 @@ func (d *db) reconnectToDB() error {
 
 // Collections
 
-// downloadQueueRequestCollection returns the download queue request collection
-func (d *db) downloadQueueRequestCollection() *mongo.Collection {
+// collection pings the database, reconnects if needed and returns the named collection.
+// Requests are processed by several workers so the connection swap is guarded.
+func (d *db) collection(name string) *mongo.Collection {
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+
 	if err := d.conn.Ping(context.Background(), nil); err != nil {
 		d.log.Error("failed to ping database. reconnecting.", zap.Error(err))
 		if reconnectErr := d.reconnectToDB(); reconnectErr != nil {
 			d.log.Error("failed to reconnect to database", zap.Error(reconnectErr))
+			metrics.MongoReconnects.WithLabelValues("failure").Inc()
+		} else {
+			metrics.MongoReconnects.WithLabelValues("success").Inc()
 		}
 	}
-	return d.conn.Database(d.dbname).Collection("download-queue-requests")
+	return d.conn.Database(d.dbname).Collection(name)
 }
 
-func (d *db) playlistsCollection() *mongo.Collection {
-	if err := d.conn.Ping(context.Background(), nil); err != nil {
-		d.log.Error("failed to ping database. reconnecting.", zap.Error(err))
-		if reconnectErr := d.reconnectToDB(); reconnectErr != nil {
-			d.log.Error("failed to reconnect to database", zap.Error(reconnectErr))
-		}
-	}
+// downloadQueueRequestCollection returns the download queue request collection
+func (d *db) downloadQueueRequestCollection() *mongo.Collection {
+	return d.collection("download-queue-requests")
+}
 
-	return d.conn.Database(d.dbname).Collection("playlist-requests")
+func (d *db) playlistsCollection() *mongo.Collection {
+	return d.collection("playlist-requests")
 }
 
 func (d *db) indexStatusCollection() *mongo.Collection {
-	if err := d.conn.Ping(context.Background(), nil); err != nil {
-		d.log.Error("failed to ping database. reconnecting.", zap.Error(err))
-		if reconnectErr := d.reconnectToDB(); reconnectErr != nil {
-			d.log.Error("failed to reconnect to database", zap.Error(reconnectErr))
-		}
-	}
-
-	return d.conn.Database(d.dbname).Collection("index-status")
+	return d.collection("index-status")
 }
 
 // musicFilesCollection returns the music files collection
 func (d *db) musicFilesCollection() *mongo.Collection {
-	if err := d.conn.Ping(context.Background(), nil); err != nil {
-		d.log.Error("failed to ping database. reconnecting.", zap.Error(err))
-		if reconnectErr := d.reconnectToDB(); reconnectErr != nil {
-			d.log.Error("failed to reconnect to database", zap.Error(reconnectErr))
-		}
+	return d.collection("music-files")
+}
+
+// GetActiveRequest returns the active download request of url, ErrNotFound if there is none
+func (d *db) GetActiveRequest(ctx context.Context, url string) (models.DownloadQueueRequest, error) {
+	cur := d.downloadQueueRequestCollection().FindOne(ctx, bson.M{"spotify_url": url, "active": true})
+	var req models.DownloadQueueRequest
+	err := cur.Decode(&req)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.DownloadQueueRequest{}, ErrNotFound
+	}
+	if err != nil {
+		return models.DownloadQueueRequest{}, err
 	}
 
-	return d.conn.Database(d.dbname).Collection("music-files")
+	return req, nil
 }
 
-// escapeRegex escapes special regex characters in a string
-func escapeRegex(s string) string {
-	return regexp.QuoteMeta(s)
+// GetRequest returns a download request by id regardless of its state
+func (d *db) GetRequest(ctx context.Context, id string) (models.DownloadQueueRequest, error) {
+	var req models.DownloadQueueRequest
+	err := d.downloadQueueRequestCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&req)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.DownloadQueueRequest{}, ErrNotFound
+	}
+	if err != nil {
+		return models.DownloadQueueRequest{}, err
+	}
+
+	return req, nil
 }
 
-func (d *db) FindMusicFiles(ctx context.Context, artists, titles []string) ([]models.MusicFile, error) {
-	orPairs := make([]bson.M, 0, len(artists))
-	for i := range artists {
-		// Use case-insensitive regex matching for both artist and title
-		// This handles cases where database stores original case but we query with lowercase
-		// Escape special regex characters to ensure exact matching
-		escapedArtist := escapeRegex(artists[i])
-		escapedTitle := escapeRegex(titles[i])
-		orPairs = append(orPairs, bson.M{
-			"$and": []bson.M{
-				{"artist": bson.M{"$regex": "^" + escapedArtist + "$", "$options": "i"}},
-				{"title": bson.M{"$regex": "^" + escapedTitle + "$", "$options": "i"}},
-			},
-		})
+// ListRequests returns the download requests in the given state, newest first
+func (d *db) ListRequests(ctx context.Context, status RequestStatus) ([]models.DownloadQueueRequest, error) {
+	filter := bson.M{}
+	switch status {
+	case RequestStatusActive:
+		filter["active"] = true
+	case RequestStatusCompleted:
+		filter["active"] = false
+		filter["errored"] = bson.M{"$ne": true}
+	case RequestStatusErrored:
+		filter["errored"] = true
 	}
 
-	d.log.Info("Finding music files", zap.Any("orPairs", orPairs))
-
-	cur, err := d.musicFilesCollection().Find(ctx, bson.M{
-		"$or": orPairs,
-	}, options.Find().SetProjection(bson.M{"meta_data": 0}))
+	cursor, err := d.downloadQueueRequestCollection().Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
 	if err != nil {
 		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	defer cur.Close(ctx)
-
-	files := make([]models.MusicFile, 0)
-	for cur.Next(ctx) {
-		var file models.MusicFile
-		if err := cur.Decode(&file); err != nil {
+	requests := make([]models.DownloadQueueRequest, 0)
+	for cursor.Next(ctx) {
+		var request models.DownloadQueueRequest
+		if err := cursor.Decode(&request); err != nil {
 			return nil, err
 		}
-		files = append(files, file)
+
+		requests = append(requests, request)
 	}
-	if err := cur.Err(); err != nil {
+	if err := cursor.Err(); err != nil {
 		return nil, err
 	}
 
-	return files, nil
+	return requests, nil
 }
 
-func (d *db) GetActiveRequest(ctx context.Context, url string) (models.DownloadQueueRequest, error) {
-	cur := d.downloadQueueRequestCollection().FindOne(ctx, bson.M{"spotify_url": url, "active": true})
-	var req models.DownloadQueueRequest
-	if err := cur.Decode(&req); err != nil {
-		return models.DownloadQueueRequest{}, err
+// GetRequestState returns the service owned bookkeeping of a download request
+func (d *db) GetRequestState(ctx context.Context, id string) (RequestState, error) {
+	var state RequestState
+	err := d.downloadQueueRequestCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return RequestState{}, ErrNotFound
+	}
+	if err != nil {
+		return RequestState{}, err
 	}
 
-	return req, nil
+	return state, nil
 }
 
+// GetIndexStatus returns the index status, the zero status before the first update
 func (d *db) GetIndexStatus(ctx context.Context) (models.IndexStatus, error) {
 	var status models.IndexStatus
 	err := d.indexStatusCollection().FindOne(ctx, bson.M{}).Decode(&status)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.IndexStatus{}, nil
+	}
 	if err != nil {
 		return models.IndexStatus{}, err
 	}
@@ -314,7 +534,7 @@ func (d *db) GetIndexStatus(ctx context.Context) (models.IndexStatus, error) {
 func (d *db) UpdateIndexStatus(ctx context.Context, status models.IndexStatus) error {
 	_, err := d.indexStatusCollection().UpdateOne(ctx, bson.M{}, bson.M{
 		"$set": status,
-	})
+	}, options.Update().SetUpsert(true))
 	if err != nil {
 		return err
 	}
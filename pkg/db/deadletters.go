@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeadLetter is a download request that was given up after failing, kept until it is requeued
+type DeadLetter struct {
+	// RequestID is the id of the download request, a request has at most one dead letter
+	RequestID  string                    `bson:"_id"`
+	SpotifyURL string                    `bson:"spotify_url"`
+	Name       string                    `bson:"name"`
+	ObjectType spotify.SpotifyObjectType `bson:"object_type"`
+	CreatorID  int64                     `bson:"creator_id"`
+	SyncCount  int                       `bson:"sync_count"`
+	RetryCount int                       `bson:"retry_count"`
+	// Error is the failure that ended the request
+	Error string `bson:"error"`
+	// OutputTail are the last lines the downloader printed in the last failed download
+	OutputTail    []string          `bson:"output_tail"`
+	SkippedTracks []DeadLetterTrack `bson:"skipped_tracks"`
+	CreatedAt     int64             `bson:"created_at"`
+}
+
+// DeadLetterTrack is a track of a dead letter that was skipped after failing
+type DeadLetterTrack struct {
+	Artist         string `bson:"artist"`
+	Title          string `bson:"title"`
+	SpotifyURL     string `bson:"spotify_url"`
+	FailedAttempts int    `bson:"failed_attempts"`
+	// Reason is the downloader's reason of the last failure, if it gave one
+	Reason string `bson:"reason,omitempty"`
+}
+
+// Requeue puts the request of a dead letter back into the queue and drops the dead letter.
+// The sync and retry counts start over and the tracks that were not found are tried again.
+func Requeue(ctx context.Context, database Database, id string) (models.DownloadQueueRequest, error) {
+	if _, err := database.GetDeadLetter(ctx, id); err != nil {
+		return models.DownloadQueueRequest{}, err
+	}
+
+	request, err := database.GetRequest(ctx, id)
+	if err != nil {
+		return models.DownloadQueueRequest{}, err
+	}
+
+	request = Reactivate(request)
+	request.UpdatedAt = time.Now().Unix()
+
+	if err := database.UpdateActiveRequest(ctx, request); err != nil {
+		return models.DownloadQueueRequest{}, err
+	}
+	if err := database.SetNextAttempt(ctx, request.ID, time.Time{}); err != nil {
+		return models.DownloadQueueRequest{}, err
+	}
+	if err := database.DeleteDeadLetter(ctx, id); err != nil && !errors.Is(err, ErrNotFound) {
+		return models.DownloadQueueRequest{}, err
+	}
+
+	return request, nil
+}
+
+// Reactivate marks a request active with reset sync and retry counts, and gives the tracks
+// that were not found fresh failed attempts
+func Reactivate(request models.DownloadQueueRequest) models.DownloadQueueRequest {
+	request.Active = true
+	request.Errored = false
+	request.SyncCount = 0
+	request.RetryCount = 0
+	request.TrackMetadata = slices.Clone(request.TrackMetadata)
+	for i := range request.TrackMetadata {
+		track := &request.TrackMetadata[i]
+		track.FailedAttempts = 0
+		if !track.Found {
+			track.Skipped = false
+		}
+	}
+
+	return request
+}
+
+// AddDeadLetter stores a dead letter, replacing an earlier one of the same request
+func (d *db) AddDeadLetter(ctx context.Context, letter DeadLetter) error {
+	_, err := d.deadLettersCollection().ReplaceOne(ctx, bson.M{"_id": letter.RequestID}, letter, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetDeadLetter returns the dead letter of a request, ErrNotFound if it has none
+func (d *db) GetDeadLetter(ctx context.Context, id string) (DeadLetter, error) {
+	var letter DeadLetter
+	err := d.deadLettersCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&letter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return DeadLetter{}, ErrNotFound
+	}
+	if err != nil {
+		return DeadLetter{}, err
+	}
+
+	return letter, nil
+}
+
+// ListDeadLetters returns all dead letters, newest first
+func (d *db) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	cursor, err := d.deadLettersCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	letters := make([]DeadLetter, 0)
+	if err := cursor.All(ctx, &letters); err != nil {
+		return nil, err
+	}
+
+	return letters, nil
+}
+
+// DeleteDeadLetter drops the dead letter of a request, ErrNotFound if it has none
+func (d *db) DeleteDeadLetter(ctx context.Context, id string) error {
+	info, err := d.deadLettersCollection().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if info.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (d *db) deadLettersCollection() *mongo.Collection {
+	return d.collection("dead-letters")
+}
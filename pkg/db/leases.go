@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	models "github.com/supperdoggy/spot-models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Several instances can share one queue: a request is processed by the instance holding its lease.
+// The holder renews the lease while it works on the request, a crashed instance stops renewing
+// and the request becomes claimable again once the lease expired.
+
+var (
+	// ErrLeaseLost is returned when the lease of a request is no longer held by the caller
+	ErrLeaseLost = errors.New("lease lost")
+)
+
+// ClaimNextRequest leases the next active request to owner for lease. Requests leased to another
+// instance, requests claimed at or after claimedBefore and requests waiting for their next attempt
+// are skipped, errored requests come last.
+// It returns ErrNotFound when there is nothing left to claim.
+func (d *db) ClaimNextRequest(ctx context.Context, owner string, lease time.Duration, claimedBefore time.Time) (models.DownloadQueueRequest, error) {
+	now := time.Now()
+	filter := bson.M{
+		"active": true,
+		// $not also matches documents that were never leased
+		"lease_expires_at": bson.M{"$not": bson.M{"$gt": now.UnixMilli()}},
+		"claimed_at":       bson.M{"$not": bson.M{"$gte": claimedBefore.UnixMilli()}},
+		"next_attempt_at":  bson.M{"$not": bson.M{"$gt": now.Unix()}},
+	}
+	update := bson.M{"$set": bson.M{
+		"lease_owner":      owner,
+		"lease_expires_at": now.Add(lease).UnixMilli(),
+		"claimed_at":       now.UnixMilli(),
+	}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "errored", Value: 1}, {Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var request models.DownloadQueueRequest
+	err := d.downloadQueueRequestCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&request)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.DownloadQueueRequest{}, ErrNotFound
+	}
+	if err != nil {
+		return models.DownloadQueueRequest{}, err
+	}
+
+	return request, nil
+}
+
+// RenewLease extends the lease of owner on a request by lease from now, ErrLeaseLost if owner doesn't hold it
+func (d *db) RenewLease(ctx context.Context, id, owner string, lease time.Duration) error {
+	info, err := d.downloadQueueRequestCollection().UpdateOne(ctx, bson.M{"_id": id, "lease_owner": owner}, bson.M{"$set": bson.M{
+		"lease_expires_at": time.Now().Add(lease).UnixMilli(),
+	}})
+	if err != nil {
+		return err
+	}
+
+	if info.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// ReleaseRequest gives up the lease of owner on a request, ErrLeaseLost if owner doesn't hold it
+func (d *db) ReleaseRequest(ctx context.Context, id, owner string) error {
+	info, err := d.downloadQueueRequestCollection().UpdateOne(ctx, bson.M{"_id": id, "lease_owner": owner}, bson.M{"$unset": bson.M{
+		"lease_owner":      "",
+		"lease_expires_at": "",
+	}})
+	if err != nil {
+		return err
+	}
+
+	if info.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// ReleaseExpiredLeases clears the leases that were not renewed in time and returns how many there were
+func (d *db) ReleaseExpiredLeases(ctx context.Context) (int64, error) {
+	info, err := d.downloadQueueRequestCollection().UpdateMany(ctx, bson.M{
+		"lease_expires_at": bson.M{"$lte": time.Now().UnixMilli()},
+	}, bson.M{"$unset": bson.M{
+		"lease_owner":      "",
+		"lease_expires_at": "",
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	return info.ModifiedCount, nil
+}
@@ -0,0 +1,265 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/match"
+	models "github.com/supperdoggy/spot-models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	// deleteChunkSize bounds the size of a single $in delete
+	deleteChunkSize = 1000
+	// lookupChunkSize bounds the size of a single $in lookup
+	lookupChunkSize = 500
+	// backfillBatchSize is the number of documents updated per bulk write when adding match keys
+	backfillBatchSize = 1000
+)
+
+// LibraryEntry is the indexer's bookkeeping stored on a music file document
+type LibraryEntry struct {
+	Path string `bson:"path"`
+	// Hash is a checksum of the audio data that does not change when tags are edited
+	Hash    string `bson:"content_hash"`
+	Size    int64  `bson:"size"`
+	ModTime int64  `bson:"mod_time"`
+	Album   string `bson:"album,omitempty"`
+	// DurationMs is 0 when the duration could not be read
+	DurationMs int64 `bson:"duration_ms,omitempty"`
+	// ISRC and SpotifyID are read from the tags spotdl writes
+	ISRC      string `bson:"isrc,omitempty"`
+	SpotifyID string `bson:"spotify_id,omitempty"`
+}
+
+// LibraryFile is an indexed music file with the details the matcher can use
+type LibraryFile struct {
+	models.MusicFile `bson:",inline"`
+	// DurationMs is 0 when the indexer could not read the duration
+	DurationMs int64  `bson:"duration_ms"`
+	ISRC       string `bson:"isrc"`
+	SpotifyID  string `bson:"spotify_id"`
+}
+
+// FindMusicFilesByISRC returns the files tagged with one of isrcs
+func (d *db) FindMusicFilesByISRC(ctx context.Context, isrcs []string) ([]LibraryFile, error) {
+	return d.findLibraryFilesIn(ctx, "isrc", isrcs)
+}
+
+// FindMusicFilesBySpotifyID returns the files tagged with one of the Spotify track ids
+func (d *db) FindMusicFilesBySpotifyID(ctx context.Context, ids []string) ([]LibraryFile, error) {
+	return d.findLibraryFilesIn(ctx, "spotify_id", ids)
+}
+
+// findLibraryFilesIn looks up the files whose field is one of values, in chunks of lookupChunkSize
+func (d *db) findLibraryFilesIn(ctx context.Context, field string, values []string) ([]LibraryFile, error) {
+	files := make([]LibraryFile, 0)
+	values = slices.DeleteFunc(slices.Clone(values), func(v string) bool { return v == "" })
+
+	for start := 0; start < len(values); start += lookupChunkSize {
+		end := min(start+lookupChunkSize, len(values))
+		cur, err := d.musicFilesCollection().Find(ctx, bson.M{field: bson.M{"$in": values[start:end]}},
+			options.Find().SetProjection(bson.M{"meta_data": 0}))
+		if err != nil {
+			return nil, err
+		}
+
+		var chunk []LibraryFile
+		if err := cur.All(ctx, &chunk); err != nil {
+			return nil, err
+		}
+		files = append(files, chunk...)
+	}
+
+	return files, nil
+}
+
+// FindMatchCandidates returns the files sharing the match key of one of titles.
+// Callers pick the actual match from the candidates themselves.
+func (d *db) FindMatchCandidates(ctx context.Context, titles []string) ([]LibraryFile, error) {
+	seen := make(map[string]bool, len(titles))
+	keys := make([]string, 0, len(titles))
+	for _, title := range titles {
+		key := match.TitleKey(title)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+
+	d.log.Debug("finding match candidates", zap.Int("titles", len(titles)), zap.Strings("match_keys", keys))
+
+	return d.findLibraryFilesIn(ctx, "match_key", keys)
+}
+
+// IndexMusicFile upserts a music file by its path
+func (d *db) IndexMusicFile(ctx context.Context, file models.MusicFile, entry LibraryEntry) error {
+	collection := d.musicFilesCollection()
+
+	var existing models.MusicFile
+	err := collection.FindOne(ctx, bson.M{"path": entry.Path}).Decode(&existing)
+	switch {
+	case err == nil:
+		file.ID = existing.ID
+		file.CreatedAt = existing.CreatedAt
+	case errors.Is(err, mongo.ErrNoDocuments):
+		file.ID = uuid.Must(uuid.NewV4()).String()
+		file.CreatedAt = time.Now().Unix()
+	default:
+		return err
+	}
+	file.Path = entry.Path
+
+	raw, err := bson.Marshal(file)
+	if err != nil {
+		return err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+
+	doc["content_hash"] = entry.Hash
+	doc["size"] = entry.Size
+	doc["mod_time"] = entry.ModTime
+	doc["album"] = entry.Album
+	doc["duration_ms"] = entry.DurationMs
+	doc["isrc"] = entry.ISRC
+	doc["match_key"] = match.TitleKey(file.Title)
+	doc["spotify_id"] = entry.SpotifyID
+	doc["indexed_at"] = time.Now().Unix()
+
+	_, err = collection.ReplaceOne(ctx, bson.M{"path": entry.Path}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+// MoveMusicFile points an indexed file to its new path, keeping its id
+func (d *db) MoveMusicFile(ctx context.Context, from, to string) error {
+	info, err := d.musicFilesCollection().UpdateOne(ctx, bson.M{"path": from}, bson.M{"$set": bson.M{"path": to}})
+	if err != nil {
+		return err
+	}
+
+	if info.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteMusicFiles removes the indexed files with the given paths
+func (d *db) DeleteMusicFiles(ctx context.Context, paths []string) (int64, error) {
+	var deleted int64
+	for start := 0; start < len(paths); start += deleteChunkSize {
+		end := min(start+deleteChunkSize, len(paths))
+		res, err := d.musicFilesCollection().DeleteMany(ctx, bson.M{"path": bson.M{"$in": paths[start:end]}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += res.DeletedCount
+	}
+
+	return deleted, nil
+}
+
+// ListLibraryEntries returns the indexer bookkeeping of all indexed files
+func (d *db) ListLibraryEntries(ctx context.Context) ([]LibraryEntry, error) {
+	cur, err := d.musicFilesCollection().Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{
+		"path":         1,
+		"content_hash": 1,
+		"size":         1,
+		"mod_time":     1,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	entries := make([]LibraryEntry, 0)
+	for cur.Next(ctx) {
+		var entry LibraryEntry
+		if err := cur.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// ensureLibraryIndexes creates the lookup indexes of the music files collection and
+// adds match keys to files indexed before they existed
+func (d *db) ensureLibraryIndexes(ctx context.Context) error {
+	_, err := d.musicFilesCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "match_key", Value: 1}}},
+		{Keys: bson.D{{Key: "path", Value: 1}}},
+		{Keys: bson.D{{Key: "isrc", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "spotify_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return err
+	}
+
+	return d.backfillMatchKeys(ctx)
+}
+
+func (d *db) backfillMatchKeys(ctx context.Context) error {
+	collection := d.musicFilesCollection()
+	cur, err := collection.Find(ctx, bson.M{"match_key": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"_id": 1, "title": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	updated := 0
+	batch := make([]mongo.WriteModel, 0, backfillBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+		updated += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for cur.Next(ctx) {
+		var file models.MusicFile
+		if err := cur.Decode(&file); err != nil {
+			return err
+		}
+
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": file.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"match_key": match.TitleKey(file.Title)}}))
+		if len(batch) == backfillBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if updated > 0 {
+		d.log.Info("added match keys to indexed music files", zap.Int("files", updated))
+	}
+	return nil
+}
@@ -0,0 +1,579 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/match"
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+)
+
+// memoryDatabase keeps everything in process memory, it is meant for tests and local development
+type memoryDatabase struct {
+	mu sync.Mutex
+
+	requests  map[string]*memoryRequest
+	playlists map[string]*memoryPlaylist
+	// files are keyed by path
+	files map[string]*memoryFile
+	// deadLetters are keyed by request id
+	deadLetters map[string]DeadLetter
+	indexStatus models.IndexStatus
+}
+
+type memoryRequest struct {
+	request models.DownloadQueueRequest
+	state   RequestState
+}
+
+type memoryPlaylist struct {
+	request models.PlaylistRequest
+	state   PlaylistState
+}
+
+type memoryFile struct {
+	file     LibraryFile
+	entry    LibraryEntry
+	matchKey string
+}
+
+// NewMemoryDatabase returns an empty in-memory database
+func NewMemoryDatabase() Database {
+	return &memoryDatabase{
+		requests:    make(map[string]*memoryRequest),
+		playlists:   make(map[string]*memoryPlaylist),
+		files:       make(map[string]*memoryFile),
+		deadLetters: make(map[string]DeadLetter),
+	}
+}
+
+func (m *memoryDatabase) GetActiveRequests(ctx context.Context) ([]models.DownloadQueueRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requests := m.findRequests(func(r models.DownloadQueueRequest) bool { return r.Active })
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].CreatedAt < requests[j].CreatedAt })
+	return requests, nil
+}
+
+func (m *memoryDatabase) GetActiveRequest(ctx context.Context, url string) (models.DownloadQueueRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requests := m.findRequests(func(r models.DownloadQueueRequest) bool { return r.Active && r.SpotifyURL == url })
+	if len(requests) == 0 {
+		return models.DownloadQueueRequest{}, ErrNotFound
+	}
+	return requests[0], nil
+}
+
+func (m *memoryDatabase) GetRequest(ctx context.Context, id string) (models.DownloadQueueRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.requests[id]
+	if !ok {
+		return models.DownloadQueueRequest{}, ErrNotFound
+	}
+	return cloneRequest(r.request), nil
+}
+
+func (m *memoryDatabase) ListRequests(ctx context.Context, status RequestStatus) ([]models.DownloadQueueRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requests := m.findRequests(func(r models.DownloadQueueRequest) bool { return status.matches(r) })
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].CreatedAt > requests[j].CreatedAt })
+	return requests, nil
+}
+
+func (m *memoryDatabase) GetRequestState(ctx context.Context, id string) (RequestState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.requests[id]
+	if !ok {
+		return RequestState{}, ErrNotFound
+	}
+	state := r.state
+	state.TrackResults = slices.Clone(state.TrackResults)
+	return state, nil
+}
+
+func (m *memoryDatabase) CheckIfRequestAlreadySynced(ctx context.Context, url string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.findRequests(func(r models.DownloadQueueRequest) bool { return !r.Active && r.SpotifyURL == url })) > 0, nil
+}
+
+func (m *memoryDatabase) NewDownloadRequest(ctx context.Context, url, name string, creatorID int64, objectType spotify.SpotifyObjectType) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[id.String()] = &memoryRequest{request: models.DownloadQueueRequest{
+		ID:         id.String(),
+		SpotifyURL: url,
+		ObjectType: objectType,
+		Name:       name,
+		Active:     true,
+		CreatedAt:  time.Now().Unix(),
+		CreatorID:  creatorID,
+	}}
+	return nil
+}
+
+func (m *memoryDatabase) UpdateActiveRequest(ctx context.Context, request models.DownloadQueueRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.requests[request.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	r.request.Active = request.Active
+	r.request.SyncCount = request.SyncCount
+	r.request.Errored = request.Errored
+	r.request.RetryCount = request.RetryCount
+	r.request.ExpectedTrackCount = request.ExpectedTrackCount
+	r.request.FoundTrackCount = request.FoundTrackCount
+	r.request.TrackMetadata = slices.Clone(request.TrackMetadata)
+	r.request.ObjectType = request.ObjectType
+	r.request.UpdatedAt = request.UpdatedAt
+	return nil
+}
+
+func (m *memoryDatabase) SetRequestOutcome(ctx context.Context, id string, outcome RequestOutcome, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.requests[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	r.state.LastOutcome = outcome
+	r.state.LastError = message
+	r.state.LastAttemptAt = time.Now().Unix()
+	return nil
+}
+
+func (m *memoryDatabase) SetTrackResults(ctx context.Context, id string, results []TrackResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.requests[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	r.state.TrackResults = slices.Clone(results)
+	return nil
+}
+
+func (m *memoryDatabase) SetNextAttempt(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.requests[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	r.state.NextAttemptAt = unixOrZero(at)
+	return nil
+}
+
+func (m *memoryDatabase) ClaimNextRequest(ctx context.Context, owner string, lease time.Duration, claimedBefore time.Time) (models.DownloadQueueRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var next *memoryRequest
+	for _, r := range m.requests {
+		if !r.request.Active || r.state.LeaseExpiresAt > now.UnixMilli() || r.state.ClaimedAt >= claimedBefore.UnixMilli() ||
+			r.state.NextAttemptAt > now.Unix() {
+			continue
+		}
+		if next == nil || claimsBefore(r.request, next.request) {
+			next = r
+		}
+	}
+	if next == nil {
+		return models.DownloadQueueRequest{}, ErrNotFound
+	}
+
+	next.state.LeaseOwner = owner
+	next.state.LeaseExpiresAt = now.Add(lease).UnixMilli()
+	next.state.ClaimedAt = now.UnixMilli()
+	return cloneRequest(next.request), nil
+}
+
+func (m *memoryDatabase) RenewLease(ctx context.Context, id, owner string, lease time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.requests[id]
+	if !ok || r.state.LeaseOwner != owner {
+		return ErrLeaseLost
+	}
+
+	r.state.LeaseExpiresAt = time.Now().Add(lease).UnixMilli()
+	return nil
+}
+
+func (m *memoryDatabase) ReleaseRequest(ctx context.Context, id, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.requests[id]
+	if !ok || r.state.LeaseOwner != owner {
+		return ErrLeaseLost
+	}
+
+	r.state.LeaseOwner = ""
+	r.state.LeaseExpiresAt = 0
+	return nil
+}
+
+func (m *memoryDatabase) ReleaseExpiredLeases(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var released int64
+	now := time.Now().UnixMilli()
+	for _, r := range m.requests {
+		if r.state.LeaseExpiresAt != 0 && r.state.LeaseExpiresAt <= now {
+			r.state.LeaseOwner = ""
+			r.state.LeaseExpiresAt = 0
+			released++
+		}
+	}
+	return released, nil
+}
+
+func (m *memoryDatabase) AddDeadLetter(ctx context.Context, letter DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deadLetters[letter.RequestID] = cloneDeadLetter(letter)
+	return nil
+}
+
+func (m *memoryDatabase) GetDeadLetter(ctx context.Context, id string) (DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	letter, ok := m.deadLetters[id]
+	if !ok {
+		return DeadLetter{}, ErrNotFound
+	}
+	return cloneDeadLetter(letter), nil
+}
+
+func (m *memoryDatabase) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	letters := make([]DeadLetter, 0, len(m.deadLetters))
+	for _, letter := range m.deadLetters {
+		letters = append(letters, cloneDeadLetter(letter))
+	}
+	sort.SliceStable(letters, func(i, j int) bool { return letters[i].CreatedAt > letters[j].CreatedAt })
+	return letters, nil
+}
+
+func (m *memoryDatabase) DeleteDeadLetter(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.deadLetters[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.deadLetters, id)
+	return nil
+}
+
+func (m *memoryDatabase) GetActivePlaylists(ctx context.Context) ([]models.PlaylistRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var playlists []models.PlaylistRequest
+	for _, p := range m.playlists {
+		if p.request.Active {
+			playlists = append(playlists, p.request)
+		}
+	}
+	sort.SliceStable(playlists, func(i, j int) bool { return playlists[i].CreatedAt < playlists[j].CreatedAt })
+	return playlists, nil
+}
+
+func (m *memoryDatabase) NewPlaylistRequest(ctx context.Context, url string, creatorID int64) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.playlists[id.String()] = &memoryPlaylist{request: models.PlaylistRequest{
+		ID:         id.String(),
+		SpotifyURL: url,
+		Active:     true,
+		CreatorID:  creatorID,
+		CreatedAt:  time.Now().Unix(),
+	}}
+	return nil
+}
+
+func (m *memoryDatabase) UpdatePlaylistRequest(ctx context.Context, request models.PlaylistRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.playlists[request.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	p.request.Active = request.Active
+	p.request.Errored = request.Errored
+	p.request.RetryCount = request.RetryCount
+	return nil
+}
+
+func (m *memoryDatabase) GetPlaylistState(ctx context.Context, id string) (PlaylistState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.playlists[id]
+	if !ok {
+		return PlaylistState{}, ErrNotFound
+	}
+	return clonePlaylistState(p.state), nil
+}
+
+func (m *memoryDatabase) SetPlaylistState(ctx context.Context, id string, state PlaylistState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.playlists[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	// Formats belong to whoever created the request, the next attempt is set on its own
+	state = clonePlaylistState(state)
+	state.Formats = p.state.Formats
+	state.NextAttemptAt = p.state.NextAttemptAt
+	p.state = state
+	return nil
+}
+
+func (m *memoryDatabase) SetPlaylistNextAttempt(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.playlists[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	p.state.NextAttemptAt = unixOrZero(at)
+	return nil
+}
+
+func (m *memoryDatabase) ReactivateSyncedPlaylists(ctx context.Context, syncedBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var reactivated int64
+	for _, p := range m.playlists {
+		if p.request.Active || p.request.Errored || p.state.LastSyncedAt <= 0 || p.state.LastSyncedAt >= syncedBefore.Unix() {
+			continue
+		}
+		p.request.Active = true
+		p.request.Errored = false
+		p.request.RetryCount = 0
+		p.state.NextAttemptAt = 0
+		reactivated++
+	}
+	return reactivated, nil
+}
+
+func (m *memoryDatabase) FindMatchCandidates(ctx context.Context, titles []string) ([]LibraryFile, error) {
+	keys := make(map[string]bool, len(titles))
+	for _, title := range titles {
+		if key := match.TitleKey(title); key != "" {
+			keys[key] = true
+		}
+	}
+
+	return m.findFiles(func(f *memoryFile) bool { return keys[f.matchKey] }), nil
+}
+
+func (m *memoryDatabase) FindMusicFilesByISRC(ctx context.Context, isrcs []string) ([]LibraryFile, error) {
+	return m.findFiles(func(f *memoryFile) bool { return f.file.ISRC != "" && slices.Contains(isrcs, f.file.ISRC) }), nil
+}
+
+func (m *memoryDatabase) FindMusicFilesBySpotifyID(ctx context.Context, ids []string) ([]LibraryFile, error) {
+	return m.findFiles(func(f *memoryFile) bool { return f.file.SpotifyID != "" && slices.Contains(ids, f.file.SpotifyID) }), nil
+}
+
+func (m *memoryDatabase) IndexMusicFile(ctx context.Context, file models.MusicFile, entry LibraryEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.files[entry.Path]; ok {
+		file.ID = existing.file.ID
+		file.CreatedAt = existing.file.CreatedAt
+	} else {
+		file.ID = uuid.Must(uuid.NewV4()).String()
+		file.CreatedAt = time.Now().Unix()
+	}
+	file.Path = entry.Path
+	file.Album = entry.Album
+
+	m.files[entry.Path] = &memoryFile{
+		file: LibraryFile{
+			MusicFile:  file,
+			DurationMs: entry.DurationMs,
+			ISRC:       entry.ISRC,
+			SpotifyID:  entry.SpotifyID,
+		},
+		entry:    entry,
+		matchKey: match.TitleKey(file.Title),
+	}
+	return nil
+}
+
+func (m *memoryDatabase) MoveMusicFile(ctx context.Context, from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[from]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(m.files, from)
+	f.file.Path = to
+	f.entry.Path = to
+	m.files[to] = f
+	return nil
+}
+
+func (m *memoryDatabase) DeleteMusicFiles(ctx context.Context, paths []string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for _, path := range paths {
+		if _, ok := m.files[path]; ok {
+			delete(m.files, path)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *memoryDatabase) ListLibraryEntries(ctx context.Context) ([]LibraryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make([]LibraryEntry, 0, len(m.files))
+	for _, f := range m.files {
+		entries = append(entries, LibraryEntry{
+			Path:    f.entry.Path,
+			Hash:    f.entry.Hash,
+			Size:    f.entry.Size,
+			ModTime: f.entry.ModTime,
+		})
+	}
+	return entries, nil
+}
+
+func (m *memoryDatabase) GetIndexStatus(ctx context.Context) (models.IndexStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.indexStatus, nil
+}
+
+func (m *memoryDatabase) UpdateIndexStatus(ctx context.Context, status models.IndexStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.indexStatus = status
+	return nil
+}
+
+func (m *memoryDatabase) Ping(ctx context.Context) error {
+	return nil
+}
+
+// findRequests returns copies of the requests matching keep, the caller holds the lock
+func (m *memoryDatabase) findRequests(keep func(models.DownloadQueueRequest) bool) []models.DownloadQueueRequest {
+	requests := make([]models.DownloadQueueRequest, 0)
+	for _, r := range m.requests {
+		if keep(r.request) {
+			requests = append(requests, cloneRequest(r.request))
+		}
+	}
+	return requests
+}
+
+func (m *memoryDatabase) findFiles(keep func(*memoryFile) bool) []LibraryFile {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make([]LibraryFile, 0)
+	for _, f := range m.files {
+		if keep(f) {
+			files = append(files, f.file)
+		}
+	}
+	return files
+}
+
+// claimsBefore reports whether a is claimed before b, errored requests come last
+func claimsBefore(a, b models.DownloadQueueRequest) bool {
+	if a.Errored != b.Errored {
+		return !a.Errored
+	}
+	return a.CreatedAt < b.CreatedAt
+}
+
+func cloneRequest(request models.DownloadQueueRequest) models.DownloadQueueRequest {
+	request.TrackMetadata = slices.Clone(request.TrackMetadata)
+	return request
+}
+
+func cloneDeadLetter(letter DeadLetter) DeadLetter {
+	letter.OutputTail = slices.Clone(letter.OutputTail)
+	letter.SkippedTracks = slices.Clone(letter.SkippedTracks)
+	return letter
+}
+
+func clonePlaylistState(state PlaylistState) PlaylistState {
+	state.Tracks = slices.Clone(state.Tracks)
+	state.Formats = slices.Clone(state.Formats)
+	if state.LastDiff != nil {
+		diff := *state.LastDiff
+		diff.Added = slices.Clone(diff.Added)
+		diff.Removed = slices.Clone(diff.Removed)
+		state.LastDiff = &diff
+	}
+	return state
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PlaylistState is the bookkeeping this service stores on a playlist request
+// document next to the fields of the shared models.PlaylistRequest
+type PlaylistState struct {
+	// Tracks are the tracks of the playlist file as last written, in playlist order
+	Tracks       []PlaylistTrack `bson:"synced_tracks,omitempty"`
+	LastDiff     *PlaylistDiff   `bson:"last_diff,omitempty"`
+	LastSyncedAt int64           `bson:"last_synced_at,omitempty"`
+	// Formats are the playlist file formats requested for this playlist, empty uses the configured ones.
+	// It is set by whoever creates the request and never written by SetPlaylistState.
+	Formats []string `bson:"formats,omitempty"`
+	// NextAttemptAt is the unix time before which a failed playlist is not processed again.
+	// It is set with SetPlaylistNextAttempt and never written by SetPlaylistState.
+	NextAttemptAt int64 `bson:"next_attempt_at,omitempty"`
+}
+
+// PlaylistTrack identifies a track of a written playlist
+type PlaylistTrack struct {
+	SpotifyID string `bson:"spotify_id,omitempty"`
+	Artist    string `bson:"artist"`
+	Title     string `bson:"title"`
+}
+
+// PlaylistDiff is the change of a playlist file compared to the previous version
+type PlaylistDiff struct {
+	Added   []PlaylistTrack `bson:"added"`
+	Removed []PlaylistTrack `bson:"removed"`
+	At      int64           `bson:"at"`
+}
+
+// GetPlaylistState returns the service owned bookkeeping of a playlist request
+func (d *db) GetPlaylistState(ctx context.Context, id string) (PlaylistState, error) {
+	var state PlaylistState
+	err := d.playlistsCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return PlaylistState{}, ErrNotFound
+	}
+	if err != nil {
+		return PlaylistState{}, err
+	}
+
+	return state, nil
+}
+
+// SetPlaylistState replaces the service owned bookkeeping of a playlist request
+func (d *db) SetPlaylistState(ctx context.Context, id string, state PlaylistState) error {
+	info, err := d.playlistsCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"synced_tracks":  state.Tracks,
+		"last_diff":      state.LastDiff,
+		"last_synced_at": state.LastSyncedAt,
+	}})
+	if err != nil {
+		return err
+	}
+
+	if info.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetPlaylistNextAttempt holds a playlist request back until at, the zero time clears it
+func (d *db) SetPlaylistNextAttempt(ctx context.Context, id string, at time.Time) error {
+	info, err := d.playlistsCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"next_attempt_at": unixOrZero(at),
+	}})
+	if err != nil {
+		return err
+	}
+
+	if info.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ReactivateSyncedPlaylists reactivates the inactive playlists last synced before syncedBefore
+// so they get refreshed with the tracks added upstream since. Playlists given up after an error are left alone.
+func (d *db) ReactivateSyncedPlaylists(ctx context.Context, syncedBefore time.Time) (int64, error) {
+	info, err := d.playlistsCollection().UpdateMany(ctx, bson.M{
+		"active":         false,
+		"errored":        bson.M{"$ne": true},
+		"last_synced_at": bson.M{"$gt": 0, "$lt": syncedBefore.Unix()},
+	}, bson.M{"$set": bson.M{
+		"active":          true,
+		"errored":         false,
+		"retry_count":     0,
+		"next_attempt_at": 0,
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	return info.ModifiedCount, nil
+}
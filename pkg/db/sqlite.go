@@ -0,0 +1,716 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/match"
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+
+	// pure Go driver, the binary stays CGO_ENABLED=0
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations create and evolve the schema, PRAGMA user_version is the number applied.
+// Only ever append to this list.
+var sqliteMigrations = []string{
+	`CREATE TABLE download_requests (
+		id TEXT PRIMARY KEY,
+		spotify_url TEXT NOT NULL,
+		object_type TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		active INTEGER NOT NULL DEFAULT 0,
+		errored INTEGER NOT NULL DEFAULT 0,
+		sync_count INTEGER NOT NULL DEFAULT 0,
+		retry_count INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL DEFAULT 0,
+		creator_id INTEGER NOT NULL DEFAULT 0,
+		expected_track_count INTEGER NOT NULL DEFAULT 0,
+		found_track_count INTEGER NOT NULL DEFAULT 0,
+		track_metadata TEXT NOT NULL DEFAULT '[]',
+		last_outcome TEXT NOT NULL DEFAULT '',
+		last_error TEXT NOT NULL DEFAULT '',
+		last_attempt_at INTEGER NOT NULL DEFAULT 0,
+		track_results TEXT NOT NULL DEFAULT '[]'
+	);
+	CREATE INDEX download_requests_active ON download_requests (active, spotify_url);
+	CREATE INDEX download_requests_created_at ON download_requests (created_at);
+
+	CREATE TABLE playlist_requests (
+		id TEXT PRIMARY KEY,
+		spotify_url TEXT NOT NULL,
+		active INTEGER NOT NULL DEFAULT 0,
+		errored INTEGER NOT NULL DEFAULT 0,
+		retry_count INTEGER NOT NULL DEFAULT 0,
+		no_pull INTEGER NOT NULL DEFAULT 0,
+		creator_id INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL DEFAULT 0,
+		synced_tracks TEXT NOT NULL DEFAULT '[]',
+		last_diff TEXT NOT NULL DEFAULT '',
+		last_synced_at INTEGER NOT NULL DEFAULT 0,
+		formats TEXT NOT NULL DEFAULT '[]'
+	);
+
+	CREATE TABLE music_files (
+		id TEXT PRIMARY KEY,
+		path TEXT NOT NULL UNIQUE,
+		artist TEXT NOT NULL DEFAULT '',
+		title TEXT NOT NULL DEFAULT '',
+		album TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL DEFAULT 0,
+		content_hash TEXT NOT NULL DEFAULT '',
+		size INTEGER NOT NULL DEFAULT 0,
+		mod_time INTEGER NOT NULL DEFAULT 0,
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		isrc TEXT NOT NULL DEFAULT '',
+		spotify_id TEXT NOT NULL DEFAULT '',
+		match_key TEXT NOT NULL DEFAULT '',
+		indexed_at INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX music_files_match_key ON music_files (match_key);
+	CREATE INDEX music_files_isrc ON music_files (isrc) WHERE isrc != '';
+	CREATE INDEX music_files_spotify_id ON music_files (spotify_id) WHERE spotify_id != '';
+
+	CREATE TABLE index_status (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		last_updated INTEGER NOT NULL DEFAULT 0,
+		last_indexed INTEGER NOT NULL DEFAULT 0
+	);`,
+
+	`ALTER TABLE download_requests ADD COLUMN lease_owner TEXT NOT NULL DEFAULT '';
+	ALTER TABLE download_requests ADD COLUMN lease_expires_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE download_requests ADD COLUMN claimed_at INTEGER NOT NULL DEFAULT 0;`,
+
+	`ALTER TABLE download_requests ADD COLUMN next_attempt_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE playlist_requests ADD COLUMN next_attempt_at INTEGER NOT NULL DEFAULT 0;`,
+
+	`CREATE TABLE dead_letters (
+		request_id TEXT PRIMARY KEY,
+		spotify_url TEXT NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		object_type TEXT NOT NULL DEFAULT '',
+		creator_id INTEGER NOT NULL DEFAULT 0,
+		sync_count INTEGER NOT NULL DEFAULT 0,
+		retry_count INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		output_tail TEXT NOT NULL DEFAULT '[]',
+		skipped_tracks TEXT NOT NULL DEFAULT '[]',
+		created_at INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX dead_letters_created_at ON dead_letters (created_at);`,
+}
+
+const requestColumns = `id, spotify_url, object_type, name, active, errored, sync_count, retry_count,
+	created_at, updated_at, creator_id, expected_track_count, found_track_count, track_metadata`
+
+const playlistColumns = `id, spotify_url, active, errored, retry_count, no_pull, creator_id, created_at`
+
+const deadLetterColumns = `request_id, spotify_url, name, object_type, creator_id, sync_count, retry_count,
+	error, output_tail, skipped_tracks, created_at`
+
+const libraryFileColumns = `id, path, artist, title, album, created_at, duration_ms, isrc, spotify_id`
+
+// sqliteDatabase stores everything in a single SQLite file for small single box deployments
+type sqliteDatabase struct {
+	conn *sql.DB
+	log  *zap.Logger
+}
+
+// NewSQLiteDatabase opens or creates the database file at path and migrates its schema
+func NewSQLiteDatabase(ctx context.Context, log *zap.Logger, path string) (Database, error) {
+	dsn := "file:" + path + "?" + url.Values{"_pragma": {
+		"busy_timeout(10000)",
+		"journal_mode(WAL)",
+		"synchronous(NORMAL)",
+	}}.Encode()
+
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer, one connection avoids busy errors between the download workers
+	conn.SetMaxOpenConns(1)
+
+	d := &sqliteDatabase{conn: conn, log: log}
+	if err := d.migrate(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to migrate sqlite database: %w", err)
+	}
+
+	return d, nil
+}
+
+func (d *sqliteDatabase) migrate(ctx context.Context) error {
+	var version int
+	if err := d.conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := d.conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA doesn't take parameters
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		d.log.Info("applied sqlite migration", zap.Int("version", i+1))
+	}
+
+	return nil
+}
+
+func (d *sqliteDatabase) GetActiveRequests(ctx context.Context) ([]models.DownloadQueueRequest, error) {
+	return d.queryRequests(ctx, "WHERE active = 1 ORDER BY created_at")
+}
+
+func (d *sqliteDatabase) GetActiveRequest(ctx context.Context, url string) (models.DownloadQueueRequest, error) {
+	requests, err := d.queryRequests(ctx, "WHERE active = 1 AND spotify_url = ? LIMIT 1", url)
+	if err != nil {
+		return models.DownloadQueueRequest{}, err
+	}
+	if len(requests) == 0 {
+		return models.DownloadQueueRequest{}, ErrNotFound
+	}
+	return requests[0], nil
+}
+
+func (d *sqliteDatabase) GetRequest(ctx context.Context, id string) (models.DownloadQueueRequest, error) {
+	requests, err := d.queryRequests(ctx, "WHERE id = ?", id)
+	if err != nil {
+		return models.DownloadQueueRequest{}, err
+	}
+	if len(requests) == 0 {
+		return models.DownloadQueueRequest{}, ErrNotFound
+	}
+	return requests[0], nil
+}
+
+func (d *sqliteDatabase) ListRequests(ctx context.Context, status RequestStatus) ([]models.DownloadQueueRequest, error) {
+	where := ""
+	switch status {
+	case RequestStatusActive:
+		where = "WHERE active = 1"
+	case RequestStatusCompleted:
+		where = "WHERE active = 0 AND errored = 0"
+	case RequestStatusErrored:
+		where = "WHERE errored = 1"
+	}
+
+	return d.queryRequests(ctx, where+" ORDER BY created_at DESC")
+}
+
+func (d *sqliteDatabase) GetRequestState(ctx context.Context, id string) (RequestState, error) {
+	var state RequestState
+	var results string
+	err := d.conn.QueryRowContext(ctx,
+		`SELECT last_outcome, last_error, last_attempt_at, track_results, next_attempt_at, lease_owner, lease_expires_at, claimed_at
+		FROM download_requests WHERE id = ?`, id).
+		Scan(&state.LastOutcome, &state.LastError, &state.LastAttemptAt, &results, &state.NextAttemptAt,
+			&state.LeaseOwner, &state.LeaseExpiresAt, &state.ClaimedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RequestState{}, ErrNotFound
+	}
+	if err != nil {
+		return RequestState{}, err
+	}
+
+	if err := unmarshalColumn(results, &state.TrackResults); err != nil {
+		return RequestState{}, err
+	}
+	return state, nil
+}
+
+func (d *sqliteDatabase) CheckIfRequestAlreadySynced(ctx context.Context, url string) (bool, error) {
+	var count int
+	err := d.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM download_requests WHERE spotify_url = ? AND active = 0", url).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (d *sqliteDatabase) NewDownloadRequest(ctx context.Context, url, name string, creatorID int64, objectType spotify.SpotifyObjectType) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	_, err = d.conn.ExecContext(ctx,
+		"INSERT INTO download_requests (id, spotify_url, object_type, name, active, created_at, creator_id) VALUES (?, ?, ?, ?, 1, ?, ?)",
+		id.String(), url, string(objectType), name, time.Now().Unix(), creatorID)
+	return err
+}
+
+func (d *sqliteDatabase) UpdateActiveRequest(ctx context.Context, request models.DownloadQueueRequest) error {
+	tracks, err := marshalColumn(request.TrackMetadata)
+	if err != nil {
+		return err
+	}
+
+	return d.execOne(ctx, `UPDATE download_requests SET active = ?, sync_count = ?, errored = ?, retry_count = ?,
+		expected_track_count = ?, found_track_count = ?, track_metadata = ?, object_type = ?, updated_at = ? WHERE id = ?`,
+		request.Active, request.SyncCount, request.Errored, request.RetryCount,
+		request.ExpectedTrackCount, request.FoundTrackCount, tracks, string(request.ObjectType), request.UpdatedAt, request.ID)
+}
+
+func (d *sqliteDatabase) SetRequestOutcome(ctx context.Context, id string, outcome RequestOutcome, message string) error {
+	return d.execOne(ctx, "UPDATE download_requests SET last_outcome = ?, last_error = ?, last_attempt_at = ? WHERE id = ?",
+		string(outcome), message, time.Now().Unix(), id)
+}
+
+func (d *sqliteDatabase) SetTrackResults(ctx context.Context, id string, results []TrackResult) error {
+	encoded, err := marshalColumn(results)
+	if err != nil {
+		return err
+	}
+	return d.execOne(ctx, "UPDATE download_requests SET track_results = ? WHERE id = ?", encoded, id)
+}
+
+func (d *sqliteDatabase) SetNextAttempt(ctx context.Context, id string, at time.Time) error {
+	return d.execOne(ctx, "UPDATE download_requests SET next_attempt_at = ? WHERE id = ?", unixOrZero(at), id)
+}
+
+func (d *sqliteDatabase) ClaimNextRequest(ctx context.Context, owner string, lease time.Duration, claimedBefore time.Time) (models.DownloadQueueRequest, error) {
+	now := time.Now()
+	// a single statement, so concurrent claims from other processes can't pick the same row
+	rows, err := d.conn.QueryContext(ctx, `UPDATE download_requests SET lease_owner = ?, lease_expires_at = ?, claimed_at = ?
+		WHERE id = (SELECT id FROM download_requests WHERE active = 1 AND lease_expires_at <= ? AND claimed_at < ?
+			AND next_attempt_at <= ? ORDER BY errored, created_at LIMIT 1)
+		RETURNING `+requestColumns,
+		owner, now.Add(lease).UnixMilli(), now.UnixMilli(), now.UnixMilli(), claimedBefore.UnixMilli(), now.Unix())
+	if err != nil {
+		return models.DownloadQueueRequest{}, err
+	}
+
+	requests, err := scanRequests(rows)
+	if err != nil {
+		return models.DownloadQueueRequest{}, err
+	}
+	if len(requests) == 0 {
+		return models.DownloadQueueRequest{}, ErrNotFound
+	}
+	return requests[0], nil
+}
+
+func (d *sqliteDatabase) RenewLease(ctx context.Context, id, owner string, lease time.Duration) error {
+	err := d.execOne(ctx, "UPDATE download_requests SET lease_expires_at = ? WHERE id = ? AND lease_owner = ?",
+		time.Now().Add(lease).UnixMilli(), id, owner)
+	if errors.Is(err, ErrNotFound) {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+func (d *sqliteDatabase) ReleaseRequest(ctx context.Context, id, owner string) error {
+	err := d.execOne(ctx, "UPDATE download_requests SET lease_owner = '', lease_expires_at = 0 WHERE id = ? AND lease_owner = ?", id, owner)
+	if errors.Is(err, ErrNotFound) {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+func (d *sqliteDatabase) ReleaseExpiredLeases(ctx context.Context) (int64, error) {
+	res, err := d.conn.ExecContext(ctx, `UPDATE download_requests SET lease_owner = '', lease_expires_at = 0
+		WHERE lease_expires_at > 0 AND lease_expires_at <= ?`, time.Now().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (d *sqliteDatabase) AddDeadLetter(ctx context.Context, letter DeadLetter) error {
+	tail, err := marshalColumn(letter.OutputTail)
+	if err != nil {
+		return err
+	}
+	skipped, err := marshalColumn(letter.SkippedTracks)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.conn.ExecContext(ctx, "INSERT OR REPLACE INTO dead_letters ("+deadLetterColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		letter.RequestID, letter.SpotifyURL, letter.Name, string(letter.ObjectType), letter.CreatorID, letter.SyncCount, letter.RetryCount,
+		letter.Error, tail, skipped, letter.CreatedAt)
+	return err
+}
+
+func (d *sqliteDatabase) GetDeadLetter(ctx context.Context, id string) (DeadLetter, error) {
+	letters, err := d.queryDeadLetters(ctx, "WHERE request_id = ?", id)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	if len(letters) == 0 {
+		return DeadLetter{}, ErrNotFound
+	}
+	return letters[0], nil
+}
+
+func (d *sqliteDatabase) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	return d.queryDeadLetters(ctx, "ORDER BY created_at DESC")
+}
+
+func (d *sqliteDatabase) DeleteDeadLetter(ctx context.Context, id string) error {
+	return d.execOne(ctx, "DELETE FROM dead_letters WHERE request_id = ?", id)
+}
+
+// queryDeadLetters returns the dead letters selected by the where and order clauses in query
+func (d *sqliteDatabase) queryDeadLetters(ctx context.Context, query string, args ...any) ([]DeadLetter, error) {
+	rows, err := d.conn.QueryContext(ctx, "SELECT "+deadLetterColumns+" FROM dead_letters "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := make([]DeadLetter, 0)
+	for rows.Next() {
+		var l DeadLetter
+		var tail, skipped string
+		if err := rows.Scan(&l.RequestID, &l.SpotifyURL, &l.Name, &l.ObjectType, &l.CreatorID, &l.SyncCount, &l.RetryCount,
+			&l.Error, &tail, &skipped, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := unmarshalColumn(tail, &l.OutputTail); err != nil {
+			return nil, err
+		}
+		if err := unmarshalColumn(skipped, &l.SkippedTracks); err != nil {
+			return nil, err
+		}
+		letters = append(letters, l)
+	}
+	return letters, rows.Err()
+}
+
+func (d *sqliteDatabase) GetActivePlaylists(ctx context.Context) ([]models.PlaylistRequest, error) {
+	rows, err := d.conn.QueryContext(ctx, "SELECT "+playlistColumns+" FROM playlist_requests WHERE active = 1 ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var playlists []models.PlaylistRequest
+	for rows.Next() {
+		var p models.PlaylistRequest
+		if err := rows.Scan(&p.ID, &p.SpotifyURL, &p.Active, &p.Errored, &p.RetryCount, &p.NoPull, &p.CreatorID, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, p)
+	}
+	return playlists, rows.Err()
+}
+
+func (d *sqliteDatabase) NewPlaylistRequest(ctx context.Context, url string, creatorID int64) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	_, err = d.conn.ExecContext(ctx,
+		"INSERT INTO playlist_requests (id, spotify_url, active, creator_id, created_at) VALUES (?, ?, 1, ?, ?)",
+		id.String(), url, creatorID, time.Now().Unix())
+	return err
+}
+
+func (d *sqliteDatabase) UpdatePlaylistRequest(ctx context.Context, request models.PlaylistRequest) error {
+	return d.execOne(ctx, "UPDATE playlist_requests SET active = ?, errored = ?, retry_count = ? WHERE id = ?",
+		request.Active, request.Errored, request.RetryCount, request.ID)
+}
+
+func (d *sqliteDatabase) GetPlaylistState(ctx context.Context, id string) (PlaylistState, error) {
+	var state PlaylistState
+	var tracks, diff, formats string
+	err := d.conn.QueryRowContext(ctx,
+		"SELECT synced_tracks, last_diff, last_synced_at, formats, next_attempt_at FROM playlist_requests WHERE id = ?", id).
+		Scan(&tracks, &diff, &state.LastSyncedAt, &formats, &state.NextAttemptAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PlaylistState{}, ErrNotFound
+	}
+	if err != nil {
+		return PlaylistState{}, err
+	}
+
+	if err := unmarshalColumn(tracks, &state.Tracks); err != nil {
+		return PlaylistState{}, err
+	}
+	if err := unmarshalColumn(diff, &state.LastDiff); err != nil {
+		return PlaylistState{}, err
+	}
+	if err := unmarshalColumn(formats, &state.Formats); err != nil {
+		return PlaylistState{}, err
+	}
+	return state, nil
+}
+
+func (d *sqliteDatabase) SetPlaylistState(ctx context.Context, id string, state PlaylistState) error {
+	tracks, err := marshalColumn(state.Tracks)
+	if err != nil {
+		return err
+	}
+	diff := ""
+	if state.LastDiff != nil {
+		if diff, err = marshalColumn(state.LastDiff); err != nil {
+			return err
+		}
+	}
+
+	return d.execOne(ctx, "UPDATE playlist_requests SET synced_tracks = ?, last_diff = ?, last_synced_at = ? WHERE id = ?",
+		tracks, diff, state.LastSyncedAt, id)
+}
+
+func (d *sqliteDatabase) SetPlaylistNextAttempt(ctx context.Context, id string, at time.Time) error {
+	return d.execOne(ctx, "UPDATE playlist_requests SET next_attempt_at = ? WHERE id = ?", unixOrZero(at), id)
+}
+
+func (d *sqliteDatabase) ReactivateSyncedPlaylists(ctx context.Context, syncedBefore time.Time) (int64, error) {
+	res, err := d.conn.ExecContext(ctx, `UPDATE playlist_requests SET active = 1, errored = 0, retry_count = 0, next_attempt_at = 0
+		WHERE active = 0 AND errored = 0 AND last_synced_at > 0 AND last_synced_at < ?`, syncedBefore.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (d *sqliteDatabase) FindMatchCandidates(ctx context.Context, titles []string) ([]LibraryFile, error) {
+	keys := make([]string, 0, len(titles))
+	for _, title := range titles {
+		if key := match.TitleKey(title); key != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return d.findLibraryFilesIn(ctx, "match_key", keys)
+}
+
+func (d *sqliteDatabase) FindMusicFilesByISRC(ctx context.Context, isrcs []string) ([]LibraryFile, error) {
+	return d.findLibraryFilesIn(ctx, "isrc", isrcs)
+}
+
+func (d *sqliteDatabase) FindMusicFilesBySpotifyID(ctx context.Context, ids []string) ([]LibraryFile, error) {
+	return d.findLibraryFilesIn(ctx, "spotify_id", ids)
+}
+
+// findLibraryFilesIn looks up the files whose column is one of values, in chunks of lookupChunkSize
+func (d *sqliteDatabase) findLibraryFilesIn(ctx context.Context, column string, values []string) ([]LibraryFile, error) {
+	files := make([]LibraryFile, 0)
+	values = slices.DeleteFunc(slices.Clone(values), func(v string) bool { return v == "" })
+
+	for start := 0; start < len(values); start += lookupChunkSize {
+		chunk := values[start:min(start+lookupChunkSize, len(values))]
+		rows, err := d.conn.QueryContext(ctx,
+			"SELECT "+libraryFileColumns+" FROM music_files WHERE "+column+" IN ("+placeholders(len(chunk))+")", anySlice(chunk)...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var f LibraryFile
+			if err := rows.Scan(&f.ID, &f.Path, &f.Artist, &f.Title, &f.Album, &f.CreatedAt, &f.DurationMs, &f.ISRC, &f.SpotifyID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			files = append(files, f)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+func (d *sqliteDatabase) IndexMusicFile(ctx context.Context, file models.MusicFile, entry LibraryEntry) error {
+	now := time.Now().Unix()
+	// the id and creation time of an already indexed path are kept by the upsert
+	_, err := d.conn.ExecContext(ctx, `INSERT INTO music_files
+		(id, path, artist, title, album, created_at, content_hash, size, mod_time, duration_ms, isrc, spotify_id, match_key, indexed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET
+			artist = excluded.artist, title = excluded.title, album = excluded.album,
+			content_hash = excluded.content_hash, size = excluded.size, mod_time = excluded.mod_time,
+			duration_ms = excluded.duration_ms, isrc = excluded.isrc, spotify_id = excluded.spotify_id,
+			match_key = excluded.match_key, indexed_at = excluded.indexed_at`,
+		uuid.Must(uuid.NewV4()).String(), entry.Path, file.Artist, file.Title, entry.Album, now,
+		entry.Hash, entry.Size, entry.ModTime, entry.DurationMs, entry.ISRC, entry.SpotifyID, match.TitleKey(file.Title), now)
+	return err
+}
+
+func (d *sqliteDatabase) MoveMusicFile(ctx context.Context, from, to string) error {
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// a stale entry at the destination is replaced, like a rename would
+	if from != to {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM music_files WHERE path = ?", to); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, "UPDATE music_files SET path = ? WHERE path = ?", to, from)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (d *sqliteDatabase) DeleteMusicFiles(ctx context.Context, paths []string) (int64, error) {
+	var deleted int64
+	for start := 0; start < len(paths); start += deleteChunkSize {
+		chunk := paths[start:min(start+deleteChunkSize, len(paths))]
+		res, err := d.conn.ExecContext(ctx, "DELETE FROM music_files WHERE path IN ("+placeholders(len(chunk))+")", anySlice(chunk)...)
+		if err != nil {
+			return deleted, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+func (d *sqliteDatabase) ListLibraryEntries(ctx context.Context) ([]LibraryEntry, error) {
+	rows, err := d.conn.QueryContext(ctx, "SELECT path, content_hash, size, mod_time FROM music_files")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]LibraryEntry, 0)
+	for rows.Next() {
+		var entry LibraryEntry
+		if err := rows.Scan(&entry.Path, &entry.Hash, &entry.Size, &entry.ModTime); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (d *sqliteDatabase) GetIndexStatus(ctx context.Context) (models.IndexStatus, error) {
+	var status models.IndexStatus
+	err := d.conn.QueryRowContext(ctx, "SELECT last_updated, last_indexed FROM index_status WHERE id = 1").
+		Scan(&status.LastUpdated, &status.LastIndexed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.IndexStatus{}, nil
+	}
+	return status, err
+}
+
+func (d *sqliteDatabase) UpdateIndexStatus(ctx context.Context, status models.IndexStatus) error {
+	_, err := d.conn.ExecContext(ctx, `INSERT INTO index_status (id, last_updated, last_indexed) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET last_updated = excluded.last_updated, last_indexed = excluded.last_indexed`,
+		status.LastUpdated, status.LastIndexed)
+	return err
+}
+
+func (d *sqliteDatabase) Ping(ctx context.Context) error {
+	return d.conn.PingContext(ctx)
+}
+
+// queryRequests returns the download requests selected by the where, order and limit clauses in query
+func (d *sqliteDatabase) queryRequests(ctx context.Context, query string, args ...any) ([]models.DownloadQueueRequest, error) {
+	rows, err := d.conn.QueryContext(ctx, "SELECT "+requestColumns+" FROM download_requests "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanRequests(rows)
+}
+
+// scanRequests reads and closes rows of requestColumns
+func scanRequests(rows *sql.Rows) ([]models.DownloadQueueRequest, error) {
+	defer rows.Close()
+
+	requests := make([]models.DownloadQueueRequest, 0)
+	for rows.Next() {
+		var r models.DownloadQueueRequest
+		var tracks string
+		if err := rows.Scan(&r.ID, &r.SpotifyURL, &r.ObjectType, &r.Name, &r.Active, &r.Errored, &r.SyncCount, &r.RetryCount,
+			&r.CreatedAt, &r.UpdatedAt, &r.CreatorID, &r.ExpectedTrackCount, &r.FoundTrackCount, &tracks); err != nil {
+			return nil, err
+		}
+		if err := unmarshalColumn(tracks, &r.TrackMetadata); err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+	return requests, rows.Err()
+}
+
+// execOne runs an update of a single row, ErrNotFound if no row matched
+func (d *sqliteDatabase) execOne(ctx context.Context, query string, args ...any) error {
+	res, err := d.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// marshalColumn encodes a nested value as JSON text, nil slices are stored as empty lists
+func marshalColumn(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if string(b) == "null" {
+		return "[]", nil
+	}
+	return string(b), nil
+}
+
+// unmarshalColumn decodes a JSON text column, empty text and empty lists leave v untouched
+func unmarshalColumn(s string, v any) error {
+	if s == "" || s == "[]" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s), v)
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func anySlice(values []string) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
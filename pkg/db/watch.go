@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// ErrChangeStreamsUnsupported is returned by WatchQueues when the deployment is not a replica set
+	ErrChangeStreamsUnsupported = errors.New("change streams are not supported by the deployment")
+)
+
+// changeStreamUnsupportedCodes are the server errors of a $changeStream on a standalone server
+var changeStreamUnsupportedCodes = []int32{
+	40573, // The $changeStream stage is only supported on replica sets
+	40324, // Unrecognized pipeline stage name, servers before 3.6
+}
+
+// WatchQueues subscribes to the download and playlist request collections and calls notify
+// for every inserted active request and every request that was set active again.
+// It blocks until ctx is done or the stream fails.
+func (d *db) WatchQueues(ctx context.Context, notify func()) error {
+	d.connMu.Lock()
+	database := d.conn.Database(d.dbname)
+	d.connMu.Unlock()
+
+	pipeline := bson.A{bson.M{"$match": bson.M{
+		"ns.coll": bson.M{"$in": bson.A{"download-queue-requests", "playlist-requests"}},
+		"$or": bson.A{
+			bson.M{"operationType": bson.M{"$in": bson.A{"insert", "replace"}}, "fullDocument.active": true},
+			// only changed fields are listed, a $set of an unchanged active flag doesn't show up
+			bson.M{"operationType": "update", "updateDescription.updatedFields.active": true},
+		},
+	}}}
+
+	stream, err := database.Watch(ctx, pipeline)
+	if err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) {
+			for _, code := range changeStreamUnsupportedCodes {
+				if cmdErr.Code == code {
+					return ErrChangeStreamsUnsupported
+				}
+			}
+		}
+		return err
+	}
+	defer stream.Close(context.WithoutCancel(ctx))
+
+	for stream.Next(ctx) {
+		notify()
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return stream.Err()
+}
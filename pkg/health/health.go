@@ -0,0 +1,205 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"go.uber.org/zap"
+)
+
+const (
+	// checkTimeout bounds every single readiness check
+	checkTimeout = 5 * time.Second
+	// versionCacheTTL avoids starting a python interpreter on every probe
+	versionCacheTTL = 5 * time.Minute
+)
+
+// Heartbeat is implemented by the processing loop
+type Heartbeat interface {
+	LastTick() time.Time
+}
+
+// Checker serves the liveness and readiness probes
+type Checker struct {
+	database  db.Database
+	heartbeat Heartbeat
+	log       *zap.Logger
+
+	binary      string
+	destination string
+	libraryPath string
+	maxTickAge  time.Duration
+
+	versionMu      sync.Mutex
+	version        string
+	versionErr     error
+	versionChecked time.Time
+}
+
+func NewChecker(database db.Database, heartbeat Heartbeat, log *zap.Logger, binary, destination, libraryPath string, maxTickAge time.Duration) *Checker {
+	return &Checker{
+		database:    database,
+		heartbeat:   heartbeat,
+		log:         log,
+		binary:      binary,
+		destination: destination,
+		libraryPath: libraryPath,
+		maxTickAge:  maxTickAge,
+	}
+}
+
+type checkResult struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type livenessResponse struct {
+	Status   string `json:"status"`
+	LastTick int64  `json:"last_tick"`
+	TickAge  string `json:"tick_age"`
+}
+
+type readinessResponse struct {
+	Status          string                 `json:"status"`
+	Checks          map[string]checkResult `json:"checks"`
+	IndexLagSeconds int64                  `json:"index_lag_seconds"`
+}
+
+// Liveness reports whether the processing loop made progress recently
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	lastTick := c.heartbeat.LastTick()
+	age := time.Since(lastTick)
+
+	response := livenessResponse{
+		Status:   "ok",
+		LastTick: lastTick.Unix(),
+		TickAge:  age.Round(time.Second).String(),
+	}
+
+	status := http.StatusOK
+	if age > c.maxTickAge {
+		response.Status = "stalled"
+		status = http.StatusServiceUnavailable
+	}
+
+	c.writeJSON(w, status, response)
+}
+
+// Readiness checks all dependencies needed to process requests
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	response := readinessResponse{
+		Status: "ok",
+		Checks: map[string]checkResult{
+			"database":      result(c.database.Ping(ctx)),
+			"destination":   result(checkWritable(c.destination)),
+			"music_library": result(checkReadable(c.libraryPath)),
+		},
+	}
+
+	version, err := c.binaryVersion(ctx)
+	downloader := result(err)
+	downloader.Detail = version
+	response.Checks[c.binary] = downloader
+
+	// index lag is informational, the playlist processing waits for the indexer on its own
+	indexStatus, err := c.database.GetIndexStatus(ctx)
+	if err != nil {
+		response.Checks["index_status"] = result(err)
+	} else {
+		response.IndexLagSeconds = max(indexStatus.LastUpdated-indexStatus.LastIndexed, 0)
+		response.Checks["index_status"] = checkResult{
+			OK:     true,
+			Detail: fmt.Sprintf("last updated %d, last indexed %d", indexStatus.LastUpdated, indexStatus.LastIndexed),
+		}
+	}
+
+	status := http.StatusOK
+	for name, check := range response.Checks {
+		if !check.OK {
+			c.log.Warn("readiness check failed", zap.String("check", name), zap.String("error", check.Error))
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	c.writeJSON(w, status, response)
+}
+
+// binaryVersion resolves the downloader binary and returns its version, the result is cached
+func (c *Checker) binaryVersion(ctx context.Context) (string, error) {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+
+	if time.Since(c.versionChecked) < versionCacheTTL {
+		return c.version, c.versionErr
+	}
+
+	c.version, c.versionErr = "", nil
+	path, err := exec.LookPath(c.binary)
+	if err != nil {
+		c.versionErr = err
+	} else {
+		out, err := exec.CommandContext(ctx, path, "--version").Output()
+		if err != nil {
+			c.versionErr = fmt.Errorf("%s --version: %w", path, err)
+		} else {
+			c.version = strings.TrimSpace(string(out))
+		}
+	}
+
+	c.versionChecked = time.Now()
+	return c.version, c.versionErr
+}
+
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+
+	name := f.Name()
+	return errors.Join(f.Close(), os.Remove(name))
+}
+
+func checkReadable(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Readdirnames(1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+func result(err error) checkResult {
+	if err != nil {
+		return checkResult{OK: false, Error: err.Error()}
+	}
+	return checkResult{OK: true}
+}
+
+func (c *Checker) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		c.log.Warn("failed to write response", zap.Error(err))
+	}
+}
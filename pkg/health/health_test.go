@@ -0,0 +1,79 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"go.uber.org/zap"
+)
+
+type fakeHeartbeat time.Time
+
+func (f fakeHeartbeat) LastTick() time.Time {
+	return time.Time(f)
+}
+
+func TestLiveness(t *testing.T) {
+	tests := []struct {
+		name       string
+		lastTick   time.Time
+		wantStatus int
+		want       string
+	}{
+		{"recent tick", time.Now().Add(-time.Minute), http.StatusOK, "ok"},
+		{"stale tick", time.Now().Add(-2 * time.Hour), http.StatusServiceUnavailable, "stalled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(db.NewMemoryDatabase(), fakeHeartbeat(tt.lastTick), zap.NewNop(), "spotdl", t.TempDir(), t.TempDir(), time.Hour)
+
+			rec := httptest.NewRecorder()
+			checker.Liveness(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			var response livenessResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if rec.Code != tt.wantStatus || response.Status != tt.want {
+				t.Errorf("liveness returned %d %q, want %d %q", rec.Code, response.Status, tt.wantStatus, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadiness_UnwritableDestination(t *testing.T) {
+	// a file is not a directory temp files can be created in
+	destination := filepath.Join(t.TempDir(), "destination")
+	if err := os.WriteFile(destination, nil, 0644); err != nil {
+		t.Fatalf("failed to write destination: %v", err)
+	}
+
+	checker := NewChecker(db.NewMemoryDatabase(), fakeHeartbeat(time.Now()), zap.NewNop(), "spotdl", destination, t.TempDir(), time.Hour)
+
+	rec := httptest.NewRecorder()
+	checker.Readiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var response readinessResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || response.Status != "unavailable" {
+		t.Errorf("readiness returned %d %q, want %d unavailable", rec.Code, response.Status, http.StatusServiceUnavailable)
+	}
+	if check := response.Checks["destination"]; check.OK || check.Error == "" {
+		t.Errorf("expected the destination check to fail, got %+v", check)
+	}
+	if check := response.Checks["database"]; !check.OK {
+		t.Errorf("expected the database check to pass, got %+v", check)
+	}
+	if check := response.Checks["music_library"]; !check.OK {
+		t.Errorf("expected the music library check to pass, got %+v", check)
+	}
+}
@@ -0,0 +1,40 @@
+package indexer
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// readDuration returns the playback duration of r, or 0 if it is not known for the file type.
+// Only FLAC stores it in a fixed header, other formats would need their frames scanned.
+func readDuration(r io.ReadSeeker, fileType tag.FileType) time.Duration {
+	if fileType != tag.FLAC {
+		return 0
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0
+	}
+
+	// "fLaC", the STREAMINFO block header and its first 18 bytes
+	var header [4 + 4 + 18]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0
+	}
+	if string(header[:4]) != "fLaC" || header[4]&0x7f != 0 {
+		return 0
+	}
+
+	// sample rate (20 bits), channels (3), bits per sample (5) and total samples (36)
+	packed := binary.BigEndian.Uint64(header[18:26])
+	sampleRate := packed >> 44
+	totalSamples := packed & (1<<36 - 1)
+	if sampleRate == 0 {
+		return 0
+	}
+
+	return time.Duration(totalSamples*1000/sampleRate) * time.Millisecond
+}
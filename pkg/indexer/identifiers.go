@@ -0,0 +1,38 @@
+package indexer
+
+import (
+	"strings"
+
+	"github.com/dhowden/tag"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/match"
+)
+
+// readIdentifiers returns the ISRC and Spotify track id spotdl stored in the tags.
+// The frame names differ per container (TSRC/WOAS in ID3, isrc/woas in vorbis
+// comments, freeform atoms in MP4), so every raw tag is looked at.
+func readIdentifiers(metadata tag.Metadata) (string, string) {
+	var isrc, spotifyID string
+	for key, value := range metadata.Raw() {
+		var text string
+		switch v := value.(type) {
+		case string:
+			text = v
+		case []byte:
+			text = string(v)
+		case *tag.Comm:
+			text = v.Text
+		default:
+			continue
+		}
+
+		name := strings.ToLower(key)
+		if isrc == "" && (name == "isrc" || name == "tsrc" || strings.HasSuffix(name, ":isrc")) {
+			isrc = match.NormalizeISRC(text)
+		}
+		if spotifyID == "" {
+			spotifyID = match.SpotifyTrackID(text)
+		}
+	}
+
+	return isrc, spotifyID
+}
@@ -0,0 +1,410 @@
+package indexer
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhowden/tag"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	models "github.com/supperdoggy/spot-models"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNoLibrary = errors.New("music library path is not set")
+)
+
+// audioExtensions are the file types the indexer handles, the downloaders report the same types.
+// Files tag can't read, like wav, are indexed by their file name.
+var audioExtensions = map[string]bool{
+	".flac": true,
+	".mp3":  true,
+	".m4a":  true,
+	".ogg":  true,
+	".opus": true,
+	".wav":  true,
+}
+
+// Stats summarises a single indexing run
+type Stats struct {
+	Scanned   int
+	Indexed   int
+	Unchanged int
+	Moved     int
+	Removed   int64
+	Failed    int
+}
+
+// scannedFile is a new or modified file found while walking the library
+type scannedFile struct {
+	file  models.MusicFile
+	entry db.LibraryEntry
+	isNew bool
+}
+
+// Indexer keeps the music files collection in sync with the library on disk
+type Indexer struct {
+	database db.Database
+	log      *zap.Logger
+	// roots are the library and the download destination, none of them inside another
+	roots []string
+
+	// mu serialises full and partial runs so they do not race on the same documents
+	mu sync.Mutex
+}
+
+// NewIndexer indexes the audio files under roots, usually the library and the download destination.
+// Empty roots and roots inside another one are dropped.
+func NewIndexer(database db.Database, log *zap.Logger, roots ...string) *Indexer {
+	return &Indexer{
+		database: database,
+		log:      log,
+		roots:    outermostRoots(roots),
+	}
+}
+
+// outermostRoots cleans roots and keeps only those that are not inside another root
+func outermostRoots(roots []string) []string {
+	cleaned := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if root != "" {
+			cleaned = append(cleaned, filepath.Clean(root))
+		}
+	}
+
+	outermost := make([]string, 0, len(cleaned))
+	for i, root := range cleaned {
+		nested := false
+		for j, other := range cleaned {
+			// of two equal roots the first one is kept
+			if i != j && isUnder(other, root) && (other != root || j < i) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			outermost = append(outermost, root)
+		}
+	}
+	return outermost
+}
+
+// Run walks the whole library, indexes new and changed files, drops deleted ones
+// and sets IndexStatus.LastIndexed once done
+func (i *Indexer) Run(ctx context.Context) (Stats, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var stats Stats
+	if len(i.roots) == 0 {
+		return stats, ErrNoLibrary
+	}
+
+	started := time.Now().UTC()
+	i.log.Info("indexing music library", zap.Strings("roots", i.roots))
+
+	entries, err := i.database.ListLibraryEntries(ctx)
+	if err != nil {
+		i.log.Error("failed to list indexed files", zap.Error(err))
+		return stats, err
+	}
+
+	known := make(map[string]db.LibraryEntry, len(entries))
+	for _, entry := range entries {
+		known[entry.Path] = entry
+	}
+
+	// changed holds new and modified files, they are written once the walk is done so moves can be detected
+	var changed []scannedFile
+	seen := make(map[string]bool, len(entries))
+
+	for _, root := range i.roots {
+		if err := i.walk(ctx, root, known, seen, &changed, &stats); err != nil {
+			i.log.Error("failed to walk music library", zap.String("root", root), zap.Error(err))
+			return stats, err
+		}
+	}
+
+	// documents of files that are gone, keyed by content hash so a new file with the same audio can take them over
+	missing := make(map[string][]string)
+	for _, entry := range entries {
+		if !seen[entry.Path] {
+			missing[entry.Hash] = append(missing[entry.Hash], entry.Path)
+		}
+	}
+
+	for _, scanned := range changed {
+		if scanned.isNew && scanned.entry.Hash != "" && len(missing[scanned.entry.Hash]) > 0 {
+			from := missing[scanned.entry.Hash][0]
+			if err := i.database.MoveMusicFile(ctx, from, scanned.entry.Path); err != nil {
+				i.log.Error("failed to move music file", zap.String("from", from), zap.String("to", scanned.entry.Path), zap.Error(err))
+			} else {
+				i.log.Info("music file moved", zap.String("from", from), zap.String("to", scanned.entry.Path))
+				missing[scanned.entry.Hash] = missing[scanned.entry.Hash][1:]
+				stats.Moved++
+			}
+		}
+
+		if err := i.database.IndexMusicFile(ctx, scanned.file, scanned.entry); err != nil {
+			i.log.Error("failed to index music file", zap.String("path", scanned.entry.Path), zap.Error(err))
+			stats.Failed++
+			continue
+		}
+		stats.Indexed++
+	}
+
+	var deleted []string
+	for _, paths := range missing {
+		deleted = append(deleted, paths...)
+	}
+
+	if len(deleted) > 0 {
+		removed, err := i.database.DeleteMusicFiles(ctx, deleted)
+		stats.Removed = removed
+		if err != nil {
+			i.log.Error("failed to remove deleted music files", zap.Error(err))
+			return stats, err
+		}
+	}
+
+	if err := i.markIndexed(ctx, started); err != nil {
+		return stats, err
+	}
+
+	i.log.Info("indexing completed",
+		zap.Int("scanned", stats.Scanned),
+		zap.Int("indexed", stats.Indexed),
+		zap.Int("unchanged", stats.Unchanged),
+		zap.Int("moved", stats.Moved),
+		zap.Int64("removed", stats.Removed),
+		zap.Int("failed", stats.Failed),
+		zap.Duration("took", time.Since(started)))
+
+	return stats, nil
+}
+
+// walk scans one root, collecting new and modified files in changed and every audio file in seen
+func (i *Indexer) walk(ctx context.Context, root string, known map[string]db.LibraryEntry, seen map[string]bool,
+	changed *[]scannedFile, stats *Stats) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			i.log.Warn("failed to read library path", zap.String("path", path), zap.Error(err))
+			if d != nil && d.IsDir() && path != root {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !IsAudioFile(path) {
+			return nil
+		}
+
+		stats.Scanned++
+		seen[path] = true
+
+		info, err := d.Info()
+		if err != nil {
+			i.log.Warn("failed to stat file", zap.String("path", path), zap.Error(err))
+			stats.Failed++
+			return nil
+		}
+
+		if entry, ok := known[path]; ok && entry.Size == info.Size() && entry.ModTime == info.ModTime().Unix() {
+			stats.Unchanged++
+			return nil
+		}
+
+		file, entry, err := readFile(path, info)
+		if err != nil {
+			i.log.Warn("failed to read audio file", zap.String("path", path), zap.Error(err))
+			stats.Failed++
+			return nil
+		}
+
+		_, isKnown := known[path]
+		*changed = append(*changed, scannedFile{file: file, entry: entry, isNew: !isKnown})
+		return nil
+	})
+}
+
+// IndexFiles indexes the given files right away, e.g. the output of a download.
+// Paths outside the roots, missing files and non audio files are ignored.
+func (i *Indexer) IndexFiles(ctx context.Context, paths []string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var errs []error
+	for _, path := range paths {
+		if !IsAudioFile(path) || !i.inLibrary(path) {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			i.log.Warn("failed to stat downloaded file", zap.String("path", path), zap.Error(err))
+			continue
+		}
+
+		file, entry, err := readFile(path, info)
+		if err != nil {
+			i.log.Warn("failed to read audio file", zap.String("path", path), zap.Error(err))
+			continue
+		}
+
+		if err := i.database.IndexMusicFile(ctx, file, entry); err != nil {
+			i.log.Error("failed to index music file", zap.String("path", path), zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// RemoveFiles drops the documents of the given paths that no longer exist on disk
+func (i *Indexer) RemoveFiles(ctx context.Context, paths []string) (int64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	gone := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			gone = append(gone, path)
+		}
+	}
+	if len(gone) == 0 {
+		return 0, nil
+	}
+
+	removed, err := i.database.DeleteMusicFiles(ctx, gone)
+	if err != nil {
+		i.log.Error("failed to remove deleted music files", zap.Error(err))
+		return removed, err
+	}
+	return removed, nil
+}
+
+// inLibrary reports whether path is under one of the roots, files outside of them
+// would be dropped again by the next full run
+func (i *Indexer) inLibrary(path string) bool {
+	for _, root := range i.roots {
+		if isUnder(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnder reports whether path is root or inside it
+func isUnder(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (i *Indexer) markIndexed(ctx context.Context, at time.Time) error {
+	status, err := i.database.GetIndexStatus(ctx)
+	if err != nil {
+		i.log.Error("failed to get index status", zap.Error(err))
+		return err
+	}
+
+	status.LastIndexed = at.Unix()
+	if err := i.database.UpdateIndexStatus(ctx, status); err != nil {
+		i.log.Error("failed to update index status", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// IsAudioFile reports whether the indexer handles the file type of path
+func IsAudioFile(path string) bool {
+	return audioExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// readFile reads the tags and the audio checksum of a single file
+func readFile(path string, info fs.FileInfo) (models.MusicFile, db.LibraryEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return models.MusicFile{}, db.LibraryEntry{}, err
+	}
+	defer f.Close()
+
+	entry := db.LibraryEntry{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime().Unix(),
+	}
+
+	var artist, title string
+	if metadata, err := tag.ReadFrom(f); err == nil {
+		artist = strings.TrimSpace(metadata.Artist())
+		if artist == "" {
+			artist = strings.TrimSpace(metadata.AlbumArtist())
+		}
+		title = strings.TrimSpace(metadata.Title())
+		entry.Album = strings.TrimSpace(metadata.Album())
+		entry.DurationMs = readDuration(f, metadata.FileType()).Milliseconds()
+		entry.ISRC, entry.SpotifyID = readIdentifiers(metadata)
+	}
+
+	if artist == "" || title == "" {
+		nameArtist, nameTitle := parseFileName(path)
+		if artist == "" {
+			artist = nameArtist
+		}
+		if title == "" {
+			title = nameTitle
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return models.MusicFile{}, db.LibraryEntry{}, err
+	}
+	if sum, err := tag.Sum(f); err == nil {
+		entry.Hash = sum
+	} else {
+		// containers tag can not parse are hashed whole, tag edits then count as a new file
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return models.MusicFile{}, db.LibraryEntry{}, err
+		}
+		h := sha1.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return models.MusicFile{}, db.LibraryEntry{}, err
+		}
+		entry.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return models.MusicFile{
+		Path:   path,
+		Artist: artist,
+		Title:  title,
+	}, entry, nil
+}
+
+// parseFileName extracts artist and title from spotdl's default "Artist - Title.ext" naming
+func parseFileName(path string) (string, string) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	artist, title, ok := strings.Cut(name, " - ")
+	if !ok {
+		return "", strings.TrimSpace(name)
+	}
+	return strings.TrimSpace(artist), strings.TrimSpace(title)
+}
@@ -0,0 +1,221 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	models "github.com/supperdoggy/spot-models"
+	"go.uber.org/zap"
+)
+
+// fakeDatabase keeps the indexed files in memory
+type fakeDatabase struct {
+	db.Database
+
+	files   map[string]models.MusicFile
+	entries map[string]db.LibraryEntry
+	status  models.IndexStatus
+	moves   []string
+}
+
+func newFakeDatabase() *fakeDatabase {
+	return &fakeDatabase{
+		files:   make(map[string]models.MusicFile),
+		entries: make(map[string]db.LibraryEntry),
+	}
+}
+
+func (f *fakeDatabase) IndexMusicFile(ctx context.Context, file models.MusicFile, entry db.LibraryEntry) error {
+	f.files[entry.Path] = file
+	f.entries[entry.Path] = entry
+	return nil
+}
+
+func (f *fakeDatabase) MoveMusicFile(ctx context.Context, from, to string) error {
+	f.moves = append(f.moves, from+" -> "+to)
+	f.files[to] = f.files[from]
+	f.entries[to] = f.entries[from]
+	delete(f.files, from)
+	delete(f.entries, from)
+	return nil
+}
+
+func (f *fakeDatabase) DeleteMusicFiles(ctx context.Context, paths []string) (int64, error) {
+	var deleted int64
+	for _, path := range paths {
+		if _, ok := f.entries[path]; ok {
+			delete(f.files, path)
+			delete(f.entries, path)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (f *fakeDatabase) ListLibraryEntries(ctx context.Context) ([]db.LibraryEntry, error) {
+	entries := make([]db.LibraryEntry, 0, len(f.entries))
+	for _, entry := range f.entries {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (f *fakeDatabase) GetIndexStatus(ctx context.Context) (models.IndexStatus, error) {
+	return f.status, nil
+}
+
+func (f *fakeDatabase) UpdateIndexStatus(ctx context.Context, status models.IndexStatus) error {
+	f.status = status
+	return nil
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+	song := filepath.Join(root, "Artist", "Artist - Song.mp3")
+	writeFile(t, song, "not really audio")
+	writeFile(t, filepath.Join(root, "cover.jpg"), "image")
+	writeFile(t, filepath.Join(root, ".trash", "Old - Song.flac"), "deleted")
+
+	database := newFakeDatabase()
+	database.entries[filepath.Join(root, "Gone - Song.flac")] = db.LibraryEntry{Path: filepath.Join(root, "Gone - Song.flac"), Hash: "gone"}
+
+	indexer := NewIndexer(database, zap.NewNop(), root)
+	stats, err := indexer.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if stats.Indexed != 1 || stats.Removed != 1 {
+		t.Fatalf("expected 1 indexed and 1 removed file, got %+v", stats)
+	}
+
+	file, ok := database.files[song]
+	if !ok {
+		t.Fatalf("expected %s to be indexed, got %v", song, database.files)
+	}
+	if file.Artist != "Artist" || file.Title != "Song" {
+		t.Fatalf("expected artist and title from the file name, got %q - %q", file.Artist, file.Title)
+	}
+	if database.entries[song].Hash == "" {
+		t.Fatal("expected a content hash")
+	}
+	if database.status.LastIndexed == 0 {
+		t.Fatal("expected LastIndexed to be set")
+	}
+
+	stats, err = indexer.Run(context.Background())
+	if err != nil {
+		t.Fatalf("second Run failed: %v", err)
+	}
+	if stats.Indexed != 0 || stats.Unchanged != 1 {
+		t.Fatalf("expected unchanged file to be skipped, got %+v", stats)
+	}
+}
+
+func TestRun_DetectsMove(t *testing.T) {
+	root := t.TempDir()
+	from := filepath.Join(root, "Artist - Song.mp3")
+	to := filepath.Join(root, "Album", "Artist - Song.mp3")
+	writeFile(t, from, "audio")
+
+	database := newFakeDatabase()
+	indexer := NewIndexer(database, zap.NewNop(), root)
+	if _, err := indexer.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := indexer.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if stats.Moved != 1 || len(database.moves) != 1 || stats.Removed != 0 {
+		t.Fatalf("expected the file to be moved instead of removed, got %+v", stats)
+	}
+	if _, ok := database.files[from]; ok {
+		t.Fatal("expected the old path to be removed")
+	}
+	if _, ok := database.files[to]; !ok {
+		t.Fatal("expected the new path to be indexed")
+	}
+}
+
+func TestIndexFiles_IgnoresFilesOutsideLibrary(t *testing.T) {
+	root := t.TempDir()
+	inside := filepath.Join(root, "Artist - Song.opus")
+	outside := filepath.Join(t.TempDir(), "Other - Song.opus")
+	writeFile(t, inside, "audio")
+	writeFile(t, outside, "audio")
+
+	database := newFakeDatabase()
+	indexer := NewIndexer(database, zap.NewNop(), root)
+	if err := indexer.IndexFiles(context.Background(), []string{inside, outside, filepath.Join(root, "missing.mp3")}); err != nil {
+		t.Fatalf("IndexFiles failed: %v", err)
+	}
+
+	if len(database.files) != 1 {
+		t.Fatalf("expected only the library file to be indexed, got %v", database.files)
+	}
+}
+
+func TestRun_SeparateDestination(t *testing.T) {
+	library := t.TempDir()
+	destination := t.TempDir()
+	old := filepath.Join(library, "Artist - Old.mp3")
+	downloaded := filepath.Join(destination, "Artist - New.mp3")
+	writeFile(t, old, "old audio")
+
+	database := newFakeDatabase()
+	indexer := NewIndexer(database, zap.NewNop(), library, destination)
+	if _, err := indexer.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// a download lands outside the library
+	writeFile(t, downloaded, "new audio")
+	if err := indexer.IndexFiles(context.Background(), []string{downloaded}); err != nil {
+		t.Fatalf("IndexFiles failed: %v", err)
+	}
+	if _, ok := database.files[downloaded]; !ok {
+		t.Fatalf("expected the downloaded file to be indexed, got %v", database.files)
+	}
+
+	// and the next full run keeps it
+	stats, err := indexer.Run(context.Background())
+	if err != nil {
+		t.Fatalf("second Run failed: %v", err)
+	}
+	if stats.Removed != 0 || stats.Unchanged != 2 {
+		t.Fatalf("expected both roots to be scanned and nothing removed, got %+v", stats)
+	}
+	if _, ok := database.files[old]; !ok {
+		t.Fatal("expected the library file to stay indexed")
+	}
+}
+
+func TestNewIndexer_NestedRoots(t *testing.T) {
+	indexer := NewIndexer(newFakeDatabase(), zap.NewNop(), "/mnt/music/", "/mnt/music/downloads", "", "/mnt/music")
+	if len(indexer.roots) != 1 || indexer.roots[0] != "/mnt/music" {
+		t.Fatalf("expected only the library root, got %v", indexer.roots)
+	}
+}
@@ -0,0 +1,248 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// Watcher indexes library changes as they happen instead of waiting for a full rescan
+type Watcher struct {
+	indexer  *Indexer
+	log      *zap.Logger
+	roots    []string
+	debounce time.Duration
+
+	// watching is set while events are followed, Run falls back to full rescans otherwise
+	watching atomic.Bool
+
+	mu sync.Mutex
+	// pending maps changed paths to the time of their last event
+	pending map[string]time.Time
+	// dirs are the watched directories, removing one of them needs a full rescan
+	dirs map[string]bool
+	// rescan is set when events were lost or a watched directory disappeared
+	rescan bool
+}
+
+// NewWatcher watches roots for audio file changes. A path is indexed once it saw
+// no events for debounce, so files that are still being written are not read.
+func NewWatcher(indexer *Indexer, log *zap.Logger, debounce time.Duration, roots ...string) *Watcher {
+	return &Watcher{
+		indexer:  indexer,
+		log:      log,
+		roots:    roots,
+		debounce: debounce,
+		pending:  make(map[string]time.Time),
+		dirs:     make(map[string]bool),
+	}
+}
+
+// Watch runs a full index and then follows filesystem events until ctx is cancelled
+func (w *Watcher) Watch(ctx context.Context) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		w.log.Error("failed to create filesystem watcher", zap.Error(err))
+		return err
+	}
+	defer fsw.Close()
+
+	for _, root := range w.roots {
+		w.addTree(fsw, root, false)
+	}
+
+	w.watching.Store(true)
+	defer w.watching.Store(false)
+
+	// catch up with whatever changed while we were not watching
+	if _, err := w.indexer.Run(ctx); err != nil && ctx.Err() == nil {
+		w.log.Error("initial library index failed", zap.Error(err))
+	}
+
+	w.log.Info("watching music library for changes", zap.Strings("roots", w.roots), zap.Duration("debounce", w.debounce))
+
+	ticker := time.NewTicker(max(w.debounce/2, 100*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(fsw, event)
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			w.log.Warn("filesystem watcher error", zap.Error(err))
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.mu.Lock()
+				w.rescan = true
+				w.mu.Unlock()
+			}
+		case <-ticker.C:
+			if err := w.flush(ctx, time.Now().Add(-w.debounce), false); err != nil && ctx.Err() == nil {
+				w.log.Error("failed to index library changes", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Run indexes every pending change right away and marks the index as up to date.
+// It lets the service clear the index gate after a pass without a full rescan.
+func (w *Watcher) Run(ctx context.Context) (Stats, error) {
+	if !w.watching.Load() {
+		return w.indexer.Run(ctx)
+	}
+	return Stats{}, w.flush(ctx, time.Now(), true)
+}
+
+// IndexFiles indexes the given files right away
+func (w *Watcher) IndexFiles(ctx context.Context, paths []string) error {
+	return w.indexer.IndexFiles(ctx, paths)
+}
+
+func (w *Watcher) handleEvent(fsw *fsnotify.Watcher, event fsnotify.Event) {
+	path := event.Name
+
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			// a new or moved in directory, its files do not emit events of their own
+			w.addTree(fsw, path, true)
+			return
+		}
+	}
+
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		w.mu.Lock()
+		if w.dirs[path] {
+			w.log.Info("watched directory removed, scheduling full rescan", zap.String("path", path))
+			w.removeDirs(path)
+			w.rescan = true
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+	}
+
+	if !IsAudioFile(path) {
+		return
+	}
+
+	w.mu.Lock()
+	w.pending[path] = time.Now()
+	w.mu.Unlock()
+}
+
+// addTree watches dir and its subdirectories, queueing their audio files when queueFiles is set
+func (w *Watcher) addTree(fsw *fsnotify.Watcher, dir string, queueFiles bool) {
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			w.log.Warn("failed to read directory", zap.String("path", path), zap.Error(err))
+			return nil
+		}
+
+		if !d.IsDir() {
+			if queueFiles && IsAudioFile(path) {
+				w.mu.Lock()
+				w.pending[path] = time.Now()
+				w.mu.Unlock()
+			}
+			return nil
+		}
+
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+
+		if err := fsw.Add(path); err != nil {
+			w.log.Warn("failed to watch directory", zap.String("path", path), zap.Error(err))
+			return nil
+		}
+
+		w.mu.Lock()
+		w.dirs[path] = true
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+// removeDirs forgets dir and everything below it, the caller holds mu
+func (w *Watcher) removeDirs(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for path := range w.dirs {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(w.dirs, path)
+		}
+	}
+}
+
+// flush indexes the pending paths whose last event is older than before.
+// LastIndexed is bumped when something was indexed or force is set.
+func (w *Watcher) flush(ctx context.Context, before time.Time, force bool) error {
+	w.mu.Lock()
+	rescan := w.rescan
+	w.rescan = false
+
+	var ready []string
+	for path, last := range w.pending {
+		if rescan || !last.After(before) {
+			ready = append(ready, path)
+			delete(w.pending, path)
+		}
+	}
+	w.mu.Unlock()
+
+	if rescan {
+		_, err := w.indexer.Run(ctx)
+		if err != nil {
+			w.mu.Lock()
+			w.rescan = true
+			w.mu.Unlock()
+		}
+		return err
+	}
+
+	if len(ready) == 0 {
+		if force {
+			return w.indexer.markIndexed(ctx, time.Now().UTC())
+		}
+		return nil
+	}
+
+	var existing, gone []string
+	for _, path := range ready {
+		if _, err := os.Stat(path); err == nil {
+			existing = append(existing, path)
+		} else {
+			gone = append(gone, path)
+		}
+	}
+
+	w.log.Info("indexing library changes", zap.Int("changed", len(existing)), zap.Int("removed", len(gone)))
+
+	var errs []error
+	if err := w.indexer.IndexFiles(ctx, existing); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := w.indexer.RemoveFiles(ctx, gone); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	return w.indexer.markIndexed(ctx, time.Now().UTC())
+}
@@ -0,0 +1,108 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	models "github.com/supperdoggy/spot-models"
+	"go.uber.org/zap"
+)
+
+// lockedDatabase guards fakeDatabase, the watcher writes from its own goroutine
+type lockedDatabase struct {
+	*fakeDatabase
+	mu sync.Mutex
+}
+
+func (l *lockedDatabase) IndexMusicFile(ctx context.Context, file models.MusicFile, entry db.LibraryEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fakeDatabase.IndexMusicFile(ctx, file, entry)
+}
+
+func (l *lockedDatabase) DeleteMusicFiles(ctx context.Context, paths []string) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fakeDatabase.DeleteMusicFiles(ctx, paths)
+}
+
+func (l *lockedDatabase) ListLibraryEntries(ctx context.Context) ([]db.LibraryEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fakeDatabase.ListLibraryEntries(ctx)
+}
+
+func (l *lockedDatabase) GetIndexStatus(ctx context.Context) (models.IndexStatus, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fakeDatabase.GetIndexStatus(ctx)
+}
+
+func (l *lockedDatabase) UpdateIndexStatus(ctx context.Context, status models.IndexStatus) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fakeDatabase.UpdateIndexStatus(ctx, status)
+}
+
+func (l *lockedDatabase) indexed(path string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.files[path]
+	return ok
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWatcher_IndexesChanges(t *testing.T) {
+	root := t.TempDir()
+	database := &lockedDatabase{fakeDatabase: newFakeDatabase()}
+	watcher := NewWatcher(NewIndexer(database, zap.NewNop(), root), zap.NewNop(), 50*time.Millisecond, root)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = watcher.Watch(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitFor(t, "watcher to start", watcher.watching.Load)
+
+	song := filepath.Join(root, "Album", "Artist - Song.flac")
+	writeFile(t, song, "audio")
+	waitFor(t, "new file to be indexed", func() bool { return database.indexed(song) })
+
+	if err := os.Remove(song); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "removed file to be dropped", func() bool { return !database.indexed(song) })
+}
+
+func TestWatcher_RunMarksIndexed(t *testing.T) {
+	database := newFakeDatabase()
+	watcher := NewWatcher(NewIndexer(database, zap.NewNop(), t.TempDir()), zap.NewNop(), time.Minute)
+	watcher.watching.Store(true)
+
+	if _, err := watcher.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if database.status.LastIndexed == 0 {
+		t.Fatal("expected LastIndexed to be bumped without pending changes")
+	}
+}
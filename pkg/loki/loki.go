@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -181,12 +182,14 @@ func (c *LokiCore) flush() {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		fmt.Printf("loki marshal error: %v\n", err)
+		metrics.LokiPushFailures.Inc()
 		return
 	}
 
 	req, err := http.NewRequest("POST", c.url, bytes.NewReader(data))
 	if err != nil {
 		fmt.Printf("loki request error: %v\n", err)
+		metrics.LokiPushFailures.Inc()
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
@@ -194,6 +197,7 @@ func (c *LokiCore) flush() {
 	resp, err := c.client.Do(req)
 	if err != nil {
 		fmt.Printf("[loki] push error: %v\n", err)
+		metrics.LokiPushFailures.Inc()
 		return
 	}
 	defer resp.Body.Close()
@@ -201,6 +205,7 @@ func (c *LokiCore) flush() {
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
 		fmt.Printf("[loki] push failed with status %d: %s\n", resp.StatusCode, string(body))
+		metrics.LokiPushFailures.Inc()
 	} else {
 		fmt.Printf("[loki] pushed %d log entries\n", len(toSend))
 	}
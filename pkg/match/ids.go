@@ -0,0 +1,31 @@
+package match
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// spotifyTrackURL matches a track link, e.g. https://open.spotify.com/intl-de/track/<id>?si=...
+	spotifyTrackURL = regexp.MustCompile(`(?:open\.spotify\.com/(?:[a-z-]+/)?track/|spotify:track:)([A-Za-z0-9]{22})`)
+	// isrcPattern is the country, registrant, year and designation code of an ISRC
+	isrcPattern = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$`)
+)
+
+// SpotifyTrackID extracts the track id from a Spotify track URL or URI found in s
+func SpotifyTrackID(s string) string {
+	m := spotifyTrackURL.FindStringSubmatch(s)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// NormalizeISRC returns isrc in its compact upper case form, or "" if it is not a valid ISRC
+func NormalizeISRC(isrc string) string {
+	isrc = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(isrc)))
+	if !isrcPattern.MatchString(isrc) {
+		return ""
+	}
+	return isrc
+}
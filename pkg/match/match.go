@@ -0,0 +1,199 @@
+package match
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var numbers = regexp.MustCompile(`\d+`)
+
+const (
+	// DefaultThreshold accepts version suffixes, accents and partial artist credits but not a different title
+	DefaultThreshold = 0.85
+
+	// titleWeight and artistWeight split the score between title and artist similarity
+	titleWeight  = 0.65
+	artistWeight = 0.35
+
+	// artistThreshold is the similarity two artist names need to count as the same artist
+	artistThreshold = 0.85
+)
+
+// Track is the part of a track the matcher looks at
+type Track struct {
+	Artist string
+	Title  string
+	// Duration is optional, zero skips the duration check
+	Duration time.Duration
+}
+
+// Prepared is a Track normalized for comparisons, see Matcher.Prepare
+type Prepared struct {
+	title    string
+	artists  []string
+	duration time.Duration
+}
+
+// Matcher scores library files against wanted tracks
+type Matcher struct {
+	// Threshold is the minimum score in [0, 1] for a candidate to match
+	Threshold float64
+	// DurationTolerance is the maximum duration difference when both durations are known
+	DurationTolerance time.Duration
+}
+
+func NewMatcher(threshold float64, durationTolerance time.Duration) *Matcher {
+	return &Matcher{
+		Threshold:         threshold,
+		DurationTolerance: durationTolerance,
+	}
+}
+
+// Score returns how similar got is to want, from 0 (unrelated) to 1 (same track)
+func (m *Matcher) Score(want, got Track) float64 {
+	return m.score(prepare(want), prepare(got))
+}
+
+// Matches reports whether got is the same track as want
+func (m *Matcher) Matches(want, got Track) bool {
+	return m.Score(want, got) >= m.Threshold
+}
+
+// Prepare normalizes a track once so it can be scored against many others with BestPrepared
+func (m *Matcher) Prepare(track Track) Prepared {
+	return prepare(track)
+}
+
+// Key is the normalized title, the same as TitleKey of the track's title
+func (p Prepared) Key() string {
+	return p.title
+}
+
+// Best returns the index of the best matching candidate and its score, or -1 if none reaches the threshold
+func (m *Matcher) Best(want Track, candidates []Track) (int, float64) {
+	prepared := make([]Prepared, len(candidates))
+	for i, candidate := range candidates {
+		prepared[i] = prepare(candidate)
+	}
+	return m.BestPrepared(prepare(want), prepared)
+}
+
+// BestPrepared is Best for tracks that are already prepared
+func (m *Matcher) BestPrepared(want Prepared, candidates []Prepared) (int, float64) {
+	best, bestScore := -1, 0.0
+	for i, candidate := range candidates {
+		score := m.score(want, candidate)
+		if score >= m.Threshold && score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best, bestScore
+}
+
+func (m *Matcher) score(want, got Prepared) float64 {
+	if want.duration > 0 && got.duration > 0 {
+		diff := want.duration - got.duration
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > m.DurationTolerance {
+			return 0
+		}
+	}
+
+	return titleWeight*titleSimilarity(want.title, got.title) + artistWeight*artistSimilarity(want.artists, got.artists)
+}
+
+// titleSimilarity is the similarity of two normalized titles, titles with different
+// numbers like "Part 1" and "Part 2" are different tracks however close they are
+func titleSimilarity(a, b string) float64 {
+	if !slices.Equal(numbers.FindAllString(a, -1), numbers.FindAllString(b, -1)) {
+		return 0
+	}
+	return similarity(a, b)
+}
+
+func prepare(track Track) Prepared {
+	title, featured := CleanTitle(track.Title)
+	artists := make([]string, 0, len(featured)+1)
+	for _, artist := range append(SplitArtists(track.Artist), featured...) {
+		if artist = Normalize(artist); artist != "" {
+			artists = append(artists, artist)
+		}
+	}
+
+	return Prepared{
+		title:    Normalize(title),
+		artists:  artists,
+		duration: track.Duration,
+	}
+}
+
+// artistSimilarity is the share of the shorter artist list found in the other one,
+// so a file tagged with the main artist only still matches a track with guests
+func artistSimilarity(want, got []string) float64 {
+	if len(want) == 0 || len(got) == 0 {
+		return 0
+	}
+
+	// "Florence + The Machine" is one artist while "Florence and the Machine" splits in two
+	if joined := similarity(strings.Join(want, " "), strings.Join(got, " ")); joined >= artistThreshold {
+		return joined
+	}
+
+	shorter, longer := want, got
+	if len(shorter) > len(longer) {
+		shorter, longer = longer, shorter
+	}
+
+	found := 0
+	for _, a := range shorter {
+		for _, b := range longer {
+			if similarity(a, b) >= artistThreshold {
+				found++
+				break
+			}
+		}
+	}
+
+	return float64(found) / float64(len(shorter))
+}
+
+// similarity is 1 minus the edit distance of a and b relative to the longer one
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,160 @@
+package match
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"Beyoncé":           "beyonce",
+		"Sigur Rós":         "sigur ros",
+		"Mø":                "mo",
+		"Simon & Garfunkel": "simon and garfunkel",
+		"Don't Stop Me Now": "dont stop me now",
+		"  AC/DC ":          "ac dc",
+		"Ｆｕｌｌ Ｗｉｄｔｈ":        "full width",
+	}
+
+	for in, want := range tests {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCleanTitle(t *testing.T) {
+	tests := []struct {
+		in       string
+		title    string
+		featured []string
+	}{
+		{"Song", "Song", nil},
+		{"Song (Remastered 2011)", "Song", nil},
+		{"Song - 2011 Remaster", "Song", nil},
+		{"Song - Radio Edit", "Song", nil},
+		{"Song [Deluxe Edition]", "Song", nil},
+		{"Song (feat. Guest)", "Song", []string{"Guest"}},
+		{"Song (with A & B) - Remastered", "Song", []string{"A", "B"}},
+		{"Song feat. Guest", "Song", []string{"Guest"}},
+		{"Song (Live)", "Song (Live)", nil},
+		{"Song - Remix", "Song - Remix", nil},
+	}
+
+	for _, tt := range tests {
+		title, featured := CleanTitle(tt.in)
+		if title != tt.title || !reflect.DeepEqual(featured, tt.featured) {
+			t.Errorf("CleanTitle(%q) = %q, %v, want %q, %v", tt.in, title, featured, tt.title, tt.featured)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	matcher := NewMatcher(0.85, 5*time.Second)
+
+	tests := []struct {
+		name string
+		want Track
+		got  Track
+		ok   bool
+	}{
+		{"exact", Track{Artist: "Queen", Title: "Don't Stop Me Now"}, Track{Artist: "Queen", Title: "Don't Stop Me Now"}, true},
+		{"version suffix", Track{Artist: "Queen", Title: "Don't Stop Me Now - Remastered 2011"}, Track{Artist: "Queen", Title: "Don't Stop Me Now"}, true},
+		{"accents", Track{Artist: "Beyoncé", Title: "Déjà Vu"}, Track{Artist: "Beyonce", Title: "Deja Vu"}, true},
+		{"ampersand", Track{Artist: "Simon & Garfunkel", Title: "The Boxer"}, Track{Artist: "Simon and Garfunkel", Title: "The Boxer"}, true},
+		{"reordered artists", Track{Artist: "A, B", Title: "Song"}, Track{Artist: "B, A", Title: "Song"}, true},
+		{"main artist only", Track{Artist: "A, B", Title: "Song"}, Track{Artist: "A", Title: "Song"}, true},
+		{"featured in title", Track{Artist: "A", Title: "Song (feat. B)"}, Track{Artist: "A, B", Title: "Song"}, true},
+		{"joined band name", Track{Artist: "Florence + The Machine", Title: "Dog Days Are Over"}, Track{Artist: "Florence and the Machine", Title: "Dog Days Are Over"}, true},
+		{"different artist", Track{Artist: "A", Title: "Song"}, Track{Artist: "C", Title: "Song"}, false},
+		{"different title", Track{Artist: "A", Title: "Song"}, Track{Artist: "A", Title: "Other Tune"}, false},
+		{"numbered part", Track{Artist: "A", Title: "Song, Pt. 1"}, Track{Artist: "A", Title: "Song, Pt. 2"}, false},
+		{"live version", Track{Artist: "A", Title: "Song"}, Track{Artist: "A", Title: "Song (Live)"}, false},
+		{"duration within tolerance", Track{Artist: "A", Title: "Song", Duration: 200 * time.Second}, Track{Artist: "A", Title: "Song", Duration: 203 * time.Second}, true},
+		{"duration too far off", Track{Artist: "A", Title: "Song", Duration: 200 * time.Second}, Track{Artist: "A", Title: "Song", Duration: 320 * time.Second}, false},
+		{"unknown duration", Track{Artist: "A", Title: "Song", Duration: 200 * time.Second}, Track{Artist: "A", Title: "Song"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matcher.Matches(tt.want, tt.got); got != tt.ok {
+				t.Errorf("Matches = %v (score %.2f), want %v", got, matcher.Score(tt.want, tt.got), tt.ok)
+			}
+		})
+	}
+}
+
+func TestMatcher_Best(t *testing.T) {
+	matcher := NewMatcher(0.85, 0)
+	candidates := []Track{
+		{Artist: "A", Title: "Song (Live)"},
+		{Artist: "A", Title: "Song - Remastered"},
+		{Artist: "B", Title: "Song"},
+	}
+
+	best, score := matcher.Best(Track{Artist: "A", Title: "Song"}, candidates)
+	if best != 1 || score < 0.85 {
+		t.Fatalf("expected the remastered candidate to win, got %d (score %.2f)", best, score)
+	}
+
+	if best, _ := matcher.Best(Track{Artist: "C", Title: "Other"}, candidates); best != -1 {
+		t.Fatalf("expected no match, got %d", best)
+	}
+}
+
+func TestMatcher_BestPrepared(t *testing.T) {
+	matcher := NewMatcher(0.85, 0)
+	want := matcher.Prepare(Track{Artist: "A", Title: "Song (feat. B)"})
+	if want.Key() != TitleKey("Song (feat. B)") {
+		t.Fatalf("expected key %q, got %q", TitleKey("Song (feat. B)"), want.Key())
+	}
+
+	candidates := []Prepared{
+		matcher.Prepare(Track{Artist: "C", Title: "Song"}),
+		matcher.Prepare(Track{Artist: "A & B", Title: "Song"}),
+	}
+	if best, score := matcher.BestPrepared(want, candidates); best != 1 || score < 0.85 {
+		t.Fatalf("expected the credited candidate to win, got %d (score %.2f)", best, score)
+	}
+}
+
+func TestSpotifyTrackID(t *testing.T) {
+	tests := map[string]string{
+		"https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC":                "4uLU6hMCjMI75M1A2tKUQC",
+		"https://open.spotify.com/intl-de/track/4uLU6hMCjMI75M1A2tKUQC?si=abc": "4uLU6hMCjMI75M1A2tKUQC",
+		"spotify:track:4uLU6hMCjMI75M1A2tKUQC":                                 "4uLU6hMCjMI75M1A2tKUQC",
+		"https://open.spotify.com/album/4uLU6hMCjMI75M1A2tKUQC":                "",
+		"https://music.youtube.com/watch?v=abc":                                "",
+	}
+
+	for in, want := range tests {
+		if got := SpotifyTrackID(in); got != want {
+			t.Errorf("SpotifyTrackID(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeISRC(t *testing.T) {
+	tests := map[string]string{
+		"GBUM71029604":    "GBUM71029604",
+		"gb-um7-10-29604": "GBUM71029604",
+		"not an isrc":     "",
+		"":                "",
+	}
+
+	for in, want := range tests {
+		if got := NormalizeISRC(in); got != want {
+			t.Errorf("NormalizeISRC(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTitleKey(t *testing.T) {
+	titles := []string{"Déjà Vu", "Deja Vu (feat. Jay-Z)", "DEJA VU - Remastered 2011", "Déjà-Vu"}
+	for _, title := range titles {
+		if got := TitleKey(title); got != "deja vu" {
+			t.Errorf("TitleKey(%q) = %q, want %q", title, got, "deja vu")
+		}
+	}
+}
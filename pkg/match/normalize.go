@@ -0,0 +1,124 @@
+package match
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// bracketed matches a trailing "(...)" or "[...]" group of a title
+	bracketed = regexp.MustCompile(`\s*[\(\[]([^\(\)\[\]]*)[\)\]]\s*$`)
+	// dashSuffix matches a trailing " - ..." part of a title
+	dashSuffix = regexp.MustCompile(`\s+[-–—]\s+([^-–—]*)$`)
+	// featuring matches a featured artist credit, the credited artists follow it
+	featuring = regexp.MustCompile(`(?i)^(feat\.?|ft\.?|featuring|with)\s+`)
+	// inlineFeaturing matches a credit that is not wrapped in brackets, e.g. "Song feat. X"
+	inlineFeaturing = regexp.MustCompile(`(?i)\s+(feat\.?|ft\.?|featuring)\s+(.+)$`)
+	// versionSuffix matches suffixes naming a release of the same recording
+	versionSuffix = regexp.MustCompile(`(?i)\b(remaster(ed)?|re-?master(ed)?|radio edit|single version|album version|original version|mono|stereo|explicit|clean|deluxe|bonus track|anniversary|edition|from .+)\b`)
+	// artistSeparator splits credited artists
+	artistSeparator = regexp.MustCompile(`(?i)\s*(,|;|/|&|\band\b|\bvs\.?|\bfeat\.?|\bft\.?|\bfeaturing\b)\s*`)
+)
+
+// foldings covers letters that do not decompose into a base letter and a mark
+var foldings = strings.NewReplacer(
+	"ø", "o", "Ø", "o",
+	"æ", "ae", "Æ", "ae",
+	"œ", "oe", "Œ", "oe",
+	"ß", "ss",
+	"ł", "l", "Ł", "l",
+	"đ", "d", "Đ", "d",
+	"ı", "i",
+)
+
+// Normalize lowercases s, folds accents, spells out "&" and drops punctuation
+func Normalize(s string) string {
+	s = foldings.Replace(norm.NFKD.String(s))
+	s = strings.ReplaceAll(s, "&", " and ")
+
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(unicode.ToLower(r))
+		case r == '\'' || r == '’':
+			// "don't" and "dont" are the same word
+		default:
+			space = true
+		}
+	}
+
+	return b.String()
+}
+
+// CleanTitle strips version suffixes like "(Remastered 2011)" or "- Radio Edit" from
+// title and returns the artists credited in it as featured
+func CleanTitle(title string) (string, []string) {
+	var featured []string
+	title = strings.TrimSpace(title)
+
+	for {
+		if m := bracketed.FindStringSubmatchIndex(title); m != nil {
+			inner := strings.TrimSpace(title[m[2]:m[3]])
+			if loc := featuring.FindStringIndex(inner); loc != nil {
+				featured = append(featured, SplitArtists(inner[loc[1]:])...)
+				title = strings.TrimSpace(title[:m[0]])
+				continue
+			}
+			if versionSuffix.MatchString(inner) {
+				title = strings.TrimSpace(title[:m[0]])
+				continue
+			}
+		}
+
+		if m := dashSuffix.FindStringSubmatchIndex(title); m != nil {
+			suffix := strings.TrimSpace(title[m[2]:m[3]])
+			if loc := featuring.FindStringIndex(suffix); loc != nil {
+				featured = append(featured, SplitArtists(suffix[loc[1]:])...)
+				title = strings.TrimSpace(title[:m[0]])
+				continue
+			}
+			if versionSuffix.MatchString(suffix) {
+				title = strings.TrimSpace(title[:m[0]])
+				continue
+			}
+		}
+
+		if m := inlineFeaturing.FindStringSubmatchIndex(title); m != nil {
+			featured = append(featured, SplitArtists(title[m[4]:m[5]])...)
+			title = strings.TrimSpace(title[:m[0]])
+			continue
+		}
+
+		return title, featured
+	}
+}
+
+// SplitArtists splits a credit like "A, B & C feat. D" into its artists
+func SplitArtists(artists string) []string {
+	parts := artistSeparator.Split(artists, -1)
+	out := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// TitleKey is the normalized title without version suffixes and featured artists.
+// Titles of the same track share it, so it can be looked up with an exact index match.
+func TitleKey(title string) string {
+	title, _ = CleanTitle(title)
+	return Normalize(title)
+}
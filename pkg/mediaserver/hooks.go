@@ -0,0 +1,105 @@
+package mediaserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// maxDelayFactor caps how long a steady stream of downloads can hold back a rescan, in debounce periods
+const maxDelayFactor = 5
+
+// Server is a media server to rescan and its debounce period
+type Server struct {
+	Scanner  Scanner
+	Debounce time.Duration
+}
+
+// Hooks rescans media servers after downloads. Triggers are debounced per server, a rescan
+// starts once no trigger came for the debounce period, at the latest maxDelayFactor periods after the first.
+type Hooks struct {
+	log   *zap.Logger
+	hooks []*hook
+}
+
+type hook struct {
+	Server
+	log     *zap.Logger
+	trigger chan struct{}
+}
+
+func NewHooks(log *zap.Logger, servers ...Server) *Hooks {
+	h := &Hooks{log: log}
+	for _, server := range servers {
+		h.hooks = append(h.hooks, &hook{
+			Server:  server,
+			log:     log.With(zap.String("server", server.Scanner.Name())),
+			trigger: make(chan struct{}, 1),
+		})
+	}
+	return h
+}
+
+// Trigger schedules a rescan of every server, it never blocks
+func (h *Hooks) Trigger() {
+	for _, hook := range h.hooks {
+		select {
+		case hook.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run rescans the servers as triggered until ctx is cancelled, pending rescans are dropped then
+func (h *Hooks) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, hook := range h.hooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hook.run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (h *hook) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	timer.Stop()
+	defer timer.Stop()
+
+	var pending <-chan time.Time
+	var first time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.trigger:
+			now := time.Now()
+			if pending == nil {
+				first = now
+			}
+			wait := min(h.Debounce, first.Add(maxDelayFactor*h.Debounce).Sub(now))
+			timer.Reset(max(wait, 0))
+			pending = timer.C
+		case <-pending:
+			pending = nil
+			h.rescan(ctx)
+		}
+	}
+}
+
+func (h *hook) rescan(ctx context.Context) {
+	name := h.Scanner.Name()
+	if err := h.Scanner.Rescan(ctx); err != nil {
+		h.log.Error("failed to start media server rescan", zap.Error(err))
+		metrics.LibraryRescans.WithLabelValues(name, "failure").Inc()
+		return
+	}
+
+	h.log.Info("started media server rescan")
+	metrics.LibraryRescans.WithLabelValues(name, "success").Inc()
+}
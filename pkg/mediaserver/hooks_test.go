@@ -0,0 +1,124 @@
+package mediaserver
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/subsonic"
+	"go.uber.org/zap"
+)
+
+func TestScanners(t *testing.T) {
+	var jellyfin, plex, navidrome atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/Library/Refresh" && r.Header.Get("X-Emby-Token") == "jf-key":
+			jellyfin.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && r.URL.Path == "/library/sections/3/refresh" && r.Header.Get("X-Plex-Token") == "plex-token":
+			plex.Add(1)
+		case r.URL.Path == "/rest/startScan":
+			_ = r.ParseForm()
+			token := md5.Sum([]byte("nd-pass" + r.Form.Get("s")))
+			if r.Form.Get("u") != "admin" || r.Form.Get("t") != hex.EncodeToString(token[:]) {
+				t.Errorf("unexpected navidrome credentials %v", r.Form)
+			}
+			navidrome.Add(1)
+			_, _ = w.Write([]byte(`{"subsonic-response": {"status": "ok", "version": "1.16.1", "scanStatus": {"scanning": true}}}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	scanners := []Scanner{
+		NewJellyfin(srv.URL+"/", "jf-key", time.Second),
+		NewPlex(srv.URL, "plex-token", "3", time.Second),
+		NewNavidrome(subsonic.NewClient(srv.URL, "admin", "nd-pass", time.Second)),
+	}
+	for _, scanner := range scanners {
+		if err := scanner.Rescan(ctx); err != nil {
+			t.Errorf("%s rescan failed: %v", scanner.Name(), err)
+		}
+	}
+
+	if jellyfin.Load() != 1 || plex.Load() != 1 || navidrome.Load() != 1 {
+		t.Errorf("expected one call per server, got jellyfin=%d plex=%d navidrome=%d", jellyfin.Load(), plex.Load(), navidrome.Load())
+	}
+
+	if err := NewJellyfin(srv.URL, "wrong", time.Second).Rescan(ctx); err == nil {
+		t.Error("expected an error for a rejected rescan")
+	}
+}
+
+type countingScanner struct {
+	calls atomic.Int32
+}
+
+func (c *countingScanner) Name() string {
+	return "counting"
+}
+
+func (c *countingScanner) Rescan(ctx context.Context) error {
+	c.calls.Add(1)
+	return nil
+}
+
+func TestHooks_Debounce(t *testing.T) {
+	scanner := &countingScanner{}
+	hooks := NewHooks(zap.NewNop(), Server{Scanner: scanner, Debounce: 100 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		hooks.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// a burst of triggers results in a single rescan after the quiet period
+	for range 5 {
+		hooks.Trigger()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls := scanner.calls.Load(); calls != 0 {
+		t.Fatalf("rescan started before the debounce period, calls=%d", calls)
+	}
+
+	waitFor(t, func() bool { return scanner.calls.Load() == 1 })
+	time.Sleep(200 * time.Millisecond)
+	if calls := scanner.calls.Load(); calls != 1 {
+		t.Fatalf("expected a single rescan, got %d", calls)
+	}
+
+	// continuous triggers can't hold the rescan back longer than the max delay
+	deadline := time.Now().Add(maxDelayFactor*100*time.Millisecond + 200*time.Millisecond)
+	for scanner.calls.Load() == 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("steady triggers held back the rescan")
+		}
+		hooks.Trigger()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
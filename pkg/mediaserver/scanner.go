@@ -0,0 +1,115 @@
+package mediaserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/subsonic"
+)
+
+// Scanner starts a library rescan on a media server
+type Scanner interface {
+	Name() string
+	Rescan(ctx context.Context) error
+}
+
+// Jellyfin refreshes all Jellyfin libraries
+type Jellyfin struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+func NewJellyfin(baseURL, apiKey string, timeout time.Duration) *Jellyfin {
+	return &Jellyfin{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+func (j *Jellyfin) Name() string {
+	return "jellyfin"
+}
+
+func (j *Jellyfin) Rescan(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.baseURL+"/Library/Refresh", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Emby-Token", j.apiKey)
+
+	return do(j.http, req)
+}
+
+// Plex refreshes one Plex library section, or all of them without a section
+type Plex struct {
+	baseURL string
+	token   string
+	section string
+	http    *http.Client
+}
+
+func NewPlex(baseURL, token, section string, timeout time.Duration) *Plex {
+	if section == "" {
+		section = "all"
+	}
+
+	return &Plex{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		section: section,
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *Plex) Name() string {
+	return "plex"
+}
+
+func (p *Plex) Rescan(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/library/sections/"+url.PathEscape(p.section)+"/refresh", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Plex-Token", p.token)
+
+	return do(p.http, req)
+}
+
+// Navidrome starts a quick Navidrome scan through the Subsonic API
+type Navidrome struct {
+	client *subsonic.Client
+}
+
+func NewNavidrome(client *subsonic.Client) *Navidrome {
+	return &Navidrome{client: client}
+}
+
+func (n *Navidrome) Name() string {
+	return "navidrome"
+}
+
+func (n *Navidrome) Rescan(ctx context.Context) error {
+	return n.client.StartScan(ctx)
+}
+
+// do sends req and fails on any non 2xx status
+func do(client *http.Client, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s %s returned status %d", req.Method, req.URL.Path, res.StatusCode)
+	}
+	return nil
+}
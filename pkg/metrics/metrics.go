@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "spotdl_wrapper"
+
+var (
+	// ActiveRequests is the number of active download requests seen by the last pass
+	ActiveRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_requests",
+		Help:      "Number of active download requests at the start of the last processing pass.",
+	})
+
+	// ErroredRequests is the number of active download requests that errored before
+	ErroredRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "errored_requests",
+		Help:      "Number of active download requests flagged as errored at the start of the last processing pass.",
+	})
+
+	// LastPassTimestamp is set when a processing pass finishes, alert on it to detect a stalled queue
+	LastPassTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_pass_timestamp_seconds",
+		Help:      "Unix time the last processing pass finished.",
+	})
+
+	// DownloadDuration observes how long a single sync of a request took
+	DownloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Duration of a single sync of a download request.",
+		Buckets:   []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200},
+	}, []string{"object_type", "outcome"})
+
+	// CommandExits counts finished downloader processes by exit code
+	CommandExits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "command_exits_total",
+		Help:      "Finished downloader processes by command and exit code, -1 means killed by a signal.",
+	}, []string{"command", "code"})
+
+	// RequestTracksFound is the found track count of each active request
+	RequestTracksFound = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "request_tracks_found",
+		Help:      "Tracks of an active request that are present in the library.",
+	}, []string{"request_id"})
+
+	// RequestTracksSkipped is the skipped track count of each active request
+	RequestTracksSkipped = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "request_tracks_skipped",
+		Help:      "Tracks of an active request that were given up on.",
+	}, []string{"request_id"})
+
+	// PlaylistGenerations counts generated playlist files
+	PlaylistGenerations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "playlist_generations_total",
+		Help:      "Playlist file generations by result.",
+	}, []string{"result"})
+
+	// PlaylistPublishes counts playlists pushed to a media server
+	PlaylistPublishes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "playlist_publishes_total",
+		Help:      "Playlists pushed to a media server by result.",
+	}, []string{"result"})
+
+	// LibraryRescans counts media server rescans started after downloads
+	LibraryRescans = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "library_rescans_total",
+		Help:      "Media server library rescans by server and result.",
+	}, []string{"server", "result"})
+
+	// EventDeliveries counts lifecycle events handed to the event sinks
+	EventDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_deliveries_total",
+		Help:      "Lifecycle event deliveries by sink and result.",
+	}, []string{"sink", "result"})
+
+	// RequestFailures counts failed request attempts by whether retrying can help
+	RequestFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "request_failures_total",
+		Help:      "Failed download and playlist request attempts by kind and class: retryable or permanent.",
+	}, []string{"kind", "class"})
+
+	// DeadLetters counts requests that were given up and dead-lettered
+	DeadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Download requests that were given up after failing and stored as dead letters.",
+	})
+
+	// RequestLeases counts lease events of download requests shared between instances
+	RequestLeases = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "request_leases_total",
+		Help:      "Download request leases by event: claimed, lost or expired.",
+	}, []string{"event"})
+
+	// QueueWakeups counts queue changes reported by the change stream
+	QueueWakeups = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queue_wakeups_total",
+		Help:      "Queue changes that woke the processing loop.",
+	})
+
+	// MongoReconnects counts reconnects after a failed ping
+	MongoReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mongo_reconnects_total",
+		Help:      "Reconnects to MongoDB after a failed ping by result.",
+	}, []string{"result"})
+
+	// LokiPushFailures counts log batches that could not be delivered to Loki
+	LokiPushFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "loki_push_failures_total",
+		Help:      "Log batches that failed to be pushed to Loki.",
+	})
+)
+
+// ForgetRequest drops the per request series once a request is no longer active
+func ForgetRequest(requestID string) {
+	RequestTracksFound.DeleteLabelValues(requestID)
+	RequestTracksSkipped.DeleteLabelValues(requestID)
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrDownloadTimedOut = errors.New("download timed out")
+)
+
+// outputTailLines is how many output lines of a command are kept for the dead letter of a failed request
+const outputTailLines = 50
+
+// commandWaitDelay bounds how long we wait for the output pipes to close after the process was killed
+const commandWaitDelay = 10 * time.Second
+
+// runCommand runs the command and streams its output to the logger and to onLine, if set.
+// The process runs in its own process group, the whole group is killed when
+// ctx is cancelled or the timeout expires. A zero timeout means no timeout.
+func runCommand(ctx context.Context, log *zap.Logger, timeout time.Duration, onLine func(line string), name string, args ...string) error {
+	cmdCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(cmdCtx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		// Own process group so ffmpeg/yt-dlp children are killed together with spotdl
+		Setpgid: true,
+		// Kill child process when parent dies
+		Pdeathsig: syscall.SIGKILL,
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = commandWaitDelay
+
+	log.Info("executing command", zap.String("command", cmd.String()), zap.Duration("timeout", timeout))
+
+	// Capture stdout and stderr through the logger
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	// Start the command
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Stream stdout and stderr to logger, Wait closes the pipes so the readers have to finish first
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		streamOutput(log, stdout, name, "stdout", onLine)
+	}()
+	go func() {
+		defer wg.Done()
+		streamOutput(log, stderr, name, "stderr", onLine)
+	}()
+	wg.Wait()
+
+	// Wait for the command to finish
+	err = cmd.Wait()
+	metrics.CommandExits.WithLabelValues(name, strconv.Itoa(cmd.ProcessState.ExitCode())).Inc()
+	if err != nil && ctx.Err() == nil && errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
+		log.Warn("command timed out, killed process group", zap.String("command", name), zap.Duration("timeout", timeout))
+		return fmt.Errorf("%w: %s did not finish within %s", ErrDownloadTimedOut, name, timeout)
+	}
+
+	return err
+}
+
+// outputTail keeps the last outputTailLines lines of a command, stdout and stderr are added concurrently
+type outputTail struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (t *outputTail) add(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.lines) == outputTailLines {
+		t.lines = append(t.lines[:0], t.lines[1:]...)
+	}
+	t.lines = append(t.lines, line)
+}
+
+func (t *outputTail) get() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]string(nil), t.lines...)
+}
+
+// streamOutput reads from a pipe and logs each line
+func streamOutput(log *zap.Logger, pipe io.ReadCloser, name, stream string, onLine func(line string)) {
+	scanner := bufio.NewScanner(pipe)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			log.Info(name, zap.String("stream", stream), zap.String("output", line))
+			if onLine != nil {
+				onLine(line)
+			}
+		}
+	}
+}
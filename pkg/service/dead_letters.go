@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
+	models "github.com/supperdoggy/spot-models"
+	"go.uber.org/zap"
+)
+
+// addDeadLetter stores why a request was given up, so it can be inspected and requeued later
+func (s *service) addDeadLetter(ctx context.Context, request models.DownloadQueueRequest, reason string) {
+	if reason == "" {
+		reason = fmt.Sprintf("gave up after %d syncs with %d of %d tracks found",
+			request.SyncCount, request.FoundTrackCount, request.ExpectedTrackCount)
+	}
+
+	letter := db.DeadLetter{
+		RequestID:     request.ID,
+		SpotifyURL:    request.SpotifyURL,
+		Name:          request.Name,
+		ObjectType:    request.ObjectType,
+		CreatorID:     request.CreatorID,
+		SyncCount:     request.SyncCount,
+		RetryCount:    request.RetryCount,
+		Error:         reason,
+		OutputTail:    make([]string, 0),
+		SkippedTracks: make([]db.DeadLetterTrack, 0),
+		CreatedAt:     time.Now().Unix(),
+	}
+	if tail, ok := s.outputTails.Load(request.ID); ok {
+		letter.OutputTail = tail.([]string)
+	}
+
+	reasons := make(map[string]string)
+	state, err := s.database.GetRequestState(ctx, request.ID)
+	if err != nil {
+		s.log.Error("failed to get request state", zap.Error(err), zap.String("request_id", request.ID))
+	}
+	for _, result := range state.TrackResults {
+		reasons[trackResultKey(result.SpotifyURL, result.Artist, result.Title)] = result.Reason
+	}
+
+	for _, track := range request.TrackMetadata {
+		if !track.Skipped || track.Found {
+			continue
+		}
+		letter.SkippedTracks = append(letter.SkippedTracks, db.DeadLetterTrack{
+			Artist:         track.Artist,
+			Title:          track.Title,
+			SpotifyURL:     track.SpotifyURL,
+			FailedAttempts: track.FailedAttempts,
+			Reason:         reasons[trackResultKey(track.SpotifyURL, track.Artist, track.Title)],
+		})
+	}
+
+	if err := s.database.AddDeadLetter(ctx, letter); err != nil {
+		s.log.Error("failed to add dead letter", zap.Error(err), zap.String("request_id", request.ID))
+		return
+	}
+
+	metrics.DeadLetters.Inc()
+	s.log.Warn("request dead-lettered", zap.String("request_id", request.ID), zap.String("error", reason),
+		zap.Int("skipped_tracks", len(letter.SkippedTracks)))
+}
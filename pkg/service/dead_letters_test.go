@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+func TestHandleDownloadRequest_DeadLetters(t *testing.T) {
+	ctx := context.Background()
+	database := db.NewMemoryDatabase()
+	downloader := &fakeDownloader{err: errors.New("exit status 1"), tail: []string{"Processing query", "AudioProviderError: YT-DLP download error"}}
+	srv := NewService(database, zap.NewNop(), &fakeSpotify{}, downloader, Options{
+		Retry: RetryPolicy{MaxAttempts: map[spotify.SpotifyObjectType]int{spotify.SpotifyObjectTypeAlbum: 2}},
+	}).(*service)
+
+	url := "https://open.spotify.com/album/1"
+	if err := database.NewDownloadRequest(ctx, url, "album", 1, spotify.SpotifyObjectTypeAlbum); err != nil {
+		t.Fatalf("NewDownloadRequest failed: %v", err)
+	}
+	request, err := database.GetActiveRequest(ctx, url)
+	if err != nil {
+		t.Fatalf("GetActiveRequest failed: %v", err)
+	}
+	request.ExpectedTrackCount = 2
+	request.TrackMetadata = []spotify.TrackMetadata{
+		{Artist: "A", Title: "one", SpotifyURL: "https://open.spotify.com/track/1"},
+		{Artist: "B", Title: "two", SpotifyURL: "https://open.spotify.com/track/2", Skipped: true, FailedAttempts: 3},
+	}
+	if err := database.UpdateActiveRequest(ctx, request); err != nil {
+		t.Fatalf("UpdateActiveRequest failed: %v", err)
+	}
+	if err := database.SetTrackResults(ctx, request.ID, []db.TrackResult{
+		{Artist: "B", Title: "two", SpotifyURL: "https://open.spotify.com/track/2", Status: "lookup_error", Reason: "no results found"},
+	}); err != nil {
+		t.Fatalf("SetTrackResults failed: %v", err)
+	}
+
+	// the first failure is retried
+	srv.handleDownloadRequest(ctx, request)
+	if _, err := database.GetDeadLetter(ctx, request.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("request dead-lettered before the retry policy gave up, got %v", err)
+	}
+
+	request, err = database.GetRequest(ctx, request.ID)
+	if err != nil {
+		t.Fatalf("GetRequest failed: %v", err)
+	}
+	srv.handleDownloadRequest(ctx, request)
+
+	letter, err := database.GetDeadLetter(ctx, request.ID)
+	if err != nil {
+		t.Fatalf("GetDeadLetter failed: %v", err)
+	}
+	if letter.Error != "exit status 1" || letter.RetryCount != 2 || letter.SpotifyURL != url {
+		t.Errorf("unexpected dead letter: %+v", letter)
+	}
+	if !slices.Equal(letter.OutputTail, downloader.tail) {
+		t.Errorf("output tail = %v, want %v", letter.OutputTail, downloader.tail)
+	}
+	want := []db.DeadLetterTrack{
+		{Artist: "B", Title: "two", SpotifyURL: "https://open.spotify.com/track/2", FailedAttempts: 3, Reason: "no results found"},
+	}
+	if !slices.Equal(letter.SkippedTracks, want) {
+		t.Errorf("skipped tracks = %+v, want %+v", letter.SkippedTracks, want)
+	}
+	if _, ok := srv.outputTails.Load(request.ID); ok {
+		t.Error("output tail kept after the request was handled")
+	}
+}
@@ -1,27 +1,43 @@
 package service
 
 import (
-	"bufio"
 	"context"
-	"io"
-	"os/exec"
-	"sort"
-	"strings"
-	"syscall"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/spotdl"
 	models "github.com/supperdoggy/spot-models"
 	"github.com/supperdoggy/spot-models/spotify"
 	"go.uber.org/zap"
 )
 
+// maxRequestSyncs caps the successful syncs of a request whose tracks are not all found,
+// failed attempts are capped by the retry policy
+const maxRequestSyncs = 3
+
 func (s *service) ProcessDownloadRequest(ctx context.Context) error {
+	passStarted := time.Now()
+	s.releaseExpiredLeases(ctx)
+
 	active, err := s.database.GetActiveRequests(ctx)
 	if err != nil {
 		s.log.Error("failed to get active requests", zap.Error(err))
 		return err
 	}
 
+	errored := 0
+	for _, request := range active {
+		if request.Errored {
+			errored++
+		}
+	}
+	metrics.ActiveRequests.Set(float64(len(active)))
+	metrics.ErroredRequests.Set(float64(errored))
+
 	if len(active) == 0 {
 		s.log.Info("no active requests to process")
 		return nil
@@ -29,57 +45,42 @@ func (s *service) ProcessDownloadRequest(ctx context.Context) error {
 
 	s.log.Info("processing active requests", zap.Any("requests", len(active)))
 
-	// sort active by errored so errored requests are processed last
-	sort.Slice(active, func(i, j int) bool {
-		if active[i].Errored && !active[j].Errored {
-			return false
-		}
-		if !active[i].Errored && active[j].Errored {
-			return true
-		}
-		return active[i].CreatedAt < active[j].CreatedAt
-	})
-
-	s.log.Info("sorted active requests", zap.Any("requests", active))
-
-	for _, request := range active {
-		time.Sleep(time.Duration(s.sleepInMinutes) * time.Minute)
-
-		request.SyncCount++
-		if err := s.ProcessRequest(ctx, request); err != nil {
-			s.log.Error("failed to process request", zap.Error(err), zap.Any("request", request))
-			request.Errored = true
-			request.RetryCount++
-			s.log.Warn("request processing encountered an error", zap.Any("request", request))
-		}
-
-		// Re-fetch request to get updated track metadata (Found/Skipped status)
-		updatedRequest, err := s.database.GetActiveRequest(ctx, request.SpotifyURL)
+	// Claim requests one at a time so other instances sharing the queue get the rest.
+	// Claims come in order, errored requests last, each request at most once per pass.
+	// Wait for a free worker, claim, then wait for the shared rate limiter.
+	workers := make(chan struct{}, max(s.downloadWorkers, 1))
+	var wg sync.WaitGroup
+	var claimErr error
+	for !s.stopRequested() {
+		workers <- struct{}{}
+		request, err := s.database.ClaimNextRequest(ctx, s.instanceID, s.leaseDuration, passStarted)
 		if err != nil {
-			s.log.Error("failed to re-fetch request", zap.Error(err))
-		} else {
-			request.TrackMetadata = updatedRequest.TrackMetadata
-			request.FoundTrackCount = updatedRequest.FoundTrackCount
-		}
-
-		// Check if all non-skipped tracks are found (early completion)
-		if s.isRequestComplete(request) {
-			s.log.Info("all non-skipped tracks found, marking request as complete",
-				zap.String("request_id", request.ID))
-			request.Active = false
+			<-workers
+			if !errors.Is(err, db.ErrNotFound) {
+				s.log.Error("failed to claim next request", zap.Error(err))
+				claimErr = err
+			}
+			break
 		}
 
-		// Fallback: deactivate after max sync attempts
-		if request.SyncCount >= 3 {
-			request.Active = false
+		leaseCtx, release := s.holdLease(ctx, request)
+		if err := s.sleep(ctx, s.downloadLimiter.reserve()); err != nil {
+			release()
+			<-workers
+			s.log.Info("stopping download processing", zap.Error(err))
+			break
 		}
 
-		s.log.Info("updated request status", zap.Any("request", request))
-
-		if err := s.database.UpdateActiveRequest(ctx, request); err != nil {
-			s.log.Error("failed to update request", zap.Error(err), zap.Any("request", request))
-		}
+		wg.Add(1)
+		go func(request models.DownloadQueueRequest) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			defer release()
+			s.handleDownloadRequest(leaseCtx, request)
+		}(request)
 	}
+	wg.Wait()
+	s.rescanLibrary()
 
 	indexStatus, err := s.database.GetIndexStatus(ctx)
 	if err != nil {
@@ -93,8 +94,124 @@ func (s *service) ProcessDownloadRequest(ctx context.Context) error {
 		return err
 	}
 
+	if err := s.IndexDownloadedFiles(ctx); err != nil {
+		return err
+	}
+
 	s.log.Info("completed processing of active requests")
-	return nil
+	return claimErr
+}
+
+// handleDownloadRequest runs one sync of the request and persists its new state
+func (s *service) handleDownloadRequest(ctx context.Context, request models.DownloadQueueRequest) {
+	s.tick()
+	defer s.tick()
+
+	if request.SyncCount == 0 && request.RetryCount == 0 {
+		s.publish(requestEvent(EventRequestCreated, request))
+	}
+	defer s.outputTails.Delete(request.ID)
+
+	request.SyncCount++
+	outcome, outcomeMessage := db.RequestOutcomeSucceeded, ""
+	var nextAttempt time.Time
+	giveUp := false
+	started := time.Now()
+	err := s.ProcessRequest(ctx, request)
+	if err != nil {
+		if ctx.Err() != nil {
+			// shutdown or the lease was taken over by another instance
+			s.log.Warn("request interrupted", zap.Error(err), zap.NamedError("cause", context.Cause(ctx)),
+				zap.String("request_id", request.ID))
+			return
+		}
+
+		outcomeMessage = err.Error()
+		class := classifyError(err)
+		// A failed attempt doesn't use up a sync, it counts against the retry policy instead
+		request.SyncCount--
+		request.RetryCount++
+		if errors.Is(err, ErrDownloadTimedOut) {
+			s.log.Warn("request timed out", zap.Error(err), zap.Any("request", request))
+			outcome = db.RequestOutcomeTimedOut
+		} else {
+			s.log.Error("failed to process request", zap.Error(err), zap.String("class", string(class)), zap.Any("request", request))
+			outcome = db.RequestOutcomeFailed
+			request.Errored = true
+		}
+		metrics.RequestFailures.WithLabelValues("download", string(class)).Inc()
+
+		switch {
+		case class == errorPermanent:
+			s.log.Warn("giving up request after a permanent failure", zap.String("request_id", request.ID))
+			giveUp = true
+		case request.RetryCount >= s.retry.maxAttempts(request.ObjectType):
+			s.log.Warn("giving up request after max attempts", zap.String("request_id", request.ID),
+				zap.Int("attempts", request.RetryCount))
+			giveUp = true
+		default:
+			nextAttempt = time.Now().Add(s.retry.delay(request.RetryCount))
+			s.log.Info("scheduled request retry", zap.String("request_id", request.ID), zap.Time("next_attempt_at", nextAttempt))
+		}
+	}
+
+	objectType := string(request.ObjectType)
+	if objectType == "" {
+		objectType = "unknown"
+	}
+	metrics.DownloadDuration.WithLabelValues(objectType, string(outcome)).Observe(time.Since(started).Seconds())
+
+	if err := s.database.SetRequestOutcome(ctx, request.ID, outcome, outcomeMessage); err != nil {
+		s.log.Error("failed to record request outcome", zap.Error(err), zap.String("request_id", request.ID))
+	}
+	if err := s.database.SetNextAttempt(ctx, request.ID, nextAttempt); err != nil {
+		s.log.Error("failed to schedule next attempt", zap.Error(err), zap.String("request_id", request.ID))
+	}
+
+	// Re-fetch request to get updated track metadata (Found/Skipped status)
+	updatedRequest, err := s.database.GetActiveRequest(ctx, request.SpotifyURL)
+	if errors.Is(err, db.ErrNotFound) {
+		// Deactivated while we were syncing it, e.g. cancelled through the api
+		s.log.Info("request was deactivated during processing", zap.String("request_id", request.ID))
+		request.Active = false
+	} else if err != nil {
+		s.log.Error("failed to re-fetch request", zap.Error(err))
+	} else {
+		request.TrackMetadata = updatedRequest.TrackMetadata
+		request.FoundTrackCount = updatedRequest.FoundTrackCount
+	}
+
+	// Check if all non-skipped tracks are found (early completion)
+	if request.Active && s.isRequestComplete(request) {
+		s.log.Info("all non-skipped tracks found, marking request as complete",
+			zap.String("request_id", request.ID))
+		request.Active = false
+		s.publish(requestSummaryEvent(EventRequestCompleted, request))
+	}
+
+	// Fallback: deactivate after max syncs or when the retry policy gave up
+	deadLetter := false
+	if request.Active && (request.SyncCount >= maxRequestSyncs || giveUp) {
+		request.Active = false
+		event := requestSummaryEvent(EventRequestDeactivated, request)
+		event.Reason = outcomeMessage
+		s.publish(event)
+		deadLetter = giveUp || request.Errored
+	}
+
+	s.log.Info("updated request status", zap.Any("request", request))
+
+	if !request.Active {
+		metrics.ForgetRequest(request.ID)
+	}
+
+	if err := s.database.UpdateActiveRequest(ctx, request); err != nil {
+		s.log.Error("failed to update request", zap.Error(err), zap.Any("request", request))
+	}
+
+	if deadLetter {
+		s.addDeadLetter(ctx, request, outcomeMessage)
+	}
 }
 
 // ProcessRequest processes the request
@@ -111,6 +228,10 @@ func (s *service) ProcessRequest(ctx context.Context, request models.DownloadQue
 		trackCount, trackMetadata, err := s.spotifyService.GetTrackCount(ctx, request.SpotifyURL)
 		if err != nil {
 			s.log.Error("failed to get track count", zap.Error(err), zap.String("url", request.SpotifyURL))
+			// e.g. removed from Spotify, there is nothing to download
+			if classifyError(err) == errorPermanent {
+				return fmt.Errorf("failed to get track count: %w", err)
+			}
 			// Continue anyway, we'll just not have progress tracking
 		} else {
 			request.ExpectedTrackCount = trackCount
@@ -131,6 +252,9 @@ func (s *service) ProcessRequest(ctx context.Context, request models.DownloadQue
 		objectType, err = s.spotifyService.GetObjectType(ctx, request.SpotifyURL)
 		if err != nil {
 			s.log.Error("failed to get object type", zap.Error(err), zap.String("url", request.SpotifyURL))
+			if classifyError(err) == errorPermanent {
+				return fmt.Errorf("failed to get object type: %w", err)
+			}
 			// Fall back to old behavior if we can't determine type
 			objectType = ""
 		} else {
@@ -170,6 +294,11 @@ func (s *service) processPlaylistRequest(ctx context.Context, request models.Dow
 
 	// Download missing tracks individually
 	for i := range request.TrackMetadata {
+		if s.stopRequested() {
+			s.log.Info("shutdown requested, leaving remaining tracks for the next run", zap.String("url", request.SpotifyURL))
+			break
+		}
+
 		track := &request.TrackMetadata[i]
 
 		// Skip tracks that are already found or skipped
@@ -187,15 +316,33 @@ func (s *service) processPlaylistRequest(ctx context.Context, request models.Dow
 		s.log.Info("downloading individual track", zap.String("url", track.SpotifyURL), zap.String("artist", track.Artist), zap.String("title", track.Title))
 
 		// Download the track
-		if err := s.DownloadSingleTrack(ctx, track.SpotifyURL); err != nil {
-			s.log.Error("failed to download track", zap.Error(err), zap.String("url", track.SpotifyURL))
-			track.FailedAttempts++
-			if track.FailedAttempts >= spotify.MaxFailedAttempts {
-				track.Skipped = true
+		s.tick()
+		result, err := s.downloader.DownloadTrack(ctx, *track)
+		for i := range result.Events {
+			// everything printed by a single track run is about that track
+			if result.Events[i].SpotifyURL == "" {
+				result.Events[i].SpotifyURL = track.SpotifyURL
+			}
+		}
+		s.recordDownloadEvents(ctx, request, result.Events)
+		s.filesDownloaded(ctx, result.Files)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			s.outputTails.Store(request.ID, result.OutputTail)
+			kind := failureKind(result.Events)
+			s.log.Error("failed to download track", zap.Error(err), zap.String("url", track.SpotifyURL),
+				zap.Bool("timed_out", errors.Is(err, ErrDownloadTimedOut)), zap.String("kind", string(kind)))
+			if countTrackFailure(track, kind) {
 				s.log.Warn("marking track as skipped after max failed attempts",
 					zap.String("artist", track.Artist),
 					zap.String("title", track.Title),
 					zap.Int("failed_attempts", track.FailedAttempts))
+				event := requestEvent(EventTrackSkipped, request)
+				event.Track = &EventTrack{Artist: track.Artist, Title: track.Title, SpotifyURL: track.SpotifyURL, FailedAttempts: track.FailedAttempts}
+				event.Reason = err.Error()
+				s.publish(event)
 			}
 		} else {
 			// After download, check if track now exists in DB
@@ -222,49 +369,17 @@ func (s *service) processPlaylistRequest(ctx context.Context, request models.Dow
 
 // processBulkDownload handles album/track downloads using the original bulk method
 func (s *service) processBulkDownload(ctx context.Context, request models.DownloadQueueRequest) error {
-	s.log.Info("processing bulk download request", zap.String("url", request.SpotifyURL))
-
-	// Build output format with destination path
-	args := []string{
-		request.SpotifyURL,
-		"--output", s.destination,
-		"--config",
-		"--no-cache",
-		"--sync-without-deleting",
-	}
+	s.log.Info("processing bulk download request", zap.String("url", request.SpotifyURL), zap.String("downloader", s.downloader.Name()))
 
-	// Run the "spotdl --sync {url}" command
-	cmd := exec.Command("spotdl", args...)
-	// Kill child process when parent dies
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Pdeathsig: syscall.SIGKILL,
-	}
-
-	s.log.Info("executing command", zap.String("command", cmd.String()))
-
-	// Capture stdout and stderr through the logger
-	stdout, err := cmd.StdoutPipe()
+	result, err := s.downloader.DownloadBulk(ctx, request)
+	s.recordDownloadEvents(ctx, request, result.Events)
+	s.filesDownloaded(ctx, result.Files)
 	if err != nil {
-		return err
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
-	}
-
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return err
+		s.outputTails.Store(request.ID, result.OutputTail)
+		return downloadError(err, result)
 	}
 
-	// Stream stdout and stderr to logger
-	go s.streamOutput(stdout, "stdout")
-	go s.streamOutput(stderr, "stderr")
-
-	// Wait for the command to finish
-	if err := cmd.Wait(); err != nil {
-		return err
-	}
+	s.log.Info("bulk download finished", zap.String("url", request.SpotifyURL), zap.Strings("files", result.Files))
 
 	// After download completes, compare with indexed files
 	if request.ExpectedTrackCount > 0 && len(request.TrackMetadata) > 0 {
@@ -283,33 +398,22 @@ func (s *service) preCheckTracksInDB(ctx context.Context, request *models.Downlo
 		return nil
 	}
 
-	// Extract artists and titles from all tracks
-	artists := make([]string, 0, len(request.TrackMetadata))
-	titles := make([]string, 0, len(request.TrackMetadata))
+	tracks := make([]libraryQuery, 0, len(request.TrackMetadata))
 	for _, track := range request.TrackMetadata {
-		artists = append(artists, track.Artist)
-		titles = append(titles, track.Title)
+		tracks = append(tracks, matchTrack(track))
 	}
 
 	// Find matching music files in the database
-	foundMusic, err := s.database.FindMusicFiles(ctx, artists, titles)
+	found, err := s.findInLibrary(ctx, tracks)
 	if err != nil {
 		return err
 	}
 
-	// Create a map for quick lookup
-	foundMap := make(map[string]bool)
-	for _, music := range foundMusic {
-		key := strings.ToLower(music.Artist) + " " + strings.ToLower(music.Title)
-		foundMap[key] = true
-	}
-
 	// Mark tracks that already exist as Found
 	foundCount := 0
 	for i := range request.TrackMetadata {
 		track := &request.TrackMetadata[i]
-		key := strings.ToLower(track.Artist) + " " + strings.ToLower(track.Title)
-		if foundMap[key] {
+		if found[i] != nil {
 			track.Found = true
 			track.FailedAttempts = 0
 			foundCount++
@@ -328,23 +432,15 @@ func (s *service) preCheckTracksInDB(ctx context.Context, request *models.Downlo
 
 // checkSingleTrackInDB checks if a single track exists in the database after download
 func (s *service) checkSingleTrackInDB(ctx context.Context, track *spotify.TrackMetadata) error {
-	artists := []string{track.Artist}
-	titles := []string{track.Title}
-
-	foundMusic, err := s.database.FindMusicFiles(ctx, artists, titles)
+	found, err := s.findInLibrary(ctx, []libraryQuery{matchTrack(*track)})
 	if err != nil {
 		return err
 	}
 
-	// Check if we found a match
-	for _, music := range foundMusic {
-		key := strings.ToLower(music.Artist) + " " + strings.ToLower(music.Title)
-		trackKey := strings.ToLower(track.Artist) + " " + strings.ToLower(track.Title)
-		if key == trackKey {
-			track.Found = true
-			track.FailedAttempts = 0
-			return nil
-		}
+	if found[0] != nil {
+		track.Found = true
+		track.FailedAttempts = 0
+		return nil
 	}
 
 	// Track not found yet - might need indexing, will be checked on next sync
@@ -357,27 +453,26 @@ func (s *service) UpdateFoundTrackCount(ctx context.Context, request models.Down
 		return nil
 	}
 
-	// Extract artists and titles from non-skipped track metadata
-	artists := make([]string, 0, len(request.TrackMetadata))
-	titles := make([]string, 0, len(request.TrackMetadata))
+	// Skipped tracks are looked up too, the result of those is ignored
+	tracks := make([]libraryQuery, 0, len(request.TrackMetadata))
 	for _, track := range request.TrackMetadata {
-		if !track.Skipped {
-			artists = append(artists, track.Artist)
-			titles = append(titles, track.Title)
-		}
+		tracks = append(tracks, matchTrack(track))
 	}
 
 	// Find matching music files in the database
-	foundMusic, err := s.database.FindMusicFiles(ctx, artists, titles)
+	found, err := s.findInLibrary(ctx, tracks)
 	if err != nil {
 		return err
 	}
 
-	// Create a map for quick lookup
-	foundMap := make(map[string]bool)
-	for _, music := range foundMusic {
-		key := strings.ToLower(music.Artist) + " " + strings.ToLower(music.Title)
-		foundMap[key] = true
+	// the recorded downloader results decide what a missing track costs
+	state, err := s.database.GetRequestState(ctx, request.ID)
+	if err != nil {
+		s.log.Error("failed to get request state", zap.Error(err), zap.String("request_id", request.ID))
+	}
+	results := make(map[string]db.TrackResult, len(state.TrackResults))
+	for _, result := range state.TrackResults {
+		results[trackResultKey(result.SpotifyURL, result.Artist, result.Title)] = result
 	}
 
 	// Update individual track status
@@ -392,22 +487,21 @@ func (s *service) UpdateFoundTrackCount(ctx context.Context, request models.Down
 			continue
 		}
 
-		key := strings.ToLower(track.Artist) + " " + strings.ToLower(track.Title)
-		if foundMap[key] {
+		if found[i] != nil {
 			track.Found = true
 			track.FailedAttempts = 0 // reset on success
 			foundCount++
 		} else {
 			track.Found = false
-			track.FailedAttempts++
+			kind := spotdl.EventKind(results[trackResultKey(track.SpotifyURL, track.Artist, track.Title)].Status)
 
 			// Mark as skipped (stuck) after max failed attempts
-			if track.FailedAttempts >= spotify.MaxFailedAttempts {
-				track.Skipped = true
+			if countTrackFailure(track, kind) {
 				skippedCount++
 				s.log.Warn("marking track as skipped (stuck)",
 					zap.String("artist", track.Artist),
 					zap.String("title", track.Title),
+					zap.String("kind", string(kind)),
 					zap.Int("failed_attempts", track.FailedAttempts))
 			}
 		}
@@ -415,6 +509,8 @@ func (s *service) UpdateFoundTrackCount(ctx context.Context, request models.Down
 
 	// Update the request with found count
 	request.FoundTrackCount = foundCount
+	metrics.RequestTracksFound.WithLabelValues(request.ID).Set(float64(foundCount))
+	metrics.RequestTracksSkipped.WithLabelValues(request.ID).Set(float64(skippedCount))
 	request.UpdatedAt = time.Now().Unix()
 
 	if err := s.database.UpdateActiveRequest(ctx, request); err != nil {
@@ -450,58 +546,3 @@ func (s *service) isRequestComplete(request models.DownloadQueueRequest) bool {
 
 	return true
 }
-
-// streamOutput reads from a pipe and logs each line
-func (s *service) streamOutput(pipe io.ReadCloser, stream string) {
-	scanner := bufio.NewScanner(pipe)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line != "" {
-			s.log.Info("spotdl", zap.String("stream", stream), zap.String("output", line))
-		}
-	}
-}
-
-// DownloadSingleTrack downloads a single track using spotdl
-func (s *service) DownloadSingleTrack(ctx context.Context, trackURL string) error {
-	args := []string{
-		trackURL,
-		"--output", s.destination,
-		"--config",
-		"--no-cache",
-	}
-
-	cmd := exec.Command("spotdl", args...)
-	// Kill child process when parent dies
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Pdeathsig: syscall.SIGKILL,
-	}
-
-	s.log.Info("executing spotdl for single track", zap.String("url", trackURL))
-
-	// Capture stdout and stderr through the logger
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
-	}
-
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-
-	// Stream stdout and stderr to logger
-	go s.streamOutput(stdout, "stdout")
-	go s.streamOutput(stderr, "stderr")
-
-	// Wait for the command to finish
-	if err := cmd.Wait(); err != nil {
-		return err
-	}
-
-	return nil
-}
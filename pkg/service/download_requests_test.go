@@ -0,0 +1,282 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/spotdl"
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+type fakeDownloader struct {
+	tracks []string
+	bulk   []string
+	err    error
+	// tail and events are reported with every result
+	tail   []string
+	events []spotdl.Event
+}
+
+func (f *fakeDownloader) Name() string {
+	return "fake"
+}
+
+func (f *fakeDownloader) DownloadTrack(ctx context.Context, track spotify.TrackMetadata) (DownloadResult, error) {
+	f.tracks = append(f.tracks, track.SpotifyURL)
+	return DownloadResult{OutputTail: f.tail, Events: f.events}, f.err
+}
+
+func (f *fakeDownloader) DownloadBulk(ctx context.Context, request models.DownloadQueueRequest) (DownloadResult, error) {
+	f.bulk = append(f.bulk, request.SpotifyURL)
+	return DownloadResult{OutputTail: f.tail, Events: f.events}, f.err
+}
+
+// fakeDatabase implements the parts of db.Database used by ProcessRequest
+type fakeDatabase struct {
+	db.Database
+
+	library []models.MusicFile
+	// tagged are library files carrying identifiers, they are only found by id
+	tagged  []db.LibraryFile
+	updates []models.DownloadQueueRequest
+	// results are the recorded downloader results of every request
+	results []db.TrackResult
+}
+
+func (f *fakeDatabase) GetRequestState(ctx context.Context, id string) (db.RequestState, error) {
+	return db.RequestState{TrackResults: f.results}, nil
+}
+
+func (f *fakeDatabase) FindMatchCandidates(ctx context.Context, titles []string) ([]db.LibraryFile, error) {
+	files := make([]db.LibraryFile, 0, len(f.library))
+	for _, file := range f.library {
+		files = append(files, db.LibraryFile{MusicFile: file})
+	}
+	return files, nil
+}
+
+func (f *fakeDatabase) FindMusicFilesBySpotifyID(ctx context.Context, ids []string) ([]db.LibraryFile, error) {
+	var files []db.LibraryFile
+	for _, file := range f.tagged {
+		if slices.Contains(ids, file.SpotifyID) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func (f *fakeDatabase) FindMusicFilesByISRC(ctx context.Context, isrcs []string) ([]db.LibraryFile, error) {
+	var files []db.LibraryFile
+	for _, file := range f.tagged {
+		if slices.Contains(isrcs, file.ISRC) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func (f *fakeDatabase) UpdateActiveRequest(ctx context.Context, request models.DownloadQueueRequest) error {
+	f.updates = append(f.updates, request)
+	return nil
+}
+
+func newTestService(database db.Database, downloader Downloader) *service {
+	return NewService(database, zap.NewNop(), nil, downloader, Options{}).(*service)
+}
+
+func TestProcessRequest_BulkUsesDownloader(t *testing.T) {
+	database := &fakeDatabase{library: []models.MusicFile{{Artist: "Artist1", Title: "Song1"}}}
+	downloader := &fakeDownloader{}
+	srv := newTestService(database, downloader)
+
+	request := models.DownloadQueueRequest{
+		ID:                 "req",
+		SpotifyURL:         "https://open.spotify.com/track/1",
+		ObjectType:         spotify.SpotifyObjectTypeTrack,
+		ExpectedTrackCount: 2,
+		TrackMetadata: []spotify.TrackMetadata{
+			{Artist: "Artist1", Title: "Song1"},
+			{Artist: "Artist2", Title: "Song2"},
+		},
+	}
+
+	if err := srv.ProcessRequest(context.Background(), request); err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	if len(downloader.bulk) != 1 || downloader.bulk[0] != request.SpotifyURL {
+		t.Fatalf("expected one bulk download of %s, got %v", request.SpotifyURL, downloader.bulk)
+	}
+
+	if len(database.updates) == 0 {
+		t.Fatal("expected the found track count to be persisted")
+	}
+	last := database.updates[len(database.updates)-1]
+	if last.FoundTrackCount != 1 {
+		t.Errorf("expected 1 found track, got %d", last.FoundTrackCount)
+	}
+	if !last.TrackMetadata[0].Found || last.TrackMetadata[1].Found {
+		t.Errorf("unexpected found state %+v", last.TrackMetadata)
+	}
+}
+
+func TestProcessRequest_PlaylistDownloadsMissingTracks(t *testing.T) {
+	database := &fakeDatabase{library: []models.MusicFile{{Artist: "Artist1", Title: "Song1"}}}
+	downloader := &fakeDownloader{}
+	srv := newTestService(database, downloader)
+
+	request := models.DownloadQueueRequest{
+		ID:                 "req",
+		SpotifyURL:         "https://open.spotify.com/playlist/1",
+		ObjectType:         spotify.SpotifyObjectTypePlaylist,
+		ExpectedTrackCount: 2,
+		TrackMetadata: []spotify.TrackMetadata{
+			{Artist: "Artist1", Title: "Song1", SpotifyURL: "https://open.spotify.com/track/1"},
+			{Artist: "Artist2", Title: "Song2", SpotifyURL: "https://open.spotify.com/track/2"},
+		},
+	}
+
+	if err := srv.ProcessRequest(context.Background(), request); err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	if len(downloader.bulk) != 0 {
+		t.Errorf("expected no bulk download for playlists, got %v", downloader.bulk)
+	}
+	if len(downloader.tracks) != 1 || downloader.tracks[0] != "https://open.spotify.com/track/2" {
+		t.Errorf("expected only the missing track to be downloaded, got %v", downloader.tracks)
+	}
+}
+
+func TestProcessRequest_PlaylistMatchesRetaggedTracks(t *testing.T) {
+	database := &fakeDatabase{library: []models.MusicFile{
+		{Artist: "Beyonce", Title: "Deja Vu"},
+		{Artist: "Queen", Title: "Don't Stop Me Now"},
+	}}
+	downloader := &fakeDownloader{}
+	srv := newTestService(database, downloader)
+
+	request := models.DownloadQueueRequest{
+		ID:                 "req",
+		SpotifyURL:         "https://open.spotify.com/playlist/1",
+		ObjectType:         spotify.SpotifyObjectTypePlaylist,
+		ExpectedTrackCount: 2,
+		TrackMetadata: []spotify.TrackMetadata{
+			{Artist: "Beyoncé, Jay-Z", Title: "Déjà Vu (feat. Jay-Z)", SpotifyURL: "https://open.spotify.com/track/1"},
+			{Artist: "Queen", Title: "Don't Stop Me Now - Remastered 2011", SpotifyURL: "https://open.spotify.com/track/2"},
+		},
+	}
+
+	if err := srv.ProcessRequest(context.Background(), request); err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	if len(downloader.tracks) != 0 {
+		t.Errorf("expected retagged tracks to be found in the library, downloaded %v", downloader.tracks)
+	}
+}
+
+func TestProcessRequest_PlaylistPrefersSpotifyID(t *testing.T) {
+	database := &fakeDatabase{tagged: []db.LibraryFile{{
+		MusicFile: models.MusicFile{Artist: "宇多田ヒカル", Title: "初恋"},
+		SpotifyID: "4uLU6hMCjMI75M1A2tKUQC",
+	}}}
+	downloader := &fakeDownloader{}
+	srv := newTestService(database, downloader)
+
+	request := models.DownloadQueueRequest{
+		ID:                 "req",
+		SpotifyURL:         "https://open.spotify.com/playlist/1",
+		ObjectType:         spotify.SpotifyObjectTypePlaylist,
+		ExpectedTrackCount: 2,
+		TrackMetadata: []spotify.TrackMetadata{
+			{Artist: "Hikaru Utada", Title: "First Love", SpotifyURL: "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC"},
+			{Artist: "Hikaru Utada", Title: "Automatic", SpotifyURL: "https://open.spotify.com/track/2"},
+		},
+	}
+
+	if err := srv.ProcessRequest(context.Background(), request); err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	if len(downloader.tracks) != 1 || downloader.tracks[0] != "https://open.spotify.com/track/2" {
+		t.Errorf("expected the re-titled track to be found by its Spotify id, downloaded %v", downloader.tracks)
+	}
+}
+
+func TestProcessRequest_DownloaderError(t *testing.T) {
+	downloadErr := errors.New("spotdl exploded")
+	srv := newTestService(&fakeDatabase{}, &fakeDownloader{err: downloadErr})
+
+	request := models.DownloadQueueRequest{
+		SpotifyURL:         "https://open.spotify.com/track/1",
+		ObjectType:         spotify.SpotifyObjectTypeTrack,
+		ExpectedTrackCount: 1,
+		TrackMetadata:      []spotify.TrackMetadata{{Artist: "Artist1", Title: "Song1"}},
+	}
+
+	if err := srv.ProcessRequest(context.Background(), request); !errors.Is(err, downloadErr) {
+		t.Errorf("expected the downloader error, got %v", err)
+	}
+}
+
+func TestUpdateFoundTrackCount_CountsByFailureKind(t *testing.T) {
+	database := &fakeDatabase{results: []db.TrackResult{
+		{SpotifyURL: "https://open.spotify.com/track/1", Status: string(spotdl.EventRateLimited)},
+		{SpotifyURL: "https://open.spotify.com/track/2", Status: string(spotdl.EventNoMatch)},
+		{SpotifyURL: "https://open.spotify.com/track/3", Status: string(spotdl.EventProviderError)},
+	}}
+	srv := newTestService(database, &fakeDownloader{})
+
+	request := models.DownloadQueueRequest{
+		ID:                 "req",
+		ExpectedTrackCount: 3,
+		TrackMetadata: []spotify.TrackMetadata{
+			{Artist: "Artist1", Title: "Song1", SpotifyURL: "https://open.spotify.com/track/1", FailedAttempts: 1},
+			{Artist: "Artist2", Title: "Song2", SpotifyURL: "https://open.spotify.com/track/2", FailedAttempts: 1},
+			{Artist: "Artist3", Title: "Song3", SpotifyURL: "https://open.spotify.com/track/3", FailedAttempts: 1},
+		},
+	}
+
+	if err := srv.UpdateFoundTrackCount(context.Background(), request); err != nil {
+		t.Fatalf("UpdateFoundTrackCount failed: %v", err)
+	}
+	if len(database.updates) == 0 {
+		t.Fatal("expected the track status to be persisted")
+	}
+
+	tracks := database.updates[len(database.updates)-1].TrackMetadata
+	if tracks[0].FailedAttempts != 1 || tracks[0].Skipped {
+		t.Errorf("a rate limit should not count against the track: %+v", tracks[0])
+	}
+	if tracks[1].FailedAttempts != 2 || !tracks[1].Skipped {
+		t.Errorf("a missing match should be skipped after %d attempts: %+v", maxNoMatchAttempts, tracks[1])
+	}
+	if tracks[2].FailedAttempts != 2 || tracks[2].Skipped {
+		t.Errorf("a provider error should be retried until %d attempts: %+v", spotify.MaxFailedAttempts, tracks[2])
+	}
+}
+
+func TestProcessDownloadRequest_EmptyQueueResetsGauges(t *testing.T) {
+	metrics.ActiveRequests.Set(3)
+	metrics.ErroredRequests.Set(1)
+
+	srv := newTestService(db.NewMemoryDatabase(), &fakeDownloader{})
+	if err := srv.ProcessDownloadRequest(context.Background()); err != nil {
+		t.Fatalf("ProcessDownloadRequest failed: %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.ActiveRequests); got != 0 {
+		t.Errorf("active requests gauge = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(metrics.ErroredRequests); got != 0 {
+		t.Errorf("errored requests gauge = %v, want 0", got)
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/indexer"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/spotdl"
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+const (
+	DownloaderSpotdl = "spotdl"
+	DownloaderYtdlp  = "yt-dlp"
+)
+
+// Downloader fetches the audio of Spotify objects into the destination directory
+type Downloader interface {
+	// Name identifies the backend, it is also the name of the binary it runs
+	Name() string
+	// DownloadTrack downloads a single track
+	DownloadTrack(ctx context.Context, track spotify.TrackMetadata) (DownloadResult, error)
+	// DownloadBulk syncs all tracks of a request without deleting anything already downloaded
+	DownloadBulk(ctx context.Context, request models.DownloadQueueRequest) (DownloadResult, error)
+}
+
+// DownloadResult reports what a download produced
+type DownloadResult struct {
+	// Files are the audio files created or rewritten in the destination.
+	// With several workers this can include files written by a concurrent download.
+	Files []string
+	// Events are the per track results the downloader reported
+	Events []spotdl.Event
+	// OutputTail are the last lines the downloader printed
+	OutputTail []string
+}
+
+// NewDownloader returns the downloader backend with the given name
+func NewDownloader(name string, log *zap.Logger, destination string, trackTimeout, bulkTimeout time.Duration) (Downloader, error) {
+	switch name {
+	case DownloaderSpotdl:
+		return newSpotdlDownloader(log, destination, trackTimeout, bulkTimeout), nil
+	case DownloaderYtdlp:
+		return newYtdlpDownloader(log, destination, trackTimeout, bulkTimeout), nil
+	default:
+		return nil, fmt.Errorf("unknown downloader %q", name)
+	}
+}
+
+// dirSnapshot maps the audio files directly inside a directory to their modification time
+type dirSnapshot map[string]time.Time
+
+// snapshotDir lists the audio files of dir, both backends write flat into the destination
+func snapshotDir(dir string) dirSnapshot {
+	snapshot := make(dirSnapshot)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return snapshot
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !indexer.IsAudioFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshot[filepath.Join(dir, entry.Name())] = info.ModTime()
+	}
+	return snapshot
+}
+
+// changedFiles returns the files that are new or modified compared to before
+func (before dirSnapshot) changedFiles(after dirSnapshot) []string {
+	files := make([]string, 0)
+	for path, modTime := range after {
+		if previous, ok := before[path]; !ok || modTime.After(previous) {
+			files = append(files, path)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
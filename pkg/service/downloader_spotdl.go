@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/spotdl"
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+// spotdlDownloader downloads through spotdl, matching Spotify metadata to YouTube Music itself
+type spotdlDownloader struct {
+	log         *zap.Logger
+	destination string
+
+	trackTimeout time.Duration
+	bulkTimeout  time.Duration
+}
+
+func newSpotdlDownloader(log *zap.Logger, destination string, trackTimeout, bulkTimeout time.Duration) *spotdlDownloader {
+	return &spotdlDownloader{
+		log:          log,
+		destination:  destination,
+		trackTimeout: trackTimeout,
+		bulkTimeout:  bulkTimeout,
+	}
+}
+
+func (d *spotdlDownloader) Name() string {
+	return DownloaderSpotdl
+}
+
+// DownloadTrack downloads a single track using spotdl
+func (d *spotdlDownloader) DownloadTrack(ctx context.Context, track spotify.TrackMetadata) (DownloadResult, error) {
+	args := []string{
+		track.SpotifyURL,
+		"--output", d.destination,
+		"--config",
+		"--no-cache",
+	}
+
+	d.log.Info("executing spotdl for single track", zap.String("url", track.SpotifyURL))
+
+	return d.run(ctx, d.trackTimeout, args)
+}
+
+// DownloadBulk runs "spotdl --sync-without-deleting {url}" for the whole request
+func (d *spotdlDownloader) DownloadBulk(ctx context.Context, request models.DownloadQueueRequest) (DownloadResult, error) {
+	// Build output format with destination path
+	args := []string{
+		request.SpotifyURL,
+		"--output", d.destination,
+		"--config",
+		"--no-cache",
+		"--sync-without-deleting",
+	}
+
+	return d.run(ctx, d.bulkTimeout, args)
+}
+
+func (d *spotdlDownloader) run(ctx context.Context, timeout time.Duration, args []string) (DownloadResult, error) {
+	// stdout and stderr are parsed concurrently
+	var mu sync.Mutex
+	var tail outputTail
+	events := make([]spotdl.Event, 0)
+	onLine := func(line string) {
+		tail.add(line)
+		if event, ok := spotdl.ParseLine(line); ok {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}
+	}
+
+	before := snapshotDir(d.destination)
+	err := runCommand(ctx, d.log, timeout, onLine, DownloaderSpotdl, args...)
+	return DownloadResult{Files: before.changedFiles(snapshotDir(d.destination)), Events: events, OutputTail: tail.get()}, err
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/spotdl"
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+// ytdlpAudioFormat is the native YouTube audio codec, so no lossy re-encode happens
+const ytdlpAudioFormat = "m4a"
+
+// ytdlpDownloader searches YouTube with yt-dlp directly, it only needs the
+// track metadata and keeps working when spotdl breaks upstream
+type ytdlpDownloader struct {
+	log         *zap.Logger
+	destination string
+
+	trackTimeout time.Duration
+	bulkTimeout  time.Duration
+}
+
+func newYtdlpDownloader(log *zap.Logger, destination string, trackTimeout, bulkTimeout time.Duration) *ytdlpDownloader {
+	return &ytdlpDownloader{
+		log:          log,
+		destination:  destination,
+		trackTimeout: trackTimeout,
+		bulkTimeout:  bulkTimeout,
+	}
+}
+
+func (d *ytdlpDownloader) Name() string {
+	return DownloaderYtdlp
+}
+
+// DownloadTrack downloads the best search match for the track and tags it with the Spotify metadata
+func (d *ytdlpDownloader) DownloadTrack(ctx context.Context, track spotify.TrackMetadata) (DownloadResult, error) {
+	if track.Artist == "" || track.Title == "" {
+		return DownloadResult{}, fmt.Errorf("%s needs artist and title to search for %s", DownloaderYtdlp, track.SpotifyURL)
+	}
+
+	name := trackFileName(track)
+	args := []string{
+		"ytsearch1:" + track.Artist + " - " + track.Title,
+		"--no-playlist",
+		"--extract-audio",
+		"--audio-format", ytdlpAudioFormat,
+		"--embed-metadata",
+		"--embed-thumbnail",
+		// Tag with the Spotify names instead of the YouTube video title so library lookups match
+		"--postprocessor-args", "Metadata:-metadata artist=" + shellQuote(track.Artist) + " -metadata title=" + shellQuote(track.Title),
+		"--output", filepath.Join(d.destination, strings.ReplaceAll(name, "%", "%%")+".%(ext)s"),
+	}
+
+	d.log.Info("executing yt-dlp for single track", zap.String("url", track.SpotifyURL), zap.String("name", name))
+
+	var tail outputTail
+	before := snapshotDir(d.destination)
+	err := runCommand(ctx, d.log, d.trackTimeout, tail.add, DownloaderYtdlp, args...)
+
+	// yt-dlp output is not parsed, report the result of the run instead
+	event := spotdl.Event{Kind: spotdl.EventDownloaded, Track: name, SpotifyURL: track.SpotifyURL}
+	if err != nil {
+		event.Kind = spotdl.EventProviderError
+		event.Message = err.Error()
+	}
+
+	return DownloadResult{Files: before.changedFiles(snapshotDir(d.destination)), Events: []spotdl.Event{event}, OutputTail: tail.get()}, err
+}
+
+// DownloadBulk downloads every track of the request that is not in the destination yet.
+// It fails only if every attempted track failed, like spotdl does for partial failures.
+func (d *ytdlpDownloader) DownloadBulk(ctx context.Context, request models.DownloadQueueRequest) (DownloadResult, error) {
+	if len(request.TrackMetadata) == 0 {
+		return DownloadResult{}, fmt.Errorf("%s needs track metadata to download %s", DownloaderYtdlp, request.SpotifyURL)
+	}
+
+	bulkCtx := ctx
+	if d.bulkTimeout > 0 {
+		var cancel context.CancelFunc
+		bulkCtx, cancel = context.WithTimeout(ctx, d.bulkTimeout)
+		defer cancel()
+	}
+
+	existing := snapshotDir(d.destination)
+	result := DownloadResult{Files: make([]string, 0), Events: make([]spotdl.Event, 0)}
+	var errs []error
+	attempted := 0
+	for _, track := range request.TrackMetadata {
+		if bulkCtx.Err() != nil {
+			break
+		}
+		if track.Found || track.Skipped || existing.hasTrack(track) {
+			continue
+		}
+
+		attempted++
+		trackResult, err := d.DownloadTrack(bulkCtx, track)
+		result.Files = append(result.Files, trackResult.Files...)
+		result.Events = append(result.Events, trackResult.Events...)
+		if err != nil {
+			d.log.Warn("failed to download track", zap.Error(err), zap.String("url", track.SpotifyURL))
+			errs = append(errs, err)
+			// the output of the last failure is the most useful when the whole bulk fails
+			result.OutputTail = trackResult.OutputTail
+		}
+	}
+
+	if ctx.Err() == nil && errors.Is(bulkCtx.Err(), context.DeadlineExceeded) {
+		return result, fmt.Errorf("%w: %s bulk download did not finish within %s", ErrDownloadTimedOut, DownloaderYtdlp, d.bulkTimeout)
+	}
+
+	if attempted > 0 && len(errs) == attempted {
+		return result, errors.Join(errs...)
+	}
+
+	return result, nil
+}
+
+// hasTrack reports whether a file named after the track is in the snapshot
+func (s dirSnapshot) hasTrack(track spotify.TrackMetadata) bool {
+	name := trackFileName(track)
+	for path := range s {
+		base := filepath.Base(path)
+		if strings.TrimSuffix(base, filepath.Ext(base)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// trackFileName is the "Artist - Title" file name without extension, matching spotdl's default
+func trackFileName(track spotify.TrackMetadata) string {
+	return strings.ReplaceAll(track.Artist+" - "+track.Title, "/", "-")
+}
+
+// shellQuote quotes s for the shlex splitting yt-dlp applies to postprocessor args
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
+	models "github.com/supperdoggy/spot-models"
+	"go.uber.org/zap"
+)
+
+// EventType names a request lifecycle event
+type EventType string
+
+const (
+	// EventRequestCreated is published when a download request is picked up for the first time
+	EventRequestCreated EventType = "request.created"
+	// EventTrackDownloaded is published for every track the downloader reports as downloaded
+	EventTrackDownloaded EventType = "track.downloaded"
+	// EventTrackSkipped is published when a track is given up after spotify.MaxFailedAttempts
+	EventTrackSkipped EventType = "track.skipped"
+	// EventRequestCompleted is published when all tracks of a request are found or skipped
+	EventRequestCompleted EventType = "request.completed"
+	// EventRequestDeactivated is published when a request is given up after its sync or retry limit
+	EventRequestDeactivated EventType = "request.deactivated"
+	// EventPlaylistGenerated is published after the files of a playlist request were written
+	EventPlaylistGenerated EventType = "playlist.generated"
+)
+
+// Event is the JSON document delivered to the event sinks
+type Event struct {
+	ID   string    `json:"id"`
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	RequestID  string `json:"request_id,omitempty"`
+	SpotifyURL string `json:"spotify_url,omitempty"`
+	Name       string `json:"name,omitempty"`
+	CreatorID  int64  `json:"creator_id,omitempty"`
+
+	// FoundTracks, ExpectedTracks and Skipped summarize the tracks of completed and deactivated requests
+	FoundTracks    int          `json:"found_tracks,omitempty"`
+	ExpectedTracks int          `json:"expected_tracks,omitempty"`
+	Skipped        []EventTrack `json:"skipped,omitempty"`
+
+	Track  *EventTrack `json:"track,omitempty"`
+	Files  []string    `json:"files,omitempty"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// EventTrack is the track an event is about
+type EventTrack struct {
+	Artist         string `json:"artist,omitempty"`
+	Title          string `json:"title,omitempty"`
+	SpotifyURL     string `json:"spotify_url,omitempty"`
+	FailedAttempts int    `json:"failed_attempts,omitempty"`
+}
+
+// EventPublisher accepts events, it must not block
+type EventPublisher interface {
+	Publish(event Event)
+}
+
+// EventSink delivers events to one destination
+type EventSink interface {
+	Name() string
+	Deliver(ctx context.Context, event Event) error
+}
+
+// EventBus fans events out to its sinks. Every sink has its own queue so a slow webhook
+// doesn't hold back the others, events are dropped when a queue is full.
+type EventBus struct {
+	log   *zap.Logger
+	sinks []*queuedSink
+}
+
+type queuedSink struct {
+	sink  EventSink
+	queue chan Event
+}
+
+func NewEventBus(log *zap.Logger, bufferSize int, sinks ...EventSink) *EventBus {
+	b := &EventBus{log: log}
+	for _, sink := range sinks {
+		b.sinks = append(b.sinks, &queuedSink{sink: sink, queue: make(chan Event, max(bufferSize, 1))})
+	}
+	return b
+}
+
+// Publish queues event for every sink, the id and time are filled in if missing
+func (b *EventBus) Publish(event Event) {
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	for _, s := range b.sinks {
+		select {
+		case s.queue <- event:
+		default:
+			b.log.Warn("event queue full, dropping event",
+				zap.String("sink", s.sink.Name()), zap.String("event", string(event.Type)), zap.String("event_id", event.ID))
+			metrics.EventDeliveries.WithLabelValues(s.sink.Name(), "dropped").Inc()
+		}
+	}
+}
+
+// Run delivers queued events until ctx is cancelled, events still queued then are dropped
+func (b *EventBus) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, s := range b.sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.deliver(ctx, s)
+		}()
+	}
+	wg.Wait()
+}
+
+func (b *EventBus) deliver(ctx context.Context, s *queuedSink) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.queue:
+			if err := s.sink.Deliver(ctx, event); err != nil {
+				b.log.Error("failed to deliver event", zap.Error(err),
+					zap.String("sink", s.sink.Name()), zap.String("event", string(event.Type)), zap.String("event_id", event.ID))
+				metrics.EventDeliveries.WithLabelValues(s.sink.Name(), "failure").Inc()
+				continue
+			}
+			metrics.EventDeliveries.WithLabelValues(s.sink.Name(), "success").Inc()
+		}
+	}
+}
+
+// publish hands event to the event bus, it is a no-op without one
+func (s *service) publish(event Event) {
+	if s.events == nil {
+		return
+	}
+	s.events.Publish(event)
+}
+
+// requestEvent returns an event of eventType about request
+func requestEvent(eventType EventType, request models.DownloadQueueRequest) Event {
+	return Event{
+		Type:       eventType,
+		RequestID:  request.ID,
+		SpotifyURL: request.SpotifyURL,
+		Name:       request.Name,
+		CreatorID:  request.CreatorID,
+	}
+}
+
+// requestSummaryEvent is requestEvent with the track summary of request
+func requestSummaryEvent(eventType EventType, request models.DownloadQueueRequest) Event {
+	event := requestEvent(eventType, request)
+	event.ExpectedTracks = request.ExpectedTrackCount
+	if event.ExpectedTracks == 0 {
+		event.ExpectedTracks = len(request.TrackMetadata)
+	}
+	found := 0
+	for _, track := range request.TrackMetadata {
+		if track.Found {
+			found++
+		}
+		if track.Skipped {
+			event.Skipped = append(event.Skipped, EventTrack{
+				Artist:         track.Artist,
+				Title:          track.Title,
+				SpotifyURL:     track.SpotifyURL,
+				FailedAttempts: track.FailedAttempts,
+			})
+		}
+	}
+	event.FoundTracks = max(request.FoundTrackCount, found)
+	return event
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxHookOutput bounds how much hook output ends up in the error
+const maxHookOutput = 1024
+
+// ExecSink runs a local executable per event with the event JSON on stdin,
+// the event type is also set in the SPOTDL_EVENT environment variable
+type ExecSink struct {
+	path    string
+	timeout time.Duration
+}
+
+func NewExecSink(path string, timeout time.Duration) *ExecSink {
+	return &ExecSink{
+		path:    path,
+		timeout: timeout,
+	}
+}
+
+func (e *ExecSink) Name() string {
+	return "exec"
+}
+
+// Deliver runs the hook and fails if it exits non-zero or runs longer than the timeout
+func (e *ExecSink) Deliver(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.path)
+	// Own process group so children of the hook are killed with it and can't hold the output pipes open
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = commandWaitDelay
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.Env = append(os.Environ(), "SPOTDL_EVENT="+string(event.Type))
+
+	if err := cmd.Run(); err != nil {
+		out := strings.TrimSpace(output.String())
+		if len(out) > maxHookOutput {
+			out = out[len(out)-maxHookOutput:]
+		}
+		return fmt.Errorf("hook %s failed: %w: %s", e.path, err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestWebhookSink_SignsAndRetries(t *testing.T) {
+	defaultDelay := webhookRetryDelay
+	webhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { webhookRetryDelay = defaultDelay })
+
+	var attempts atomic.Int32
+	received := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get("X-Signature-256"), "sha256="+signPayload("secret", body); got != want {
+			t.Errorf("unexpected signature %q, want %q", got, want)
+		}
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("invalid event body: %v", err)
+		}
+		received <- event
+	}))
+	defer srv.Close()
+
+	sink := NewWebhookSink(srv.URL, "secret", 5, time.Second)
+	event := Event{ID: "1", Type: EventRequestCompleted, RequestID: "req", Time: time.Now().UTC()}
+	if err := sink.Deliver(context.Background(), event); err != nil {
+		t.Fatalf("Deliver failed: %v", err)
+	}
+
+	got := <-received
+	if got.ID != "1" || got.Type != EventRequestCompleted || got.RequestID != "req" {
+		t.Errorf("unexpected event %+v", got)
+	}
+	if attempts.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts.Load())
+	}
+}
+
+func TestWebhookSink_ClientErrorIsNotRetried(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	if err := NewWebhookSink(srv.URL, "", 5, time.Second).Deliver(context.Background(), Event{Type: EventRequestCreated}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if attempts.Load() != 1 {
+		t.Errorf("expected a single attempt, got %d", attempts.Load())
+	}
+}
+
+func TestEventBus_ExecSink(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "event")
+	hook := filepath.Join(dir, "hook.sh")
+	script := "#!/bin/sh\ncat > " + out + ".json\necho \"$SPOTDL_EVENT\" > " + out + ".type\nmv " + out + ".json " + out + ".done\n"
+	if err := os.WriteFile(hook, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write hook: %v", err)
+	}
+
+	bus := NewEventBus(zap.NewNop(), 10, NewExecSink(hook, 5*time.Second))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bus.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	bus.Publish(Event{Type: EventTrackSkipped, Track: &EventTrack{Artist: "Björk", Title: "Jóga", FailedAttempts: 3}})
+
+	var content []byte
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var err error
+		if content, err = os.ReadFile(out + ".done"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hook did not run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var event Event
+	if err := json.Unmarshal(content, &event); err != nil {
+		t.Fatalf("hook got invalid JSON: %v", err)
+	}
+	if event.ID == "" || event.Time.IsZero() || event.Track == nil || event.Track.Title != "Jóga" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	eventType, err := os.ReadFile(out + ".type")
+	if err != nil || strings.TrimSpace(string(eventType)) != string(EventTrackSkipped) {
+		t.Errorf("unexpected SPOTDL_EVENT %q, err %v", eventType, err)
+	}
+}
+
+func TestExecSink_Failure(t *testing.T) {
+	hook := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\necho broken >&2\nexit 3\n"), 0755); err != nil {
+		t.Fatalf("failed to write hook: %v", err)
+	}
+
+	err := NewExecSink(hook, 5*time.Second).Deliver(context.Background(), Event{Type: EventRequestCreated})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected the hook output in the error, got %v", err)
+	}
+}
+
+func TestExecSink_TimeoutKillsChildren(t *testing.T) {
+	hook := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\nsleep 30 &\nsleep 30\n"), 0755); err != nil {
+		t.Fatalf("failed to write hook: %v", err)
+	}
+
+	started := time.Now()
+	err := NewExecSink(hook, 100*time.Millisecond).Deliver(context.Background(), Event{Type: EventRequestCreated})
+	if err == nil {
+		t.Fatal("expected the timed out hook to fail")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("hook with a forked child took %s to stop", elapsed)
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// webhookRetryDelay is the delay before the first webhook retry, it doubles with every attempt
+var webhookRetryDelay = time.Second
+
+// WebhookSink posts events as JSON. With a secret the body is signed with HMAC-SHA256
+// in the X-Signature-256 header as "sha256=<hex>", receivers should compare it in constant time.
+type WebhookSink struct {
+	url      string
+	secret   string
+	attempts int
+	http     *http.Client
+}
+
+func NewWebhookSink(url, secret string, attempts int, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{
+		url:      url,
+		secret:   secret,
+		attempts: max(attempts, 1),
+		http:     &http.Client{Timeout: timeout},
+	}
+}
+
+func (w *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Deliver posts event, network errors, 429 and 5xx responses are retried with exponential backoff
+func (w *WebhookSink) Deliver(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.attempts {
+			return fmt.Errorf("webhook %s failed after %d attempts: %w", w.url, attempt, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// post sends body once and reports whether a failure is worth retrying
+func (w *WebhookSink) post(ctx context.Context, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", string(event.Type))
+	req.Header.Set("X-Event-ID", event.ID)
+	if w.secret != "" {
+		req.Header.Set("X-Signature-256", "sha256="+signPayload(w.secret, body))
+	}
+
+	res, err := w.http.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status %d", res.StatusCode)
+}
+
+// signPayload returns the hex HMAC-SHA256 of body
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
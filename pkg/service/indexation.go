@@ -2,20 +2,59 @@ package service
 
 import (
 	"context"
-	"os"
-	"os/exec"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/indexer"
+	"go.uber.org/zap"
 )
 
+// LibraryIndexer keeps the music files collection in sync with the library
+type LibraryIndexer interface {
+	Run(ctx context.Context) (indexer.Stats, error)
+	IndexFiles(ctx context.Context, paths []string) error
+}
+
+// LibraryRescanner asks media servers to pick up new files, it must not block
+type LibraryRescanner interface {
+	Trigger()
+}
+
+// IndexDownloadedFiles reindexes the whole library, it is a no-op without an indexer
 func (s *service) IndexDownloadedFiles(ctx context.Context) error {
-	// Run the "spotdl --sync {url}" command
-	cmd := exec.Command("sh /home/maks/run_music_indexer.sh")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	if s.indexer == nil {
+		return nil
+	}
 
-	// Execute the command
-	if err := cmd.Run(); err != nil {
+	if _, err := s.indexer.Run(ctx); err != nil {
+		s.log.Error("failed to index music library", zap.Error(err))
 		return err
 	}
-	s.log.Info("indexing completed")
 	return nil
 }
+
+// filesDownloaded indexes freshly downloaded files and counts them for the rescan at the end of the batch
+func (s *service) filesDownloaded(ctx context.Context, paths []string) {
+	s.downloadedFiles.Add(int64(len(paths)))
+	s.indexFiles(ctx, paths)
+}
+
+// rescanLibrary asks the media servers to rescan if the batch downloaded any files
+func (s *service) rescanLibrary() {
+	downloaded := s.downloadedFiles.Swap(0)
+	if s.rescanner == nil || downloaded == 0 {
+		return
+	}
+
+	s.log.Info("requesting media server rescan", zap.Int64("downloaded_files", downloaded))
+	s.rescanner.Trigger()
+}
+
+// indexFiles indexes freshly downloaded files so found checks can see them right away
+func (s *service) indexFiles(ctx context.Context, paths []string) {
+	if s.indexer == nil || len(paths) == 0 {
+		return
+	}
+
+	if err := s.indexer.IndexFiles(ctx, paths); err != nil {
+		s.log.Error("failed to index downloaded files", zap.Error(err), zap.Strings("files", paths))
+	}
+}
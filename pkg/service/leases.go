@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
+	models "github.com/supperdoggy/spot-models"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultLeaseDuration is used when Options.LeaseDuration is not set
+	defaultLeaseDuration = 5 * time.Minute
+	// leaseReleaseTimeout bounds releasing a lease after its context was cancelled
+	leaseReleaseTimeout = 10 * time.Second
+)
+
+// defaultInstanceID identifies this process when Options.InstanceID is not set
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "spotdl-wrapper"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// releaseExpiredLeases frees the requests of instances that stopped renewing their leases
+func (s *service) releaseExpiredLeases(ctx context.Context) {
+	released, err := s.database.ReleaseExpiredLeases(ctx)
+	if err != nil {
+		s.log.Error("failed to release expired leases", zap.Error(err))
+		return
+	}
+
+	if released > 0 {
+		s.log.Warn("released expired request leases", zap.Int64("released", released))
+		metrics.RequestLeases.WithLabelValues("expired").Add(float64(released))
+	}
+}
+
+// holdLease renews the lease on request every third of the lease duration until release is called.
+// The returned context is cancelled with db.ErrLeaseLost once another instance took the request over.
+func (s *service) holdLease(ctx context.Context, request models.DownloadQueueRequest) (context.Context, func()) {
+	metrics.RequestLeases.WithLabelValues("claimed").Inc()
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(s.leaseDuration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := s.database.RenewLease(leaseCtx, request.ID, s.instanceID, s.leaseDuration)
+			if errors.Is(err, db.ErrLeaseLost) {
+				s.log.Warn("lost the lease on request, stopping it", zap.String("request_id", request.ID))
+				metrics.RequestLeases.WithLabelValues("lost").Inc()
+				cancel(db.ErrLeaseLost)
+				return
+			}
+			if err != nil && leaseCtx.Err() == nil {
+				// the lease is still valid until it expires, try again on the next tick
+				s.log.Error("failed to renew request lease", zap.Error(err), zap.String("request_id", request.ID))
+			}
+		}
+	}()
+
+	release := func() {
+		lost := errors.Is(context.Cause(leaseCtx), db.ErrLeaseLost)
+		cancel(nil)
+		wg.Wait()
+		if lost {
+			return
+		}
+
+		// released on shutdown too, so another instance can pick the request up right away
+		releaseCtx, cancelRelease := context.WithTimeout(context.WithoutCancel(ctx), leaseReleaseTimeout)
+		defer cancelRelease()
+		if err := s.database.ReleaseRequest(releaseCtx, request.ID, s.instanceID); err != nil {
+			s.log.Warn("failed to release request lease", zap.Error(err), zap.String("request_id", request.ID))
+		}
+	}
+
+	return leaseCtx, release
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+func TestHoldLease(t *testing.T) {
+	ctx := context.Background()
+	database := db.NewMemoryDatabase()
+	srv := NewService(database, zap.NewNop(), nil, &fakeDownloader{}, Options{
+		InstanceID:    "a",
+		LeaseDuration: 60 * time.Millisecond,
+	}).(*service)
+
+	if err := database.NewDownloadRequest(ctx, "https://open.spotify.com/album/1", "album", 1, spotify.SpotifyObjectTypeAlbum); err != nil {
+		t.Fatalf("NewDownloadRequest failed: %v", err)
+	}
+	request, err := database.ClaimNextRequest(ctx, "a", srv.leaseDuration, time.Now())
+	if err != nil {
+		t.Fatalf("ClaimNextRequest failed: %v", err)
+	}
+
+	leaseCtx, release := srv.holdLease(ctx, request)
+
+	// the heartbeat keeps the lease alive well past its duration
+	time.Sleep(200 * time.Millisecond)
+	if _, err := database.ClaimNextRequest(ctx, "b", time.Minute, time.Now().Add(time.Hour)); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected the request to stay leased, got %v", err)
+	}
+	if leaseCtx.Err() != nil {
+		t.Fatalf("lease context cancelled early: %v", context.Cause(leaseCtx))
+	}
+
+	// another instance takes over, e.g. after a network partition outlasted the lease
+	if err := database.ReleaseRequest(ctx, request.ID, "a"); err != nil {
+		t.Fatalf("ReleaseRequest failed: %v", err)
+	}
+	if _, err := database.ClaimNextRequest(ctx, "b", time.Minute, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("takeover claim failed: %v", err)
+	}
+
+	select {
+	case <-leaseCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("lease context not cancelled after the lease was lost")
+	}
+	if cause := context.Cause(leaseCtx); !errors.Is(cause, db.ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost as the cause, got %v", cause)
+	}
+
+	// releasing leaves the lease of the new owner alone
+	release()
+	state, err := database.GetRequestState(ctx, request.ID)
+	if err != nil || state.LeaseOwner != "b" {
+		t.Errorf("expected the lease to stay with b, got %+v, %v", state, err)
+	}
+}
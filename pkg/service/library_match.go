@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/match"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+// libraryQuery is a track looked up in the library, the ids are optional
+type libraryQuery struct {
+	match.Track
+	SpotifyID string
+	ISRC      string
+}
+
+// findInLibrary returns the library file of every track, nil where none matched.
+// Files tagged with the track's Spotify id or ISRC win, the rest is matched by artist and title.
+func (s *service) findInLibrary(ctx context.Context, queries []libraryQuery) ([]*db.LibraryFile, error) {
+	found := make([]*db.LibraryFile, len(queries))
+	if len(queries) == 0 {
+		return found, nil
+	}
+
+	spotifyIDs := make([]string, 0, len(queries))
+	isrcs := make([]string, 0, len(queries))
+	for _, query := range queries {
+		if query.SpotifyID != "" {
+			spotifyIDs = append(spotifyIDs, query.SpotifyID)
+		}
+		if query.ISRC != "" {
+			isrcs = append(isrcs, query.ISRC)
+		}
+	}
+
+	bySpotifyID, err := s.database.FindMusicFilesBySpotifyID(ctx, spotifyIDs)
+	if err != nil {
+		return nil, err
+	}
+	byISRC, err := s.database.FindMusicFilesByISRC(ctx, isrcs)
+	if err != nil {
+		return nil, err
+	}
+
+	spotifyIDFiles := make(map[string]*db.LibraryFile, len(bySpotifyID))
+	for i := range bySpotifyID {
+		spotifyIDFiles[bySpotifyID[i].SpotifyID] = &bySpotifyID[i]
+	}
+	isrcFiles := make(map[string]*db.LibraryFile, len(byISRC))
+	for i := range byISRC {
+		isrcFiles[byISRC[i].ISRC] = &byISRC[i]
+	}
+
+	// tracks without an id match fall back to fuzzy text matching
+	pending := make([]int, 0, len(queries))
+	titles := make([]string, 0, len(queries))
+	for i, query := range queries {
+		if file, ok := spotifyIDFiles[query.SpotifyID]; ok && query.SpotifyID != "" {
+			found[i] = file
+			continue
+		}
+		if file, ok := isrcFiles[query.ISRC]; ok && query.ISRC != "" {
+			found[i] = file
+			continue
+		}
+
+		pending = append(pending, i)
+		titles = append(titles, query.Title)
+	}
+
+	if len(pending) == 0 {
+		return found, nil
+	}
+
+	files, err := s.database.FindMatchCandidates(ctx, titles)
+	if err != nil {
+		return nil, err
+	}
+
+	// candidates are prepared once and grouped by match key, a track is only scored
+	// against the files sharing its key
+	buckets := make(map[string][]int, len(files))
+	prepared := make(map[string][]match.Prepared, len(files))
+	for i, file := range files {
+		candidate := s.matcher.Prepare(match.Track{
+			Artist:   file.Artist,
+			Title:    file.Title,
+			Duration: time.Duration(file.DurationMs) * time.Millisecond,
+		})
+		buckets[candidate.Key()] = append(buckets[candidate.Key()], i)
+		prepared[candidate.Key()] = append(prepared[candidate.Key()], candidate)
+	}
+
+	for _, i := range pending {
+		want := s.matcher.Prepare(queries[i].Track)
+		index, score := s.matcher.BestPrepared(want, prepared[want.Key()])
+		if index < 0 {
+			continue
+		}
+
+		best := buckets[want.Key()][index]
+		found[i] = &files[best]
+		s.log.Debug("matched track in library",
+			zap.String("artist", queries[i].Artist),
+			zap.String("title", queries[i].Title),
+			zap.String("path", files[best].Path),
+			zap.Float64("score", score))
+	}
+
+	return found, nil
+}
+
+func matchTrack(track spotify.TrackMetadata) libraryQuery {
+	return libraryQuery{
+		Track: match.Track{
+			Artist: track.Artist,
+			Title:  track.Title,
+		},
+		SpotifyID: match.SpotifyTrackID(track.SpotifyURL),
+	}
+}
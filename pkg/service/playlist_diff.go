@@ -0,0 +1,43 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+)
+
+// diffPlaylist returns the tracks of next missing in prev and the tracks of prev missing in next
+func diffPlaylist(prev, next []db.PlaylistTrack) db.PlaylistDiff {
+	prevKeys := make(map[string]bool, len(prev))
+	for _, track := range prev {
+		prevKeys[playlistTrackKey(track)] = true
+	}
+	nextKeys := make(map[string]bool, len(next))
+	for _, track := range next {
+		nextKeys[playlistTrackKey(track)] = true
+	}
+
+	diff := db.PlaylistDiff{
+		Added:   make([]db.PlaylistTrack, 0),
+		Removed: make([]db.PlaylistTrack, 0),
+	}
+	for _, track := range next {
+		if !prevKeys[playlistTrackKey(track)] {
+			diff.Added = append(diff.Added, track)
+		}
+	}
+	for _, track := range prev {
+		if !nextKeys[playlistTrackKey(track)] {
+			diff.Removed = append(diff.Removed, track)
+		}
+	}
+
+	return diff
+}
+
+func playlistTrackKey(track db.PlaylistTrack) string {
+	if track.SpotifyID != "" {
+		return track.SpotifyID
+	}
+	return strings.ToLower(track.Artist + " - " + track.Title)
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+)
+
+func TestDiffPlaylist(t *testing.T) {
+	prev := []db.PlaylistTrack{
+		{SpotifyID: "1", Artist: "A", Title: "One"},
+		{SpotifyID: "2", Artist: "A", Title: "Two"},
+		{Artist: "B", Title: "Untracked"},
+	}
+	next := []db.PlaylistTrack{
+		{SpotifyID: "2", Artist: "A", Title: "Two (Renamed)"},
+		{Artist: "b", Title: "untracked"},
+		{SpotifyID: "3", Artist: "C", Title: "Three"},
+	}
+
+	diff := diffPlaylist(prev, next)
+
+	if want := []db.PlaylistTrack{{SpotifyID: "3", Artist: "C", Title: "Three"}}; !reflect.DeepEqual(diff.Added, want) {
+		t.Errorf("added = %+v, want %+v", diff.Added, want)
+	}
+	if want := []db.PlaylistTrack{{SpotifyID: "1", Artist: "A", Title: "One"}}; !reflect.DeepEqual(diff.Removed, want) {
+		t.Errorf("removed = %+v, want %+v", diff.Removed, want)
+	}
+}
+
+func TestDiffPlaylist_FirstSync(t *testing.T) {
+	next := []db.PlaylistTrack{{SpotifyID: "1"}, {SpotifyID: "2"}}
+
+	diff := diffPlaylist(nil, next)
+	if len(diff.Added) != 2 || len(diff.Removed) != 0 {
+		t.Errorf("expected every track to be added, got %+v", diff)
+	}
+}
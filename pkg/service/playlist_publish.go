@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// PlaylistPublisher pushes generated playlists to a media server
+type PlaylistPublisher interface {
+	PublishPlaylist(ctx context.Context, playlist utils.Playlist) error
+}
+
+// publishPlaylist pushes playlist to the media server, the playlist file is already written
+// so a failure is only logged and retried on the next sync
+func (s *service) publishPlaylist(ctx context.Context, playlist utils.Playlist) {
+	if s.playlistPublisher == nil {
+		return
+	}
+
+	if err := s.playlistPublisher.PublishPlaylist(ctx, playlist); err != nil {
+		s.log.Error("failed to publish playlist", zap.Error(err), zap.String("playlist", playlist.Name))
+		metrics.PlaylistPublishes.WithLabelValues("failure").Inc()
+		return
+	}
+	metrics.PlaylistPublishes.WithLabelValues("success").Inc()
+}
@@ -5,12 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/match"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
 	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/utils"
 	models "github.com/supperdoggy/spot-models"
 	"github.com/supperdoggy/spot-models/spotify"
-	"github.com/zmb3/spotify/v2"
-	"go.mongodb.org/mongo-driver/mongo"
+	spotifyapi "github.com/zmb3/spotify/v2"
 	"go.uber.org/zap"
 )
 
@@ -31,6 +34,15 @@ func (s *service) ProcessPlaylistRequest(ctx context.Context) error {
 		return nil
 	}
 
+	if s.playlistResyncInterval > 0 {
+		reactivated, err := s.database.ReactivateSyncedPlaylists(ctx, time.Now().Add(-s.playlistResyncInterval))
+		if err != nil {
+			s.log.Error("failed to reactivate playlists for re-sync", zap.Error(err))
+		} else if reactivated > 0 {
+			s.log.Info("reactivated playlists for re-sync", zap.Int64("playlists", reactivated))
+		}
+	}
+
 	playlists, err := s.database.GetActivePlaylists(ctx)
 	if err != nil {
 		s.log.Error("failed to get active playlists", zap.Error(err))
@@ -40,16 +52,43 @@ func (s *service) ProcessPlaylistRequest(ctx context.Context) error {
 	s.log.Info("processing active playlists", zap.Any("playlists", len(playlists)))
 
 	for _, playlist := range playlists {
+		s.tick()
+		state, err := s.database.GetPlaylistState(ctx, playlist.ID)
+		if err != nil {
+			s.log.Error("failed to get playlist state", zap.Error(err), zap.String("playlist_id", playlist.ID))
+			continue
+		}
+		if state.NextAttemptAt > time.Now().Unix() {
+			s.log.Info("playlist is waiting for its next attempt", zap.String("playlist_id", playlist.ID),
+				zap.Time("next_attempt_at", time.Unix(state.NextAttemptAt, 0)))
+			continue
+		}
+
+		var nextAttempt time.Time
 		if err := s.ProcessPlaylist(ctx, playlist); err != nil {
-			s.log.Error("failed to process playlist", zap.Error(err), zap.Any("playlist", playlist))
+			class := classifyError(err)
+			s.log.Error("failed to process playlist", zap.Error(err), zap.String("class", string(class)), zap.Any("playlist", playlist))
+			metrics.RequestFailures.WithLabelValues("playlist", string(class)).Inc()
 			playlist.Errored = true
 			playlist.RetryCount++
+
+			if class == errorPermanent || playlist.RetryCount >= s.retry.playlistMaxAttempts() {
+				s.log.Warn("giving up playlist", zap.String("playlist_id", playlist.ID), zap.Int("attempts", playlist.RetryCount))
+				playlist.Active = false
+			} else {
+				nextAttempt = time.Now().Add(s.retry.delay(playlist.RetryCount))
+			}
 		} else {
+			// only playlists that were given up stay errored, those are left out of the re-sync
 			playlist.Active = false
+			playlist.Errored = false
+			playlist.RetryCount = 0
 		}
 
-		if playlist.RetryCount >= 5 {
-			playlist.Active = false
+		if !nextAttempt.IsZero() || state.NextAttemptAt != 0 {
+			if err := s.database.SetPlaylistNextAttempt(ctx, playlist.ID, nextAttempt); err != nil {
+				s.log.Error("failed to schedule next playlist attempt", zap.Error(err), zap.String("playlist_id", playlist.ID))
+			}
 		}
 
 		if err := s.database.UpdatePlaylistRequest(ctx, playlist); err != nil {
@@ -67,7 +106,7 @@ func (s *service) ProcessPlaylist(ctx context.Context, playlist models.PlaylistR
 	// checking if playlist is ready to be processed
 	// by checking if we have active request for playlist download
 	downloadRequest, err := s.database.GetActiveRequest(ctx, playlist.SpotifyURL)
-	if err != nil && err != mongo.ErrNoDocuments {
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
 		s.log.Error("failed to get active request", zap.Error(err))
 		return err
 	}
@@ -89,90 +128,66 @@ func (s *service) ProcessPlaylist(ctx context.Context, playlist models.PlaylistR
 		return err
 	}
 
-	artists := []string{}
-	titles := []string{}
+	songs := make([]spotifyapi.PlaylistItem, 0, len(songList))
+	tracks := make([]libraryQuery, 0, len(songList))
 	for _, item := range songList {
 		if item.Track.Track == nil {
 			s.log.Error("skipping empty track", zap.Any("item", item))
 			continue
 		}
-		artist := []string{}
+		artists := []string{}
 		for _, artistItem := range item.Track.Track.Artists {
-			artist = append(artist, strings.ToLower(artistItem.Name))
+			artists = append(artists, artistItem.Name)
 		}
 
-		artists = append(artists, strings.Join(artist, ", "))
-		titles = append(titles, strings.ToLower(item.Track.Track.Name))
+		songs = append(songs, item)
+		tracks = append(tracks, libraryQuery{
+			Track: match.Track{
+				Artist:   strings.Join(artists, ", "),
+				Title:    item.Track.Track.Name,
+				Duration: item.Track.Track.TimeDuration(),
+			},
+			SpotifyID: string(item.Track.Track.ID),
+			ISRC:      match.NormalizeISRC(item.Track.Track.ExternalIDs["isrc"]),
+		})
 	}
 
-	foundMusic, err := s.database.FindMusicFiles(ctx, artists, titles)
+	found, err := s.findInLibrary(ctx, tracks)
 	if err != nil {
 		s.log.Error("failed to find music file paths", zap.Error(err))
 		return err
 	}
 
-	if len(foundMusic) == 0 {
-		s.log.Error("no indexed paths found for playlist", zap.Any("playlistName", playlistName))
-		return errors.New("no indexed paths found for playlist")
-	}
-
-	foundMusicMap := make(map[string]models.MusicFile)
-	for _, music := range foundMusic {
-		// Normalize to lowercase for consistent matching
-		// Handle both single artist and comma-separated formats
-		normalizedArtist := strings.ToLower(music.Artist)
-		normalizedTitle := strings.ToLower(music.Title)
-		key := normalizedArtist + " " + normalizedTitle
-		foundMusicMap[key] = music
-	}
-
-	missingMusicFiles := []spotify.PlaylistItem{}
-	indexedPaths := make([]string, 0)
-	for _, song := range songList {
-		if song.Track.Track == nil {
-			s.log.Error("skipping empty track", zap.Any("item", song))
+	missingMusicFiles := []spotifyapi.PlaylistItem{}
+	entries := make([]utils.PlaylistEntry, 0, len(songs))
+	written := make([]db.PlaylistTrack, 0, len(songs))
+	for i, song := range songs {
+		if found[i] == nil {
+			s.log.Error("song not found in indexed paths", zap.String("artist", tracks[i].Artist), zap.String("songName", tracks[i].Title))
+			missingMusicFiles = append(missingMusicFiles, song)
 			continue
 		}
-		artists := []string{}
-		for _, artistItem := range song.Track.Track.Artists {
-			artists = append(artists, strings.ToLower(artistItem.Name))
-		}
-
-		artist := strings.Join(artists, ", ")
-
-		songName := strings.ToLower(song.Track.Track.Name)
-
-		key := artist + " " + songName
-
-		// Also try with single artist format (first artist) in case database stores it that way
-		var singleArtistKey string
-		if len(artists) > 0 {
-			singleArtistKey = artists[0] + " " + songName
-		}
-
-		var foundFile models.MusicFile
-		var found bool
 
-		// Try comma-separated artist format first
-		if file, ok := foundMusicMap[key]; ok {
-			foundFile = file
-			found = true
-		} else if singleArtistKey != "" {
-			// Try single artist format (database might store only first artist)
-			if file, ok := foundMusicMap[singleArtistKey]; ok {
-				foundFile = file
-				found = true
-			}
+		entry := utils.PlaylistEntry{
+			Path:     found[i].Path,
+			Artist:   tracks[i].Artist,
+			Title:    tracks[i].Title,
+			Duration: tracks[i].Duration,
 		}
-
-		if !found {
-			s.log.Error("song not found in indexed paths", zap.Any("artist", artist), zap.Any("songName", songName), zap.Any("singleArtist", singleArtistKey))
-			missingMusicFiles = append(missingMusicFiles, song)
-			// return errors.New("song not found in indexed paths")
-			continue
+		if images := song.Track.Track.Album.Images; len(images) > 0 {
+			entry.Cover = images[0].URL
 		}
+		entries = append(entries, entry)
+		written = append(written, db.PlaylistTrack{
+			SpotifyID: tracks[i].SpotifyID,
+			Artist:    tracks[i].Artist,
+			Title:     tracks[i].Title,
+		})
+	}
 
-		indexedPaths = append(indexedPaths, foundFile.Path)
+	if len(entries) == 0 {
+		s.log.Error("no indexed paths found for playlist", zap.Any("playlistName", playlistName))
+		return errors.New("no indexed paths found for playlist")
 	}
 
 	// if we tried to download the playlist but it failed then whatever
@@ -226,21 +241,107 @@ func (s *service) ProcessPlaylist(ctx context.Context, playlist models.PlaylistR
 		}
 	}
 
-	for i, path := range indexedPaths {
-		indexedPaths[i] = strings.ReplaceAll(path, "/mnt/music", "/music")
-	}
-
 	playlistPathName := strings.ReplaceAll(playlistName, "/", `-`)
 	// playlistPathName = strings.ReplaceAll(playlistPathName, " ", `\ `)
 
-	outputPath := s.destination + "/Playlists/" + playlistPathName + ".m3u"
-
-	if err := utils.CreateM3UPlaylist(indexedPaths, s.libraryPath, outputPath); err != nil {
-		s.log.Error("failed to create m3u playlist", zap.Error(err))
+	state, err := s.database.GetPlaylistState(ctx, playlist.ID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		s.log.Error("failed to get playlist state", zap.Error(err), zap.String("playlist_id", playlist.ID))
 		return err
 	}
 
-	s.log.Info("created m3u playlist", zap.Any("outputPath", outputPath))
+	basePath := s.destination + "/Playlists/" + playlistPathName
+	generated := utils.Playlist{Name: playlistName, Entries: entries}
+
+	writers := s.playlistWriters(state.Formats)
+	var files []string
+	for _, writer := range writers {
+		outputPath, err := utils.WritePlaylist(writer, generated, s.playlistPaths, basePath)
+		if err != nil {
+			s.log.Error("failed to write playlist", zap.Error(err), zap.String("outputPath", outputPath))
+			metrics.PlaylistGenerations.WithLabelValues("failure").Inc()
+			return err
+		}
+
+		s.log.Info("wrote playlist", zap.String("outputPath", outputPath))
+		files = append(files, outputPath)
+	}
+	metrics.PlaylistGenerations.WithLabelValues("success").Inc()
+
+	if removed, err := utils.RemoveLegacyPlaylist(basePath, writers); err != nil {
+		s.log.Warn("failed to remove legacy playlist", zap.Error(err), zap.String("basePath", basePath))
+	} else if removed {
+		s.log.Info("removed legacy .m3u playlist", zap.String("basePath", basePath))
+	}
+
+	s.publish(Event{
+		Type:       EventPlaylistGenerated,
+		RequestID:  playlist.ID,
+		SpotifyURL: playlist.SpotifyURL,
+		Name:       playlistName,
+		CreatorID:  playlist.CreatorID,
+		Files:      files,
+	})
+	s.publishPlaylist(ctx, generated)
+	s.recordPlaylistSync(ctx, playlist, state, written)
 
 	return nil
 }
+
+// playlistWriters returns the writers of the formats requested for a playlist, falling back to the configured ones
+func (s *service) playlistWriters(requested []string) []utils.PlaylistWriter {
+	if len(requested) > 0 {
+		formats := make([]utils.PlaylistFormat, 0, len(requested))
+		for _, format := range requested {
+			formats = append(formats, utils.PlaylistFormat(format))
+		}
+
+		if writers := s.newPlaylistWriters(formats); len(writers) > 0 {
+			return writers
+		}
+	}
+
+	if writers := s.newPlaylistWriters(s.playlistFormats); len(writers) > 0 {
+		return writers
+	}
+
+	return []utils.PlaylistWriter{utils.DefaultPlaylistWriter()}
+}
+
+// newPlaylistWriters returns one writer per distinct known format, unknown formats are logged and skipped
+func (s *service) newPlaylistWriters(formats []utils.PlaylistFormat) []utils.PlaylistWriter {
+	writers := make([]utils.PlaylistWriter, 0, len(formats))
+	seen := make(map[string]bool, len(formats))
+	for _, format := range formats {
+		writer, err := utils.NewPlaylistWriter(format)
+		if err != nil {
+			s.log.Warn("skipping playlist format", zap.Error(err))
+			continue
+		}
+		if seen[writer.Extension()] {
+			continue
+		}
+		seen[writer.Extension()] = true
+		writers = append(writers, writer)
+	}
+
+	return writers
+}
+
+// recordPlaylistSync stores the written tracks and their diff to the previous state on the request
+func (s *service) recordPlaylistSync(ctx context.Context, playlist models.PlaylistRequest, state db.PlaylistState, written []db.PlaylistTrack) {
+	diff := diffPlaylist(state.Tracks, written)
+	diff.At = time.Now().Unix()
+	s.log.Info("playlist synced",
+		zap.String("playlist_id", playlist.ID),
+		zap.Int("tracks", len(written)),
+		zap.Any("added", diff.Added),
+		zap.Any("removed", diff.Removed))
+
+	state.Tracks = written
+	state.LastDiff = &diff
+	state.LastSyncedAt = diff.At
+	if err := s.database.SetPlaylistState(ctx, playlist.ID, state); err != nil {
+		s.log.Error("failed to save playlist state", zap.Error(err), zap.String("playlist_id", playlist.ID))
+	}
+}
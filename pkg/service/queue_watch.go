@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// QueueWatcher reports queue changes as they are written, e.g. through a Mongo change stream
+type QueueWatcher interface {
+	// WatchQueues calls notify for every new or re-activated request until ctx is done.
+	// It returns db.ErrChangeStreamsUnsupported when the database can't be watched.
+	WatchQueues(ctx context.Context, notify func()) error
+}
+
+// watchRetryDelay is the wait before a failed change stream is opened again
+var watchRetryDelay = 30 * time.Second
+
+// watchQueues wakes the processing loop through wake until ctx is done.
+// Polling every processInterval goes on regardless, so nothing is lost while the stream is down.
+func (s *service) watchQueues(ctx context.Context, wake chan<- struct{}) {
+	notify := func() {
+		metrics.QueueWakeups.Inc()
+		// a pending wake up covers this change too
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+
+	for {
+		err := s.watcher.WatchQueues(ctx, notify)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, db.ErrChangeStreamsUnsupported) {
+			s.log.Warn("queue change streams are not supported, polling only", zap.Duration("interval", s.processInterval))
+			return
+		}
+
+		s.log.Error("queue change stream stopped, reopening", zap.Error(err), zap.Duration("retry_in", watchRetryDelay))
+		timer := time.NewTimer(watchRetryDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		// catch up on the changes missed while the stream was down
+		notify()
+	}
+}
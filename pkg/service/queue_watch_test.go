@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	models "github.com/supperdoggy/spot-models"
+	"go.uber.org/zap"
+)
+
+// passCountingDatabase counts the processing passes by their queue reads
+type passCountingDatabase struct {
+	db.Database
+	passes atomic.Int32
+}
+
+func (p *passCountingDatabase) GetActiveRequests(ctx context.Context) ([]models.DownloadQueueRequest, error) {
+	p.passes.Add(1)
+	return p.Database.GetActiveRequests(ctx)
+}
+
+type fakeWatcher struct {
+	calls    atomic.Int32
+	err      error
+	notifies chan func()
+}
+
+func (f *fakeWatcher) WatchQueues(ctx context.Context, notify func()) error {
+	f.calls.Add(1)
+	if f.err != nil {
+		return f.err
+	}
+	f.notifies <- notify
+	<-ctx.Done()
+	return nil
+}
+
+func runWatchedService(t *testing.T, watcher QueueWatcher) *passCountingDatabase {
+	t.Helper()
+	database := &passCountingDatabase{Database: db.NewMemoryDatabase()}
+	srv := NewService(database, zap.NewNop(), nil, &fakeDownloader{}, Options{
+		ProcessInterval: time.Hour,
+		Watcher:         watcher,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitFor(t, func() bool { return database.passes.Load() >= 1 })
+	return database
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRun_WakesOnQueueChange(t *testing.T) {
+	watcher := &fakeWatcher{notifies: make(chan func(), 1)}
+	database := runWatchedService(t, watcher)
+
+	notify := <-watcher.notifies
+	notify()
+	notify() // coalesced with the first one
+	waitFor(t, func() bool { return database.passes.Load() >= 2 })
+
+	time.Sleep(50 * time.Millisecond)
+	if passes := database.passes.Load(); passes > 3 {
+		t.Errorf("expected the wake ups to be coalesced, got %d passes", passes)
+	}
+}
+
+func TestRun_PollsWithoutChangeStreams(t *testing.T) {
+	delay := watchRetryDelay
+	t.Cleanup(func() { watchRetryDelay = delay })
+	watchRetryDelay = time.Millisecond
+
+	watcher := &fakeWatcher{err: db.ErrChangeStreamsUnsupported}
+	database := runWatchedService(t, watcher)
+
+	time.Sleep(50 * time.Millisecond)
+	if calls := watcher.calls.Load(); calls != 1 {
+		t.Errorf("expected no retries on an unsupported deployment, got %d calls", calls)
+	}
+	if passes := database.passes.Load(); passes != 1 {
+		t.Errorf("expected polling only, got %d passes", passes)
+	}
+}
+
+func TestRun_ReopensFailedChangeStream(t *testing.T) {
+	delay := watchRetryDelay
+	t.Cleanup(func() { watchRetryDelay = delay })
+	watchRetryDelay = time.Millisecond
+
+	watcher := &fakeWatcher{err: errors.New("connection reset")}
+	database := runWatchedService(t, watcher)
+
+	// every reopen catches up with a pass
+	waitFor(t, func() bool { return watcher.calls.Load() > 1 && database.passes.Load() > 1 })
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/supperdoggy/spot-models/spotify"
+	spotifyapi "github.com/zmb3/spotify/v2"
+)
+
+var (
+	// ErrPermanent marks failures that retrying won't fix
+	ErrPermanent = errors.New("permanent failure")
+)
+
+const (
+	defaultRetryBaseDelay = 10 * time.Minute
+	defaultRetryMaxDelay  = 12 * time.Hour
+	defaultMaxAttempts    = 5
+)
+
+// RetryPolicy decides when failed requests are tried again and when they are given up
+type RetryPolicy struct {
+	// BaseDelay is the wait after the first failure, it doubles with every further one up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxAttempts are the failed attempts of a download request per object type before it is given up
+	MaxAttempts map[spotify.SpotifyObjectType]int
+	// PlaylistMaxAttempts are the failed attempts of a playlist request before it is given up
+	PlaylistMaxAttempts int
+}
+
+// maxAttempts returns the attempt limit of a download request of objectType
+func (p RetryPolicy) maxAttempts(objectType spotify.SpotifyObjectType) int {
+	if n := p.MaxAttempts[objectType]; n > 0 {
+		return n
+	}
+	return defaultMaxAttempts
+}
+
+func (p RetryPolicy) playlistMaxAttempts() int {
+	if p.PlaylistMaxAttempts > 0 {
+		return p.PlaylistMaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// delay returns the wait before the attempt after the given number of failed ones.
+// The exponential delay is jittered into its upper half so failed requests don't retry in lockstep.
+func (p RetryPolicy) delay(failures int) time.Duration {
+	base, maxDelay := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+
+	d := base
+	for i := 1; i < failures && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+
+	return d/2 + rand.N(d/2+1)
+}
+
+// errorClass tells whether retrying a failed request can help
+type errorClass string
+
+const (
+	errorRetryable errorClass = "retryable"
+	errorPermanent errorClass = "permanent"
+)
+
+// permanentMessages are lower case fragments of errors about the request itself:
+// invalid urls, content removed from Spotify and content not available in the region
+var permanentMessages = []string{
+	"invalid url",
+	"invalid id",
+	"invalid base62 id",
+	"non existing id",
+	"unsupported url",
+	"not a valid spotify",
+	"resource not found",
+	"has been removed",
+	"no longer available",
+	"made this video available in your country",
+	"not available in your country",
+	"not available in your region",
+	"not available in your market",
+	"region restricted",
+}
+
+// classifyError sorts a failure into retryable, like network errors, rate limits and provider
+// outages, or permanent. Unknown errors are retryable, the attempt limit ends those.
+func classifyError(err error) errorClass {
+	if errors.Is(err, ErrPermanent) {
+		return errorPermanent
+	}
+	if errors.Is(err, ErrDownloadTimedOut) || errors.Is(err, context.DeadlineExceeded) {
+		return errorRetryable
+	}
+
+	var apiErr spotifyapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Status {
+		case http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound:
+			return errorPermanent
+		case http.StatusTooManyRequests:
+			return errorRetryable
+		}
+		if apiErr.Status >= http.StatusInternalServerError {
+			return errorRetryable
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return errorRetryable
+	}
+
+	if isPermanentMessage(err.Error()) {
+		return errorPermanent
+	}
+
+	return errorRetryable
+}
+
+// isPermanentMessage reports whether an error message contains one of permanentMessages
+func isPermanentMessage(message string) bool {
+	message = strings.ToLower(message)
+	for _, fragment := range permanentMessages {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// downloadError marks a failed download as permanent when retrying can't help: every track the
+// downloader failed on was reported as removed or region locked. One such track next to tracks
+// that failed for other reasons leaves the run retryable, per-track limits skip it later.
+func downloadError(err error, result DownloadResult) error {
+	if err == nil || errors.Is(err, ErrDownloadTimedOut) {
+		return err
+	}
+
+	failed := 0
+	for _, event := range result.Events {
+		if !event.Failed() {
+			continue
+		}
+		if event.Track == "" && event.SpotifyURL == "" || !isPermanentMessage(event.Message) {
+			return err
+		}
+		failed++
+	}
+	if failed == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w: %d tracks are unavailable", ErrPermanent, err, failed)
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/spotdl"
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+	spotifyapi "github.com/zmb3/spotify/v2"
+	"go.uber.org/zap"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want errorClass
+	}{
+		{"timeout", fmt.Errorf("%w: spotdl did not finish within 15m", ErrDownloadTimedOut), errorRetryable},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, errorRetryable},
+		{"rate limit", spotifyapi.Error{Status: 429, Message: "API rate limit exceeded"}, errorRetryable},
+		{"provider outage", spotifyapi.Error{Status: 503, Message: "Service unavailable"}, errorRetryable},
+		{"exit status", errors.New("exit status 1"), errorRetryable},
+		{"removed", fmt.Errorf("failed to get track count: %w", spotifyapi.Error{Status: 404, Message: "Non existing id"}), errorPermanent},
+		{"invalid id", spotifyapi.Error{Status: 400, Message: "Invalid base62 id"}, errorPermanent},
+		{"region locked", errors.New("track is not available in your country"), errorPermanent},
+		{"marked", fmt.Errorf("%w: invalid url", ErrPermanent), errorPermanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Minute, MaxDelay: 10 * time.Minute}
+
+	for failures, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute, 4: 8 * time.Minute, 10: 10 * time.Minute} {
+		for range 20 {
+			d := policy.delay(failures)
+			if d < want/2 || d > want {
+				t.Fatalf("delay(%d) = %s, want within [%s, %s]", failures, d, want/2, want)
+			}
+		}
+	}
+}
+
+func TestRetryPolicy_MaxAttempts(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: map[spotify.SpotifyObjectType]int{spotify.SpotifyObjectTypeTrack: 2}}
+
+	if got := policy.maxAttempts(spotify.SpotifyObjectTypeTrack); got != 2 {
+		t.Errorf("track attempts = %d, want 2", got)
+	}
+	if got := policy.maxAttempts(spotify.SpotifyObjectTypeAlbum); got != defaultMaxAttempts {
+		t.Errorf("album attempts = %d, want the default %d", got, defaultMaxAttempts)
+	}
+}
+
+// fakeSpotify answers the metadata lookups of ProcessRequest
+type fakeSpotify struct {
+	spotify.SpotifyService
+	err error
+}
+
+func (f *fakeSpotify) GetTrackCount(ctx context.Context, url string) (int, []spotify.TrackMetadata, error) {
+	return 0, nil, f.err
+}
+
+func TestHandleDownloadRequest_Retries(t *testing.T) {
+	regionLocked := spotdl.Event{
+		Kind:       spotdl.EventProviderError,
+		SpotifyURL: "https://open.spotify.com/track/1",
+		Message:    "AudioProviderError: YT-DLP download error - The uploader has not made this video available in your country",
+	}
+
+	tests := []struct {
+		name          string
+		spotifyErr    error
+		downloadErr   error
+		downloaded    DownloadResult
+		maxAttempts   int
+		wantActive    bool
+		wantScheduled bool
+	}{
+		{"retryable failure is scheduled", nil, errors.New("exit status 1"), DownloadResult{}, 3, true, true},
+		{"attempt limit gives up", nil, errors.New("exit status 1"), DownloadResult{}, 1, false, false},
+		{"removed from spotify gives up", spotifyapi.Error{Status: 404, Message: "Non existing id"}, nil, DownloadResult{}, 3, false, false},
+		{"region locked download gives up", nil, errors.New("exit status 1"), DownloadResult{
+			Events: []spotdl.Event{regionLocked},
+		}, 3, false, false},
+		{"region locked next to a provider error is scheduled", nil, errors.New("exit status 1"), DownloadResult{
+			Events: []spotdl.Event{regionLocked, {Kind: spotdl.EventProviderError, SpotifyURL: "https://open.spotify.com/track/2", Message: "AudioProviderError: YT-DLP download error - HTTP Error 503"}},
+		}, 3, true, true},
+		{"region locked line without a track is scheduled", nil, errors.New("exit status 1"), DownloadResult{
+			OutputTail: []string{regionLocked.Message},
+		}, 3, true, true},
+		{"no match is scheduled", nil, errors.New("exit status 1"), DownloadResult{
+			Events: []spotdl.Event{{Kind: spotdl.EventNoMatch, Track: "Artist - Song", Message: "No results found for song: Artist - Song"}},
+		}, 3, true, true},
+		{"rate limited download is scheduled", nil, errors.New("exit status 1"), DownloadResult{
+			Events: []spotdl.Event{{Kind: spotdl.EventRateLimited}, {Kind: spotdl.EventNoMatch}},
+		}, 3, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			database := db.NewMemoryDatabase()
+			srv := NewService(database, zap.NewNop(), &fakeSpotify{err: tt.spotifyErr}, &fakeDownloader{err: tt.downloadErr, tail: tt.downloaded.OutputTail, events: tt.downloaded.Events}, Options{
+				Retry: RetryPolicy{MaxAttempts: map[spotify.SpotifyObjectType]int{spotify.SpotifyObjectTypeAlbum: tt.maxAttempts}},
+			}).(*service)
+
+			url := "https://open.spotify.com/album/1"
+			if err := database.NewDownloadRequest(ctx, url, "album", 1, spotify.SpotifyObjectTypeAlbum); err != nil {
+				t.Fatalf("NewDownloadRequest failed: %v", err)
+			}
+			request, err := database.GetActiveRequest(ctx, url)
+			if err != nil {
+				t.Fatalf("GetActiveRequest failed: %v", err)
+			}
+
+			srv.handleDownloadRequest(ctx, request)
+
+			got, err := database.GetRequest(ctx, request.ID)
+			if err != nil {
+				t.Fatalf("GetRequest failed: %v", err)
+			}
+			state, err := database.GetRequestState(ctx, request.ID)
+			if err != nil {
+				t.Fatalf("GetRequestState failed: %v", err)
+			}
+
+			if got.Active != tt.wantActive || !got.Errored || got.RetryCount != 1 || got.SyncCount != 0 {
+				t.Errorf("unexpected request after a failed attempt: %+v", got)
+			}
+			if scheduled := state.NextAttemptAt > time.Now().Unix(); scheduled != tt.wantScheduled {
+				t.Errorf("next attempt at %d, want scheduled %v", state.NextAttemptAt, tt.wantScheduled)
+			}
+		})
+	}
+}
+
+func TestProcessPlaylistRequest_WaitsForNextAttempt(t *testing.T) {
+	ctx := context.Background()
+	database := db.NewMemoryDatabase()
+	srv := newTestService(database, &fakeDownloader{})
+
+	url := "https://open.spotify.com/playlist/1"
+	if err := database.NewPlaylistRequest(ctx, url, 1); err != nil {
+		t.Fatalf("NewPlaylistRequest failed: %v", err)
+	}
+	// the download of the playlist is still running
+	if err := database.NewDownloadRequest(ctx, url, "playlist", 1, spotify.SpotifyObjectTypePlaylist); err != nil {
+		t.Fatalf("NewDownloadRequest failed: %v", err)
+	}
+
+	if err := srv.ProcessPlaylistRequest(ctx); err != nil {
+		t.Fatalf("ProcessPlaylistRequest failed: %v", err)
+	}
+	playlist := activePlaylist(t, database)
+	state, err := database.GetPlaylistState(ctx, playlist.ID)
+	if err != nil {
+		t.Fatalf("GetPlaylistState failed: %v", err)
+	}
+	if playlist.RetryCount != 1 || state.NextAttemptAt <= time.Now().Unix() {
+		t.Fatalf("expected a scheduled retry, got %+v, next attempt %d", playlist, state.NextAttemptAt)
+	}
+
+	// not attempted again before its time
+	if err := srv.ProcessPlaylistRequest(ctx); err != nil {
+		t.Fatalf("ProcessPlaylistRequest failed: %v", err)
+	}
+	if playlist := activePlaylist(t, database); playlist.RetryCount != 1 {
+		t.Errorf("playlist attempted before its next attempt, retry count %d", playlist.RetryCount)
+	}
+}
+
+func activePlaylist(t *testing.T, database db.Database) models.PlaylistRequest {
+	t.Helper()
+	playlists, err := database.GetActivePlaylists(context.Background())
+	if err != nil || len(playlists) != 1 {
+		t.Fatalf("GetActivePlaylists = %v, %v", playlists, err)
+	}
+	return playlists[0]
+}
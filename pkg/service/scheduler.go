@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrShuttingDown = errors.New("shutting down")
+)
+
+// Run processes the queues every processInterval until ctx is cancelled.
+// With a queue watcher a pass also starts as soon as a request is queued.
+// On cancellation the in-flight pass gets shutdownGracePeriod to finish,
+// after that its context is cancelled which kills any running spotdl child.
+func (s *service) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.requestStop()
+	}()
+
+	wake := make(chan struct{}, 1)
+	if s.watcher != nil {
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.watchQueues(ctx, wake)
+		}()
+	}
+
+	s.log.Info("starting processing loop",
+		zap.Duration("interval", s.processInterval),
+		zap.Duration("shutdown_grace_period", s.shutdownGracePeriod),
+		zap.Bool("watch_queues", s.watcher != nil))
+
+	ticker := time.NewTicker(s.processInterval)
+	defer ticker.Stop()
+
+	for {
+		// the pass sees everything queued before it starts
+		select {
+		case <-wake:
+		default:
+		}
+		s.runPass(ctx)
+
+		select {
+		case <-ctx.Done():
+			s.log.Info("processing loop stopped")
+			return nil
+		case <-ticker.C:
+		case <-wake:
+			s.log.Info("queue changed, starting a pass")
+		}
+	}
+}
+
+// runPass runs a single processing pass and blocks until it is done or killed
+func (s *service) runPass(ctx context.Context) {
+	s.tick()
+	// the pass outlives ctx so that in-flight downloads can finish during the grace period
+	passCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				s.log.Error("recovered from panic", zap.Any("panic", r))
+				done <- errors.New("processing pass panicked")
+			}
+		}()
+		done <- s.StartProcessing(passCtx)
+	}()
+
+	select {
+	case err := <-done:
+		s.logPassResult(err)
+		return
+	case <-ctx.Done():
+	}
+
+	s.log.Info("shutdown requested, waiting for in-flight work", zap.Duration("grace_period", s.shutdownGracePeriod))
+
+	timer := time.NewTimer(s.shutdownGracePeriod)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		s.logPassResult(err)
+	case <-timer.C:
+		s.log.Warn("grace period expired, killing in-flight work")
+		cancel()
+		s.logPassResult(<-done)
+	}
+}
+
+func (s *service) logPassResult(err error) {
+	s.tick()
+	metrics.LastPassTimestamp.SetToCurrentTime()
+	if err != nil && !errors.Is(err, ErrShuttingDown) {
+		s.log.Error("processing pass failed", zap.Error(err))
+		return
+	}
+	s.log.Info("processing pass finished")
+}
+
+// tick records that the processing loop made progress, it backs the liveness probe
+func (s *service) tick() {
+	s.lastTick.Store(time.Now().UnixNano())
+}
+
+func (s *service) LastTick() time.Time {
+	return time.Unix(0, s.lastTick.Load())
+}
+
+func (s *service) requestStop() {
+	s.stoppingOnce.Do(func() {
+		close(s.stopping)
+	})
+}
+
+func (s *service) stopRequested() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for d, returning early if ctx is cancelled or shutdown is requested
+func (s *service) sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.stopping:
+		return ErrShuttingDown
+	}
+}
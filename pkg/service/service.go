@@ -3,41 +3,174 @@ package service
 import (
 	"context"
 	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/match"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/utils"
 	"github.com/supperdoggy/spot-models/spotify"
 	"go.uber.org/zap"
 )
 
 type Service interface {
 	StartProcessing(ctx context.Context) error
+	Run(ctx context.Context) error
+	// LastTick returns when the processing loop last made progress
+	LastTick() time.Time
+}
+
+// Options holds the tunables of the service
+type Options struct {
+	Destination    string
+	LibraryPath    string
+	SleepInMinutes int
+
+	// ProcessInterval is the time between the start of two processing passes
+	ProcessInterval time.Duration
+	// ShutdownGracePeriod is how long an in-flight pass may keep running after shutdown was requested
+	ShutdownGracePeriod time.Duration
+
+	// DownloadWorkers is the number of requests processed in parallel
+	DownloadWorkers int
+
+	// InstanceID owns the leases of the requests this instance processes, empty uses hostname and pid
+	InstanceID string
+	// LeaseDuration is how long a claimed request stays leased without a heartbeat, 0 uses 5m
+	LeaseDuration time.Duration
+
+	// MatchThreshold is the minimum score for a library file to count as a wanted track, 0 uses match.DefaultThreshold
+	MatchThreshold float64
+	// MatchDurationTolerance is the maximum duration difference of a match when both durations are known
+	MatchDurationTolerance time.Duration
+
+	// PlaylistPaths rewrites library paths for the generated playlists, the zero value keeps them as they are
+	PlaylistPaths utils.PathMapper
+	// PlaylistFormats are the formats written for playlists that don't request their own, empty writes m3u8 only
+	PlaylistFormats []utils.PlaylistFormat
+
+	// PlaylistResyncInterval is how often synced playlists are refreshed from Spotify, 0 disables it
+	PlaylistResyncInterval time.Duration
+
+	// PlaylistPublisher pushes generated playlists to a media server, nil disables it
+	PlaylistPublisher PlaylistPublisher
+
+	// Rescanner is triggered after a batch downloaded files, nil disables it
+	Rescanner LibraryRescanner
+
+	// Retry schedules and limits the attempts of failed requests, the zero value uses the defaults
+	Retry RetryPolicy
+
+	// Events receives the request lifecycle events, nil disables them
+	Events EventPublisher
+
+	// Watcher wakes the processing loop when requests are queued, nil polls every ProcessInterval only
+	Watcher QueueWatcher
+
+	// Indexer indexes the library after each pass and downloaded files right away, nil disables indexing
+	Indexer LibraryIndexer
 }
 
 type service struct {
 	database       db.Database
 	log            *zap.Logger
 	spotifyService spotify.SpotifyService
+	downloader     Downloader
+	indexer        LibraryIndexer
+	rescanner      LibraryRescanner
+	events         EventPublisher
+	watcher        QueueWatcher
+	matcher        *match.Matcher
+	playlistPaths  utils.PathMapper
+
+	playlistFormats   []utils.PlaylistFormat
+	playlistPublisher PlaylistPublisher
+
+	playlistResyncInterval time.Duration
 
 	destination    string
 	sleepInMinutes int
 	libraryPath    string
+
+	processInterval     time.Duration
+	shutdownGracePeriod time.Duration
+
+	downloadWorkers int
+	retry           RetryPolicy
+	instanceID      string
+	leaseDuration   time.Duration
+	// downloadLimiter spaces out request starts by sleepInMinutes across all workers
+	downloadLimiter *rateLimiter
+
+	// stopping is closed once shutdown was requested, no new work is started after that
+	stopping     chan struct{}
+	stoppingOnce sync.Once
+
+	// outputTails holds the downloader output of the last failed download of each request being processed
+	outputTails sync.Map
+
+	// downloadedFiles counts the files downloaded since the last media server rescan
+	downloadedFiles atomic.Int64
+
+	// lastTick is the unix nano time the processing loop last made progress
+	lastTick atomic.Int64
 }
 
-func NewService(database db.Database, log *zap.Logger, spotifyService spotify.SpotifyService, destination, libraryPath string, sleepInMinutes int) Service {
-	return &service{
+func NewService(database db.Database, log *zap.Logger, spotifyService spotify.SpotifyService, downloader Downloader, opts Options) Service {
+	threshold := opts.MatchThreshold
+	if threshold <= 0 {
+		threshold = match.DefaultThreshold
+	}
+	instanceID := opts.InstanceID
+	if instanceID == "" {
+		instanceID = defaultInstanceID()
+	}
+	leaseDuration := opts.LeaseDuration
+	if leaseDuration <= 0 {
+		leaseDuration = defaultLeaseDuration
+	}
+
+	s := &service{
 		database:       database,
 		log:            log,
 		spotifyService: spotifyService,
-		destination:    destination,
-		sleepInMinutes: sleepInMinutes,
-		libraryPath:    libraryPath,
+		downloader:     downloader,
+		indexer:        opts.Indexer,
+		rescanner:      opts.Rescanner,
+		events:         opts.Events,
+		watcher:        opts.Watcher,
+		matcher:        match.NewMatcher(threshold, opts.MatchDurationTolerance),
+		playlistPaths:  opts.PlaylistPaths,
+
+		playlistFormats:        opts.PlaylistFormats,
+		playlistPublisher:      opts.PlaylistPublisher,
+		playlistResyncInterval: opts.PlaylistResyncInterval,
+		destination:            opts.Destination,
+		sleepInMinutes:         opts.SleepInMinutes,
+		libraryPath:            opts.LibraryPath,
+		processInterval:        opts.ProcessInterval,
+		shutdownGracePeriod:    opts.ShutdownGracePeriod,
+		downloadWorkers:        opts.DownloadWorkers,
+		retry:                  opts.Retry,
+		instanceID:             instanceID,
+		leaseDuration:          leaseDuration,
+		downloadLimiter:        newRateLimiter(time.Duration(opts.SleepInMinutes) * time.Minute),
+		stopping:               make(chan struct{}),
 	}
+	s.tick()
+
+	return s
 }
 
 // StartProcessing starts the processing of the requests
 func (s *service) StartProcessing(ctx context.Context) error {
 	downloadError := s.ProcessDownloadRequest(ctx)
 
+	if s.stopRequested() {
+		return downloadError
+	}
+
 	playlistError := s.ProcessPlaylistRequest(ctx)
 
 	return errors.Join(downloadError, playlistError)
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/db"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/spotdl"
+	models "github.com/supperdoggy/spot-models"
+	"github.com/supperdoggy/spot-models/spotify"
+	"go.uber.org/zap"
+)
+
+// recordDownloadEvents ties the downloader events to the tracks of the request and persists them,
+// results of tracks that did not show up in this run are kept
+func (s *service) recordDownloadEvents(ctx context.Context, request models.DownloadQueueRequest, events []spotdl.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	for _, event := range events {
+		if event.Kind != spotdl.EventDownloaded {
+			continue
+		}
+		downloaded := requestEvent(EventTrackDownloaded, request)
+		downloaded.Track = &EventTrack{Title: event.Track, SpotifyURL: event.SpotifyURL}
+		if track, ok := matchEventTrack(request.TrackMetadata, event); ok {
+			downloaded.Track = &EventTrack{Artist: track.Artist, Title: track.Title, SpotifyURL: track.SpotifyURL}
+		}
+		s.publish(downloaded)
+	}
+
+	state, err := s.database.GetRequestState(ctx, request.ID)
+	if err != nil {
+		s.log.Error("failed to get request state", zap.Error(err), zap.String("request_id", request.ID))
+		return
+	}
+
+	results := make(map[string]db.TrackResult, len(state.TrackResults))
+	for _, result := range state.TrackResults {
+		results[trackResultKey(result.SpotifyURL, result.Artist, result.Title)] = result
+	}
+
+	now := time.Now().Unix()
+	for _, event := range events {
+		fields := []zap.Field{
+			zap.String("request_id", request.ID),
+			zap.String("event", string(event.Kind)),
+			zap.String("track", event.Track),
+			zap.String("spotify_url", event.SpotifyURL),
+			zap.String("message", event.Message),
+		}
+		if event.Failed() {
+			s.log.Warn("track download failed", fields...)
+		} else {
+			s.log.Info("track download result", fields...)
+		}
+
+		track, ok := matchEventTrack(request.TrackMetadata, event)
+		if !ok {
+			continue
+		}
+
+		results[trackResultKey(track.SpotifyURL, track.Artist, track.Title)] = db.TrackResult{
+			SpotifyURL: track.SpotifyURL,
+			Artist:     track.Artist,
+			Title:      track.Title,
+			Status:     string(event.Kind),
+			Reason:     event.Message,
+			UpdatedAt:  now,
+		}
+	}
+
+	// keep the order of the request tracks
+	ordered := make([]db.TrackResult, 0, len(results))
+	for _, track := range request.TrackMetadata {
+		if result, ok := results[trackResultKey(track.SpotifyURL, track.Artist, track.Title)]; ok {
+			ordered = append(ordered, result)
+		}
+	}
+
+	if err := s.database.SetTrackResults(ctx, request.ID, ordered); err != nil {
+		s.log.Error("failed to persist track results", zap.Error(err), zap.String("request_id", request.ID))
+	}
+}
+
+// matchEventTrack finds the track an event is about, by url first and by the "Artist - Title" display name otherwise
+func matchEventTrack(tracks []spotify.TrackMetadata, event spotdl.Event) (spotify.TrackMetadata, bool) {
+	if event.SpotifyURL != "" {
+		for _, track := range tracks {
+			if track.SpotifyURL == event.SpotifyURL {
+				return track, true
+			}
+		}
+	}
+
+	if event.Track != "" {
+		name := strings.ToLower(event.Track)
+		for _, track := range tracks {
+			if strings.ToLower(track.Artist+" - "+track.Title) == name {
+				return track, true
+			}
+		}
+	}
+
+	// single track requests have nothing to disambiguate
+	if len(tracks) == 1 && (event.SpotifyURL != "" || event.Track != "") {
+		return tracks[0], true
+	}
+
+	return spotify.TrackMetadata{}, false
+}
+
+// maxNoMatchAttempts skips a track sooner than spotify.MaxFailedAttempts when the downloader
+// found no match for it, searching again on the next sync rarely finds one
+const maxNoMatchAttempts = 2
+
+// countTrackFailure records a failed attempt of the track and reports whether the track is skipped now.
+// The kind of the last downloader result decides the cost: a rate limit says nothing about the track and
+// is not counted, a missing match is skipped after maxNoMatchAttempts, anything else after spotify.MaxFailedAttempts.
+func countTrackFailure(track *spotify.TrackMetadata, kind spotdl.EventKind) bool {
+	maxAttempts := spotify.MaxFailedAttempts
+	switch kind {
+	case spotdl.EventRateLimited:
+		return false
+	case spotdl.EventNoMatch:
+		maxAttempts = maxNoMatchAttempts
+	}
+
+	track.FailedAttempts++
+	if track.FailedAttempts >= maxAttempts {
+		track.Skipped = true
+	}
+	return track.Skipped
+}
+
+// failureKind returns the kind of the last failure the downloader reported, empty if it reported none
+func failureKind(events []spotdl.Event) spotdl.EventKind {
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Failed() {
+			return events[i].Kind
+		}
+	}
+	return ""
+}
+
+func trackResultKey(spotifyURL, artist, title string) string {
+	if spotifyURL != "" {
+		return spotifyURL
+	}
+	return strings.ToLower(artist + " - " + title)
+}
@@ -0,0 +1,101 @@
+package spotdl
+
+import (
+	"regexp"
+	"strings"
+)
+
+// EventKind classifies a line of downloader output
+type EventKind string
+
+const (
+	EventDownloaded    EventKind = "downloaded"
+	EventSkipped       EventKind = "skipped"
+	EventNoMatch       EventKind = "no_match"
+	EventProviderError EventKind = "provider_error"
+	EventRateLimited   EventKind = "rate_limited"
+)
+
+// Event is a structured result parsed from spotdl output
+type Event struct {
+	Kind EventKind
+	// Track is the "Artist - Title" display name spotdl printed, if any
+	Track string
+	// SpotifyURL is the track url spotdl printed, if any
+	SpotifyURL string
+	// Message is the reason for skips and failures
+	Message string
+}
+
+var (
+	ansiPattern       = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+	downloadedPattern = regexp.MustCompile(`^Downloaded "(.+)": (\S+)$`)
+	skippedPattern    = regexp.MustCompile(`^Skipping (.+?) \((.+?)\)(?: \(.+\))?$`)
+	explicitPattern   = regexp.MustCompile(`^Skipping explicit song: (.+)$`)
+	errorPattern      = regexp.MustCompile(`^(?:(https://open\.spotify\.com/track/\S+) - )?(\w+(?:Error|Exception)): (.*)$`)
+	noResultsPattern  = regexp.MustCompile(`No results found for song: (.+)$`)
+	rateLimitPattern  = regexp.MustCompile(`(?i)(rate/request limit|rate limit|too many requests|http error 429)`)
+)
+
+// providerErrors are the exception names spotdl reports for failed downloads
+var providerErrors = map[string]bool{
+	"AudioProviderError": true,
+	"DownloaderError":    true,
+	"FFmpegError":        true,
+	"ConversionError":    true,
+	"DownloadError":      true,
+}
+
+// ParseLine parses a single line of spotdl stdout/stderr, ok is false for progress and noise
+func ParseLine(line string) (Event, bool) {
+	line = strings.TrimSpace(ansiPattern.ReplaceAllString(line, ""))
+	if line == "" {
+		return Event{}, false
+	}
+
+	if m := downloadedPattern.FindStringSubmatch(line); m != nil {
+		return Event{Kind: EventDownloaded, Track: m[1]}, true
+	}
+
+	if m := explicitPattern.FindStringSubmatch(line); m != nil {
+		return Event{Kind: EventSkipped, Track: m[1], Message: "explicit"}, true
+	}
+
+	if m := skippedPattern.FindStringSubmatch(line); m != nil {
+		return Event{Kind: EventSkipped, Track: m[1], Message: m[2]}, true
+	}
+
+	// Rate limits show up inside provider errors as well, so they are checked first
+	if rateLimitPattern.MatchString(line) {
+		event := Event{Kind: EventRateLimited, Message: line}
+		if m := errorPattern.FindStringSubmatch(line); m != nil {
+			event.SpotifyURL = m[1]
+		}
+		return event, true
+	}
+
+	m := errorPattern.FindStringSubmatch(line)
+	if m == nil {
+		return Event{}, false
+	}
+
+	spotifyURL, name, message := m[1], m[2], m[3]
+	if name == "LookupError" {
+		event := Event{Kind: EventNoMatch, SpotifyURL: spotifyURL, Message: message}
+		if nm := noResultsPattern.FindStringSubmatch(message); nm != nil {
+			event.Track = nm[1]
+		}
+		return event, true
+	}
+
+	if providerErrors[name] {
+		return Event{Kind: EventProviderError, SpotifyURL: spotifyURL, Message: name + ": " + message}, true
+	}
+
+	return Event{}, false
+}
+
+// Failed reports whether the event means the track was not downloaded
+func (e Event) Failed() bool {
+	return e.Kind == EventNoMatch || e.Kind == EventProviderError || e.Kind == EventRateLimited
+}
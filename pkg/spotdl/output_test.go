@@ -0,0 +1,97 @@
+package spotdl
+
+import "testing"
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		ok    bool
+		event Event
+	}{
+		{
+			name:  "downloaded",
+			line:  `Downloaded "Artist1, Artist2 - Song1": https://music.youtube.com/watch?v=abc`,
+			ok:    true,
+			event: Event{Kind: EventDownloaded, Track: "Artist1, Artist2 - Song1"},
+		},
+		{
+			name:  "skipped existing",
+			line:  `Skipping Artist1 - Song1 (file already exists) (duplicate)`,
+			ok:    true,
+			event: Event{Kind: EventSkipped, Track: "Artist1 - Song1", Message: "file already exists"},
+		},
+		{
+			name:  "skipped explicit",
+			line:  `Skipping explicit song: Artist1 - Song1`,
+			ok:    true,
+			event: Event{Kind: EventSkipped, Track: "Artist1 - Song1", Message: "explicit"},
+		},
+		{
+			name:  "lookup error",
+			line:  `LookupError: No results found for song: Artist1 - Song (Remastered)`,
+			ok:    true,
+			event: Event{Kind: EventNoMatch, Track: "Artist1 - Song (Remastered)", Message: "No results found for song: Artist1 - Song (Remastered)"},
+		},
+		{
+			name: "lookup error with url",
+			line: `https://open.spotify.com/track/123 - LookupError: No results found for song: Artist1 - Song1`,
+			ok:   true,
+			event: Event{Kind: EventNoMatch, SpotifyURL: "https://open.spotify.com/track/123", Track: "Artist1 - Song1",
+				Message: "No results found for song: Artist1 - Song1"},
+		},
+		{
+			name:  "provider error",
+			line:  `https://open.spotify.com/track/123 - AudioProviderError: YT-DLP download error - https://music.youtube.com/watch?v=abc`,
+			ok:    true,
+			event: Event{Kind: EventProviderError, SpotifyURL: "https://open.spotify.com/track/123", Message: "AudioProviderError: YT-DLP download error - https://music.youtube.com/watch?v=abc"},
+		},
+		{
+			name:  "rate limited provider error",
+			line:  `AudioProviderError: YT-DLP download error - HTTP Error 429: Too Many Requests`,
+			ok:    true,
+			event: Event{Kind: EventRateLimited, Message: "AudioProviderError: YT-DLP download error - HTTP Error 429: Too Many Requests"},
+		},
+		{
+			name:  "spotify rate limit",
+			line:  `Your application has reached a rate/request limit. Retry will occur after: 30`,
+			ok:    true,
+			event: Event{Kind: EventRateLimited, Message: "Your application has reached a rate/request limit. Retry will occur after: 30"},
+		},
+		{
+			name:  "ansi colored",
+			line:  "\x1b[32mDownloaded \"Artist1 - Song1\": https://music.youtube.com/watch?v=abc\x1b[0m",
+			ok:    true,
+			event: Event{Kind: EventDownloaded, Track: "Artist1 - Song1"},
+		},
+		{name: "progress noise", line: `Processing query: https://open.spotify.com/album/1`},
+		{name: "unknown error", line: `KeyError: 'name'`},
+		{name: "empty", line: "   "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := ParseLine(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("expected ok=%v, got %v (%+v)", tt.ok, ok, event)
+			}
+			if event != tt.event {
+				t.Errorf("expected %+v, got %+v", tt.event, event)
+			}
+		})
+	}
+}
+
+func TestEvent_Failed(t *testing.T) {
+	for kind, failed := range map[EventKind]bool{
+		EventDownloaded:    false,
+		EventSkipped:       false,
+		EventNoMatch:       true,
+		EventProviderError: true,
+		EventRateLimited:   true,
+	} {
+		if (Event{Kind: kind}).Failed() != failed {
+			t.Errorf("expected Failed()=%v for %s", failed, kind)
+		}
+	}
+}
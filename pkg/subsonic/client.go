@@ -0,0 +1,220 @@
+package subsonic
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// apiVersion is the Subsonic API version the client speaks, Navidrome supports it fully
+	apiVersion = "1.16.1"
+	clientName = "spotdl-wrapper"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+)
+
+// Error is an error response of the Subsonic API
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("subsonic error %d: %s", e.Code, e.Message)
+}
+
+// Song is a song of the server library
+type Song struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+	// Duration is in seconds
+	Duration int    `json:"duration"`
+	Path     string `json:"path"`
+}
+
+// Playlist is a playlist stored on the server, Entries is only filled by GetPlaylist
+type Playlist struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	SongCount int    `json:"songCount"`
+	Entries   []Song `json:"entry"`
+}
+
+type response struct {
+	Status        string `json:"status"`
+	Error         *Error `json:"error"`
+	SearchResult3 struct {
+		Songs []Song `json:"song"`
+	} `json:"searchResult3"`
+	Playlists struct {
+		Playlists []Playlist `json:"playlist"`
+	} `json:"playlists"`
+	Playlist Playlist `json:"playlist"`
+}
+
+// Client calls the Subsonic REST API with token authentication
+type Client struct {
+	baseURL  string
+	username string
+	password string
+	http     *http.Client
+}
+
+// NewClient returns a client of the server at baseURL, e.g. http://navidrome:4533
+func NewClient(baseURL, username, password string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		username: username,
+		password: password,
+		http:     &http.Client{Timeout: timeout},
+	}
+}
+
+// Ping checks the server is reachable and the credentials are valid
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.call(ctx, "ping", nil)
+	return err
+}
+
+// Search3 returns up to count songs matching query
+func (c *Client) Search3(ctx context.Context, query string, count int) ([]Song, error) {
+	resp, err := c.call(ctx, "search3", url.Values{
+		"query":       {query},
+		"songCount":   {strconv.Itoa(count)},
+		"artistCount": {"0"},
+		"albumCount":  {"0"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.SearchResult3.Songs, nil
+}
+
+// GetPlaylists returns the playlists of the user without their songs
+func (c *Client) GetPlaylists(ctx context.Context) ([]Playlist, error) {
+	resp, err := c.call(ctx, "getPlaylists", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Playlists.Playlists, nil
+}
+
+// GetPlaylist returns the playlist with its songs
+func (c *Client) GetPlaylist(ctx context.Context, id string) (Playlist, error) {
+	resp, err := c.call(ctx, "getPlaylist", url.Values{"id": {id}})
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	return resp.Playlist, nil
+}
+
+// CreatePlaylist creates a playlist of songIDs and returns its id
+func (c *Client) CreatePlaylist(ctx context.Context, name string, songIDs []string) (string, error) {
+	resp, err := c.call(ctx, "createPlaylist", url.Values{
+		"name":   {name},
+		"songId": songIDs,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Playlist.ID, nil
+}
+
+// UpdatePlaylist adds songIDs to the playlist and removes the songs at removeIndexes
+func (c *Client) UpdatePlaylist(ctx context.Context, id string, songIDs []string, removeIndexes []int) error {
+	params := url.Values{
+		"playlistId":  {id},
+		"songIdToAdd": songIDs,
+	}
+	for _, index := range removeIndexes {
+		params.Add("songIndexToRemove", strconv.Itoa(index))
+	}
+
+	_, err := c.call(ctx, "updatePlaylist", params)
+	return err
+}
+
+// StartScan starts a library scan on the server, it returns before the scan finishes
+func (c *Client) StartScan(ctx context.Context) error {
+	_, err := c.call(ctx, "startScan", nil)
+	return err
+}
+
+// call runs a method of the API, the parameters are sent as a form so long playlists fit
+func (c *Client) call(ctx context.Context, method string, params url.Values) (*response, error) {
+	form := url.Values{}
+	for key, values := range params {
+		form[key] = values
+	}
+
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+	token := md5.Sum([]byte(c.password + salt))
+
+	form.Set("u", c.username)
+	form.Set("t", hex.EncodeToString(token[:]))
+	form.Set("s", salt)
+	form.Set("v", apiVersion)
+	form.Set("c", clientName)
+	form.Set("f", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/rest/"+method, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("subsonic %s returned status %d", method, res.StatusCode)
+	}
+
+	var body struct {
+		Response response `json:"subsonic-response"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode subsonic %s response: %w", method, err)
+	}
+
+	if body.Response.Status != "ok" {
+		if body.Response.Error != nil {
+			return nil, body.Response.Error
+		}
+		return nil, fmt.Errorf("subsonic %s failed with status %q", method, body.Response.Status)
+	}
+
+	return &body.Response, nil
+}
+
+func newSalt() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,141 @@
+package subsonic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/match"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// searchCount is how many songs search3 returns per track, the best match among them is used
+const searchCount = 20
+
+var (
+	ErrNoSongs = errors.New("no playlist songs found on the server")
+)
+
+// Publisher creates and updates playlists on a Subsonic compatible server like Navidrome
+type Publisher struct {
+	client  *Client
+	matcher *match.Matcher
+	log     *zap.Logger
+}
+
+func NewPublisher(client *Client, matcher *match.Matcher, log *zap.Logger) *Publisher {
+	return &Publisher{
+		client:  client,
+		matcher: matcher,
+		log:     log,
+	}
+}
+
+// PublishPlaylist makes the server playlist named like playlist hold its tracks in order,
+// creating it if needed. Tracks the server doesn't know yet are left out until the next sync.
+func (p *Publisher) PublishPlaylist(ctx context.Context, playlist utils.Playlist) error {
+	songIDs := make([]string, 0, len(playlist.Entries))
+	for _, entry := range playlist.Entries {
+		song, err := p.findSong(ctx, entry)
+		if errors.Is(err, ErrNotFound) {
+			p.log.Debug("track not found on subsonic server", zap.String("artist", entry.Artist), zap.String("title", entry.Title))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		songIDs = append(songIDs, song.ID)
+	}
+
+	if len(songIDs) == 0 {
+		return ErrNoSongs
+	}
+
+	existing, err := p.findPlaylist(ctx, playlist.Name)
+	if errors.Is(err, ErrNotFound) {
+		id, err := p.client.CreatePlaylist(ctx, playlist.Name, songIDs)
+		if err != nil {
+			return fmt.Errorf("failed to create playlist: %w", err)
+		}
+
+		p.log.Info("created subsonic playlist", zap.String("name", playlist.Name), zap.String("id", id), zap.Int("songs", len(songIDs)))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := p.client.GetPlaylist(ctx, existing.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get playlist: %w", err)
+	}
+
+	currentIDs := make([]string, 0, len(current.Entries))
+	for _, song := range current.Entries {
+		currentIDs = append(currentIDs, song.ID)
+	}
+	if slices.Equal(currentIDs, songIDs) {
+		p.log.Debug("subsonic playlist up to date", zap.String("name", playlist.Name))
+		return nil
+	}
+
+	// the server removes by index before appending, so this replaces the songs keeping the playlist id
+	remove := make([]int, len(currentIDs))
+	for i := range remove {
+		remove[i] = i
+	}
+	if err := p.client.UpdatePlaylist(ctx, existing.ID, songIDs, remove); err != nil {
+		return fmt.Errorf("failed to update playlist: %w", err)
+	}
+
+	p.log.Info("updated subsonic playlist", zap.String("name", playlist.Name), zap.String("id", existing.ID), zap.Int("songs", len(songIDs)))
+	return nil
+}
+
+// findSong searches the server for entry and returns the best match
+func (p *Publisher) findSong(ctx context.Context, entry utils.PlaylistEntry) (Song, error) {
+	title, _ := match.CleanTitle(entry.Title)
+	if title == "" {
+		return Song{}, ErrNotFound
+	}
+
+	songs, err := p.client.Search3(ctx, title, searchCount)
+	if err != nil {
+		return Song{}, fmt.Errorf("failed to search %q: %w", title, err)
+	}
+
+	candidates := make([]match.Track, 0, len(songs))
+	for _, song := range songs {
+		candidates = append(candidates, match.Track{
+			Artist:   song.Artist,
+			Title:    song.Title,
+			Duration: time.Duration(song.Duration) * time.Second,
+		})
+	}
+
+	best, _ := p.matcher.Best(match.Track{Artist: entry.Artist, Title: entry.Title, Duration: entry.Duration}, candidates)
+	if best < 0 {
+		return Song{}, ErrNotFound
+	}
+
+	return songs[best], nil
+}
+
+// findPlaylist returns the playlist of the user named name
+func (p *Publisher) findPlaylist(ctx context.Context, name string) (Playlist, error) {
+	playlists, err := p.client.GetPlaylists(ctx)
+	if err != nil {
+		return Playlist{}, fmt.Errorf("failed to list playlists: %w", err)
+	}
+
+	for _, playlist := range playlists {
+		if playlist.Name == name {
+			return playlist, nil
+		}
+	}
+
+	return Playlist{}, ErrNotFound
+}
@@ -0,0 +1,184 @@
+package subsonic
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/match"
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// stubServer is a minimal in-memory Subsonic server
+type stubServer struct {
+	t        *testing.T
+	password string
+	songs    []Song
+
+	mu        sync.Mutex
+	playlists map[string][]string
+	names     map[string]string
+	calls     []string
+}
+
+func newStubServer(t *testing.T, password string, songs []Song) *httptest.Server {
+	stub := &stubServer{t: t, password: password, songs: songs, playlists: map[string][]string{}, names: map[string]string{}}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (s *stubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.t.Errorf("invalid form: %v", err)
+	}
+
+	token := md5.Sum([]byte(s.password + r.Form.Get("s")))
+	if r.Form.Get("t") != hex.EncodeToString(token[:]) || r.Form.Get("f") != "json" || r.Form.Get("v") == "" || r.Form.Get("c") == "" {
+		s.write(w, map[string]any{"status": "failed", "error": map[string]any{"code": 40, "message": "Wrong username or password"}})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	method := strings.TrimPrefix(r.URL.Path, "/rest/")
+	s.calls = append(s.calls, method)
+	resp := map[string]any{"status": "ok"}
+
+	switch method {
+	case "ping":
+	case "search3":
+		query := strings.ToLower(r.Form.Get("query"))
+		var found []Song
+		for _, song := range s.songs {
+			if strings.Contains(strings.ToLower(song.Title), query) {
+				found = append(found, song)
+			}
+		}
+		resp["searchResult3"] = map[string]any{"song": found}
+	case "getPlaylists":
+		var playlists []Playlist
+		for id, name := range s.names {
+			playlists = append(playlists, Playlist{ID: id, Name: name, SongCount: len(s.playlists[id])})
+		}
+		resp["playlists"] = map[string]any{"playlist": playlists}
+	case "getPlaylist":
+		id := r.Form.Get("id")
+		resp["playlist"] = Playlist{ID: id, Name: s.names[id], Entries: s.entries(s.playlists[id])}
+	case "createPlaylist":
+		id := "pl-" + strconv.Itoa(len(s.names)+1)
+		s.names[id] = r.Form.Get("name")
+		s.playlists[id] = r.Form["songId"]
+		resp["playlist"] = Playlist{ID: id, Name: s.names[id]}
+	case "updatePlaylist":
+		id := r.Form.Get("playlistId")
+		var kept []string
+		for i, song := range s.playlists[id] {
+			if !slices.Contains(r.Form["songIndexToRemove"], strconv.Itoa(i)) {
+				kept = append(kept, song)
+			}
+		}
+		s.playlists[id] = append(kept, r.Form["songIdToAdd"]...)
+	default:
+		s.t.Errorf("unexpected method %q", method)
+	}
+
+	s.write(w, resp)
+}
+
+func (s *stubServer) entries(ids []string) []Song {
+	var songs []Song
+	for _, id := range ids {
+		for _, song := range s.songs {
+			if song.ID == id {
+				songs = append(songs, song)
+			}
+		}
+	}
+	return songs
+}
+
+func (s *stubServer) write(w http.ResponseWriter, resp map[string]any) {
+	resp["version"] = "1.16.1"
+	if err := json.NewEncoder(w).Encode(map[string]any{"subsonic-response": resp}); err != nil {
+		s.t.Errorf("failed to write response: %v", err)
+	}
+}
+
+func TestPublisher_PublishPlaylist(t *testing.T) {
+	songs := []Song{
+		{ID: "1", Title: "Jóga", Artist: "Björk", Duration: 305},
+		{ID: "2", Title: "Jóga (Live)", Artist: "Some Cover Band", Duration: 290},
+		{ID: "3", Title: "Hyperballad", Artist: "Björk", Duration: 321},
+	}
+	srv := newStubServer(t, "secret", songs)
+	stub := srv.Config.Handler.(*stubServer)
+
+	publisher := NewPublisher(NewClient(srv.URL, "admin", "secret", time.Second), match.NewMatcher(match.DefaultThreshold, 5*time.Second), zap.NewNop())
+	ctx := context.Background()
+
+	playlist := utils.Playlist{
+		Name: "Björk",
+		Entries: []utils.PlaylistEntry{
+			{Artist: "Björk", Title: "Jóga - 2002 Remaster", Duration: 305 * time.Second},
+			{Artist: "Björk", Title: "Not Downloaded Yet"},
+		},
+	}
+	if err := publisher.PublishPlaylist(ctx, playlist); err != nil {
+		t.Fatalf("PublishPlaylist failed: %v", err)
+	}
+	if got := stub.playlists["pl-1"]; !slices.Equal(got, []string{"1"}) {
+		t.Fatalf("expected created playlist with song 1, got %v", got)
+	}
+
+	// the new track shows up on the server and the order changes
+	playlist.Entries = []utils.PlaylistEntry{
+		{Artist: "Björk", Title: "Hyperballad"},
+		{Artist: "Björk", Title: "Jóga"},
+	}
+	if err := publisher.PublishPlaylist(ctx, playlist); err != nil {
+		t.Fatalf("PublishPlaylist failed: %v", err)
+	}
+	if got := stub.playlists["pl-1"]; !slices.Equal(got, []string{"3", "1"}) {
+		t.Fatalf("expected updated playlist [3 1], got %v", got)
+	}
+	if len(stub.names) != 1 {
+		t.Errorf("expected the playlist to be updated in place, got %v", stub.names)
+	}
+
+	// an unchanged playlist is not touched
+	stub.calls = nil
+	if err := publisher.PublishPlaylist(ctx, playlist); err != nil {
+		t.Fatalf("PublishPlaylist failed: %v", err)
+	}
+	if slices.Contains(stub.calls, "updatePlaylist") {
+		t.Errorf("unchanged playlist was updated: %v", stub.calls)
+	}
+
+	empty := utils.Playlist{Name: "Empty", Entries: []utils.PlaylistEntry{{Artist: "Nobody", Title: "Nothing"}}}
+	if err := publisher.PublishPlaylist(ctx, empty); !errors.Is(err, ErrNoSongs) {
+		t.Errorf("expected ErrNoSongs, got %v", err)
+	}
+}
+
+func TestClient_WrongPassword(t *testing.T) {
+	srv := newStubServer(t, "secret", nil)
+
+	err := NewClient(srv.URL, "admin", "wrong", time.Second).Ping(context.Background())
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != 40 {
+		t.Fatalf("expected subsonic error 40, got %v", err)
+	}
+}
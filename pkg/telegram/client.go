@@ -0,0 +1,95 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxMessageLength is the Bot API limit of a message text in characters
+const maxMessageLength = 4096
+
+// Client sends messages through the Telegram Bot API
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient returns a client of the Bot API at baseURL, normally https://api.telegram.org
+func NewClient(baseURL, token string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+type sendMessageRequest struct {
+	ChatID                int64  `json:"chat_id"`
+	Text                  string `json:"text"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+type apiResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// SendMessage sends text as plain text to chatID, longer texts are cut at the API limit
+func (c *Client) SendMessage(ctx context.Context, chatID int64, text string) error {
+	if runes := []rune(text); len(runes) > maxMessageLength {
+		text = string(runes[:maxMessageLength-1]) + "…"
+	}
+
+	body, err := json.Marshal(sendMessageRequest{ChatID: chatID, Text: text, DisableWebPagePreview: true})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/bot"+c.token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		// the url contains the token, keep it out of the logs
+		return fmt.Errorf("telegram sendMessage failed: %w", stripURL(err))
+	}
+	defer res.Body.Close()
+
+	var resp apiResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode telegram response with status %d: %w", res.StatusCode, err)
+	}
+
+	if !resp.OK {
+		if resp.Parameters.RetryAfter > 0 {
+			return fmt.Errorf("telegram error %d: %s (retry after %ds)", resp.ErrorCode, resp.Description, resp.Parameters.RetryAfter)
+		}
+		return fmt.Errorf("telegram error %d: %s", resp.ErrorCode, resp.Description)
+	}
+
+	return nil
+}
+
+// stripURL drops the request url from a transport error
+func stripURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
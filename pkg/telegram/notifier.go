@@ -0,0 +1,228 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/service"
+	"go.uber.org/zap"
+)
+
+// maxListedTracks bounds the skipped tracks listed in a message
+const maxListedTracks = 30
+
+// Notifier tells request creators about their finished requests and sends a daily digest
+// to the admin chat. It is an event sink, the digest covers the events it saw since the last one.
+type Notifier struct {
+	client      *Client
+	log         *zap.Logger
+	adminChatID int64
+	digestHour  int
+
+	mu     sync.Mutex
+	digest digest
+}
+
+// digest counts the events since the last digest
+type digest struct {
+	since      time.Time
+	completed  int
+	partial    int
+	failed     int
+	downloaded int
+	skipped    int
+	playlists  int
+	failures   []string
+}
+
+// NewNotifier returns a notifier, adminChatID 0 disables the digest which is sent at digestHour local time
+func NewNotifier(client *Client, log *zap.Logger, adminChatID int64, digestHour int) *Notifier {
+	return &Notifier{
+		client:      client,
+		log:         log,
+		adminChatID: adminChatID,
+		digestHour:  digestHour,
+		digest:      digest{since: time.Now()},
+	}
+}
+
+func (n *Notifier) Name() string {
+	return "telegram"
+}
+
+// Deliver counts event for the digest and messages the creator of finished requests
+func (n *Notifier) Deliver(ctx context.Context, event service.Event) error {
+	n.count(event)
+
+	var text string
+	switch event.Type {
+	case service.EventRequestCompleted:
+		text = completedMessage(event)
+	case service.EventRequestDeactivated:
+		text = deactivatedMessage(event)
+	default:
+		return nil
+	}
+
+	// requests queued by the wrapper itself, e.g. missing playlist tracks, have no creator
+	if event.CreatorID == 0 {
+		return nil
+	}
+
+	return n.client.SendMessage(ctx, event.CreatorID, text)
+}
+
+func (n *Notifier) count(event service.Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch event.Type {
+	case service.EventRequestCompleted:
+		if len(event.Skipped) > 0 {
+			n.digest.partial++
+		} else {
+			n.digest.completed++
+		}
+	case service.EventRequestDeactivated:
+		if failed(event) {
+			n.digest.failed++
+			n.digest.failures = append(n.digest.failures, requestName(event))
+		} else {
+			n.digest.partial++
+		}
+	case service.EventTrackDownloaded:
+		n.digest.downloaded++
+	case service.EventTrackSkipped:
+		n.digest.skipped++
+	case service.EventPlaylistGenerated:
+		n.digest.playlists++
+	}
+}
+
+// Run sends the daily digest until ctx is cancelled
+func (n *Notifier) Run(ctx context.Context) {
+	if n.adminChatID == 0 {
+		return
+	}
+
+	for {
+		timer := time.NewTimer(time.Until(nextDigest(time.Now(), n.digestHour)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := n.SendDigest(ctx); err != nil {
+			n.log.Error("failed to send telegram digest", zap.Error(err))
+		}
+	}
+}
+
+// SendDigest sends the digest to the admin chat and starts a new one, nothing is sent for a quiet day
+func (n *Notifier) SendDigest(ctx context.Context) error {
+	n.mu.Lock()
+	d := n.digest
+	n.digest = digest{since: time.Now()}
+	n.mu.Unlock()
+
+	if d.completed+d.partial+d.failed+d.downloaded+d.skipped+d.playlists == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Daily digest since %s\n\n", d.since.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "Requests completed: %d\n", d.completed)
+	fmt.Fprintf(&b, "Requests partially completed: %d\n", d.partial)
+	fmt.Fprintf(&b, "Requests failed: %d\n", d.failed)
+	fmt.Fprintf(&b, "Tracks downloaded: %d\n", d.downloaded)
+	fmt.Fprintf(&b, "Tracks skipped: %d\n", d.skipped)
+	fmt.Fprintf(&b, "Playlists generated: %d\n", d.playlists)
+	if len(d.failures) > 0 {
+		b.WriteString("\nFailed:\n")
+		writeList(&b, d.failures)
+	}
+
+	return n.client.SendMessage(ctx, n.adminChatID, b.String())
+}
+
+// nextDigest returns the next time at hour after now
+func nextDigest(now time.Time, hour int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func completedMessage(event service.Event) string {
+	if len(event.Skipped) == 0 {
+		return fmt.Sprintf("✅ %s is ready, %d tracks downloaded.", requestName(event), event.FoundTracks)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "⚠️ %s is partially ready, %d of %d tracks downloaded.\n", requestName(event), event.FoundTracks, event.ExpectedTracks)
+	writeSkipped(&b, event)
+	return b.String()
+}
+
+// deactivatedMessage tells a failed request apart from one that stopped with tracks still missing
+func deactivatedMessage(event service.Event) string {
+	var b strings.Builder
+	if failed(event) {
+		fmt.Fprintf(&b, "❌ %s could not be downloaded, %d of %d tracks found.\n\n%s\n", requestName(event), event.FoundTracks, event.ExpectedTracks, event.Reason)
+	} else {
+		fmt.Fprintf(&b, "⚠️ %s is partially ready, %d of %d tracks downloaded, the rest could not be found.\n", requestName(event), event.FoundTracks, event.ExpectedTracks)
+	}
+	writeSkipped(&b, event)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// failed reports whether a deactivated request ended with an error, without one it ran out of syncs
+// with some tracks never found
+func failed(event service.Event) bool {
+	return event.Reason != ""
+}
+
+// writeSkipped lists the skipped tracks of event, if any
+func writeSkipped(b *strings.Builder, event service.Event) {
+	if len(event.Skipped) == 0 {
+		return
+	}
+
+	b.WriteString("\nSkipped:\n")
+	skipped := make([]string, 0, len(event.Skipped))
+	for _, track := range event.Skipped {
+		skipped = append(skipped, trackName(track))
+	}
+	writeList(b, skipped)
+}
+
+// writeList writes up to maxListedTracks items as a bullet list
+func writeList(b *strings.Builder, items []string) {
+	for i, item := range items {
+		if i == maxListedTracks {
+			fmt.Fprintf(b, "… and %d more\n", len(items)-maxListedTracks)
+			return
+		}
+		fmt.Fprintf(b, "• %s\n", item)
+	}
+}
+
+func requestName(event service.Event) string {
+	if event.Name != "" {
+		return event.Name
+	}
+	return event.SpotifyURL
+}
+
+func trackName(track service.EventTrack) string {
+	if track.Artist == "" {
+		return track.Title
+	}
+	return track.Artist + " - " + track.Title
+}
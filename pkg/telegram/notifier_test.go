@@ -0,0 +1,161 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/supperdoggy/SmartHomeServer/music-services/spotdl-wapper/pkg/service"
+	"go.uber.org/zap"
+)
+
+// fakeBotAPI records the messages sent through sendMessage
+type fakeBotAPI struct {
+	mu       sync.Mutex
+	messages []sendMessageRequest
+}
+
+func newFakeBotAPI(t *testing.T, token string) (*fakeBotAPI, *httptest.Server) {
+	api := &fakeBotAPI{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bot"+token+"/sendMessage" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"ok": false, "error_code": 401, "description": "Unauthorized"}`))
+			return
+		}
+
+		var msg sendMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("invalid sendMessage body: %v", err)
+		}
+		api.mu.Lock()
+		api.messages = append(api.messages, msg)
+		api.mu.Unlock()
+		_, _ = w.Write([]byte(`{"ok": true, "result": {"message_id": 1}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return api, srv
+}
+
+func TestNotifier_Deliver(t *testing.T) {
+	api, srv := newFakeBotAPI(t, "123:abc")
+	notifier := NewNotifier(NewClient(srv.URL, "123:abc", time.Second), zap.NewNop(), 0, 9)
+	ctx := context.Background()
+
+	events := []service.Event{
+		{Type: service.EventRequestCompleted, CreatorID: 42, Name: "Homogenic", FoundTracks: 10, ExpectedTracks: 10},
+		{Type: service.EventRequestCompleted, CreatorID: 42, Name: "Debut", FoundTracks: 9, ExpectedTracks: 11, Skipped: []service.EventTrack{
+			{Artist: "Björk", Title: "Play Dead"},
+			{Artist: "Björk", Title: "Atlantic"},
+		}},
+		{Type: service.EventRequestDeactivated, CreatorID: 42, SpotifyURL: "https://open.spotify.com/album/x", Reason: "spotdl exited with code 1"},
+		// ran out of syncs without an error
+		{Type: service.EventRequestDeactivated, CreatorID: 42, Name: "Post", FoundTracks: 10, ExpectedTracks: 11, Skipped: []service.EventTrack{
+			{Artist: "Björk", Title: "Enjoy"},
+		}},
+		// no creator, no message
+		{Type: service.EventRequestCompleted, Name: "Missing track"},
+		// not announced to the creator
+		{Type: service.EventTrackDownloaded, CreatorID: 42},
+	}
+	for _, event := range events {
+		if err := notifier.Deliver(ctx, event); err != nil {
+			t.Fatalf("Deliver(%s) failed: %v", event.Type, err)
+		}
+	}
+
+	if len(api.messages) != 4 {
+		t.Fatalf("expected 4 messages, got %d: %+v", len(api.messages), api.messages)
+	}
+	for _, msg := range api.messages {
+		if msg.ChatID != 42 {
+			t.Errorf("message sent to chat %d", msg.ChatID)
+		}
+	}
+	if !strings.Contains(api.messages[0].Text, "Homogenic is ready") {
+		t.Errorf("unexpected completed message %q", api.messages[0].Text)
+	}
+	if text := api.messages[1].Text; !strings.Contains(text, "9 of 11") || !strings.Contains(text, "• Björk - Play Dead") || !strings.Contains(text, "• Björk - Atlantic") {
+		t.Errorf("unexpected partial message %q", text)
+	}
+	if text := api.messages[2].Text; !strings.Contains(text, "https://open.spotify.com/album/x could not be downloaded") || !strings.Contains(text, "spotdl exited with code 1") {
+		t.Errorf("unexpected failed message %q", text)
+	}
+	if text := api.messages[3].Text; strings.Contains(text, "❌") || !strings.Contains(text, "Post is partially ready, 10 of 11") || !strings.Contains(text, "• Björk - Enjoy") {
+		t.Errorf("unexpected partial deactivation message %q", text)
+	}
+}
+
+func TestNotifier_SendDigest(t *testing.T) {
+	api, srv := newFakeBotAPI(t, "123:abc")
+	notifier := NewNotifier(NewClient(srv.URL, "123:abc", time.Second), zap.NewNop(), -100, 9)
+	ctx := context.Background()
+
+	// a quiet day sends nothing
+	if err := notifier.SendDigest(ctx); err != nil {
+		t.Fatalf("SendDigest failed: %v", err)
+	}
+	if len(api.messages) != 0 {
+		t.Fatalf("expected no digest for a quiet day, got %+v", api.messages)
+	}
+
+	for _, event := range []service.Event{
+		{Type: service.EventTrackDownloaded},
+		{Type: service.EventTrackDownloaded},
+		{Type: service.EventTrackSkipped},
+		{Type: service.EventRequestCompleted, Skipped: []service.EventTrack{{Title: "x"}}},
+		{Type: service.EventRequestDeactivated, Name: "Vespertine", Reason: "spotdl exited with code 1"},
+		{Type: service.EventRequestDeactivated, Name: "Medúlla"},
+	} {
+		if err := notifier.Deliver(ctx, event); err != nil {
+			t.Fatalf("Deliver failed: %v", err)
+		}
+	}
+
+	if err := notifier.SendDigest(ctx); err != nil {
+		t.Fatalf("SendDigest failed: %v", err)
+	}
+	if len(api.messages) != 1 || api.messages[0].ChatID != -100 {
+		t.Fatalf("expected one digest to the admin chat, got %+v", api.messages)
+	}
+	for _, want := range []string{"partially completed: 2", "failed: 1", "Tracks downloaded: 2", "Tracks skipped: 1", "• Vespertine"} {
+		if !strings.Contains(api.messages[0].Text, want) {
+			t.Errorf("digest %q misses %q", api.messages[0].Text, want)
+		}
+	}
+}
+
+func TestClient_APIError(t *testing.T) {
+	_, srv := newFakeBotAPI(t, "123:abc")
+
+	err := NewClient(srv.URL, "wrong", time.Second).SendMessage(context.Background(), 1, "hi")
+	if err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Fatalf("expected the api error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "wrong") {
+		t.Errorf("error leaks the token: %v", err)
+	}
+}
+
+func TestNextDigest(t *testing.T) {
+	loc := time.FixedZone("test", 2*60*60)
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 10, 16, 8, 30, 0, 0, loc), time.Date(2026, 10, 16, 9, 0, 0, 0, loc)},
+		{time.Date(2026, 10, 16, 9, 0, 0, 0, loc), time.Date(2026, 10, 17, 9, 0, 0, 0, loc)},
+		{time.Date(2026, 12, 31, 23, 0, 0, 0, loc), time.Date(2027, 1, 1, 9, 0, 0, 0, loc)},
+	}
+
+	for _, tt := range tests {
+		if got := nextDigest(tt.now, 9); !got.Equal(tt.want) {
+			t.Errorf("nextDigest(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonWriter writes a JSON manifest of the playlist for scripts and custom players
+type jsonWriter struct{}
+
+type jsonPlaylist struct {
+	Name   string      `json:"name"`
+	Tracks []jsonTrack `json:"tracks"`
+}
+
+type jsonTrack struct {
+	Path       string `json:"path"`
+	Artist     string `json:"artist,omitempty"`
+	Title      string `json:"title,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Cover      string `json:"cover,omitempty"`
+}
+
+func (jsonWriter) Extension() string {
+	return ".json"
+}
+
+func (jsonWriter) Write(w io.Writer, playlist Playlist, mapper PathMapper, outputPath string) error {
+	doc := jsonPlaylist{
+		Name:   playlist.Name,
+		Tracks: make([]jsonTrack, 0, len(playlist.Entries)),
+	}
+
+	for _, entry := range playlist.Entries {
+		path, err := mapper.Map(entry.Path, outputPath)
+		if err != nil {
+			return err
+		}
+
+		doc.Tracks = append(doc.Tracks, jsonTrack{
+			Path:       path,
+			Artist:     entry.Artist,
+			Title:      entry.Title,
+			DurationMs: entry.Duration.Milliseconds(),
+			Cover:      entry.Cover,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -87,26 +89,45 @@ func FindUnindexedSongs(songList []string, musicRoot, outputPath string) ([]stri
 	return matchedPaths, nil
 }
 
-// CreateM3UPlaylist generates an .m3u playlist from a list of "Artist - Song" strings
-func CreateM3UPlaylist(matchedPaths []string, musicRoot, outputPath string) error {
+// m3uWriter writes extended M3U playlists, always UTF-8 whatever the extension
+type m3uWriter struct {
+	extension string
+}
 
-	// check if file already exists
-	if _, err := os.Stat(outputPath); err == nil {
-		return os.ErrExist
-	}
+func (w m3uWriter) Extension() string {
+	return w.extension
+}
 
-	// Write the M3U file
-	f, err := os.Create(outputPath)
-	if err != nil {
+func (m3uWriter) Write(w io.Writer, playlist Playlist, mapper PathMapper, outputPath string) error {
+	return writeExtendedM3U(w, playlist.Entries, mapper, outputPath)
+}
+
+func writeExtendedM3U(w io.Writer, entries []PlaylistEntry, mapper PathMapper, outputPath string) error {
+	if _, err := io.WriteString(w, "#EXTM3U\n"); err != nil {
 		return err
 	}
-	defer f.Close()
 
-	for _, path := range matchedPaths {
-		_, err := f.WriteString(path + "\n")
+	for _, entry := range entries {
+		path, err := mapper.Map(entry.Path, outputPath)
 		if err != nil {
 			return err
 		}
+
+		// the display title ends at the line break, keep it on one line
+		title := strings.Join(strings.Fields(entryTitle(entry)), " ")
+		if _, err := fmt.Fprintf(w, "#EXTINF:%d,%s\n", durationSeconds(entry.Duration), title); err != nil {
+			return err
+		}
+
+		if entry.Cover != "" {
+			if _, err := fmt.Fprintf(w, "#EXTIMG:%s\n", entry.Cover); err != nil {
+				return err
+			}
+		}
+
+		if _, err := io.WriteString(w, path+"\n"); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -3,72 +3,57 @@ package utils
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
-func TestCreateM3UPlaylist(t *testing.T) {
-	tmpDir := t.TempDir()
-	outputPath := filepath.Join(tmpDir, "test.m3u")
-
-	paths := []string{
-		"/music/Artist1 - Song1.flac",
-		"/music/Artist2 - Song2.flac",
-		"/music/Artist3 - Song3.flac",
-	}
-
-	err := CreateM3UPlaylist(paths, tmpDir, outputPath)
-	if err != nil {
-		t.Fatalf("CreateM3UPlaylist failed: %v", err)
-	}
-
-	// Verify file was created
-	content, err := os.ReadFile(outputPath)
-	if err != nil {
-		t.Fatalf("failed to read output file: %v", err)
-	}
-
-	// Check content contains our paths
-	contentStr := string(content)
-	for _, path := range paths {
-		if !contains(contentStr, path) {
-			t.Errorf("expected path %s in playlist, not found", path)
-		}
-	}
-}
-
-func TestCreateM3UPlaylist_AlreadyExists(t *testing.T) {
-	tmpDir := t.TempDir()
-	outputPath := filepath.Join(tmpDir, "existing.m3u")
-
-	// Create existing file
-	if err := os.WriteFile(outputPath, []byte("existing"), 0644); err != nil {
-		t.Fatalf("failed to create existing file: %v", err)
-	}
-
-	paths := []string{"/music/test.flac"}
-	err := CreateM3UPlaylist(paths, tmpDir, outputPath)
-	if err != os.ErrExist {
-		t.Errorf("expected ErrExist, got %v", err)
-	}
-}
-
-func TestCreateM3UPlaylist_EmptyPaths(t *testing.T) {
-	tmpDir := t.TempDir()
-	outputPath := filepath.Join(tmpDir, "empty.m3u")
-
-	err := CreateM3UPlaylist([]string{}, tmpDir, outputPath)
-	if err != nil {
-		t.Fatalf("CreateM3UPlaylist failed for empty paths: %v", err)
-	}
-
-	// File should exist but be empty
-	content, err := os.ReadFile(outputPath)
-	if err != nil {
-		t.Fatalf("failed to read output file: %v", err)
-	}
-
-	if len(content) != 0 {
-		t.Errorf("expected empty file, got %d bytes", len(content))
+func TestWriteExtendedM3U(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "Playlists", "test.m3u8")
+
+	entries := []PlaylistEntry{
+		{Path: "/mnt/music/Björk - Jóga.flac", Artist: "Björk", Title: "Jóga", Duration: 305400 * time.Millisecond, Cover: "https://i.scdn.co/image/cover"},
+		{Path: "/mnt/music/Artist2 - Song2.flac"},
+	}
+
+	tests := []struct {
+		name  string
+		mode  string
+		paths []string
+	}{
+		{"absolute", "absolute", []string{"/mnt/music/Björk - Jóga.flac", "/mnt/music/Artist2 - Song2.flac"}},
+		{"prefix", "prefix", []string{"/music/Björk - Jóga.flac", "/music/Artist2 - Song2.flac"}},
+		{"relative", "relative", []string{"../../mnt/music/Björk - Jóga.flac", "../../mnt/music/Artist2 - Song2.flac"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper, err := NewPathMapper(tt.mode, []string{"/mnt/music=/music"})
+			if err != nil {
+				t.Fatalf("NewPathMapper failed: %v", err)
+			}
+
+			// relative paths are resolved against the playlist, keep it at a known depth
+			playlistPath := outputPath
+			if tt.mode == "relative" {
+				playlistPath = "/srv/Playlists/" + tt.name + ".m3u8"
+			}
+
+			var b strings.Builder
+			if err := writeExtendedM3U(&b, entries, mapper, playlistPath); err != nil {
+				t.Fatalf("writeExtendedM3U failed: %v", err)
+			}
+
+			want := "#EXTM3U\n" +
+				"#EXTINF:305,Björk - Jóga\n" +
+				"#EXTIMG:https://i.scdn.co/image/cover\n" +
+				tt.paths[0] + "\n" +
+				"#EXTINF:-1,Artist2 - Song2\n" +
+				tt.paths[1] + "\n"
+			if b.String() != want {
+				t.Errorf("unexpected playlist:\n%s\nwant:\n%s", b.String(), want)
+			}
+		})
 	}
 }
 
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PathMode selects how library paths are written into playlists
+type PathMode string
+
+const (
+	// PathModeAbsolute writes the paths as the wrapper sees them
+	PathModeAbsolute PathMode = "absolute"
+	// PathModePrefix swaps path prefixes, e.g. when the media server mounts the library elsewhere
+	PathModePrefix PathMode = "prefix"
+	// PathModeRelative writes paths relative to the playlist file, which works on any mount point
+	PathModeRelative PathMode = "relative"
+)
+
+var (
+	ErrInvalidPathMode   = errors.New("invalid playlist path mode")
+	ErrInvalidPathPrefix = errors.New("invalid playlist path prefix rule, want from=to")
+)
+
+// PrefixRule rewrites paths starting with From to start with To
+type PrefixRule struct {
+	From string
+	To   string
+}
+
+// PathMapper rewrites library paths into the paths players open
+type PathMapper struct {
+	Mode PathMode
+	// Rules are tried in order in prefix mode, the first matching one wins
+	Rules []PrefixRule
+}
+
+// NewPathMapper parses mode and prefix rules in "from=to" form
+func NewPathMapper(mode string, prefixes []string) (PathMapper, error) {
+	mapper := PathMapper{Mode: PathMode(mode)}
+	switch mapper.Mode {
+	case PathModeAbsolute, PathModeRelative:
+	case PathModePrefix:
+		for _, prefix := range prefixes {
+			from, to, ok := strings.Cut(prefix, "=")
+			if !ok || from == "" {
+				return PathMapper{}, fmt.Errorf("%w: %q", ErrInvalidPathPrefix, prefix)
+			}
+			mapper.Rules = append(mapper.Rules, PrefixRule{From: filepath.Clean(from), To: to})
+		}
+	default:
+		return PathMapper{}, fmt.Errorf("%w: %q", ErrInvalidPathMode, mode)
+	}
+
+	return mapper, nil
+}
+
+// Map returns the playlist entry for the library file at path in the playlist at playlistPath
+func (m PathMapper) Map(path, playlistPath string) (string, error) {
+	path = filepath.Clean(path)
+
+	switch m.Mode {
+	case PathModeRelative:
+		rel, err := filepath.Rel(filepath.Dir(playlistPath), path)
+		if err != nil {
+			return "", err
+		}
+		return filepath.ToSlash(rel), nil
+	case PathModePrefix:
+		for _, rule := range m.Rules {
+			if path == rule.From {
+				return rule.To, nil
+			}
+			// only whole path elements match, /mnt/music must not rewrite /mnt/musicals
+			if rest, ok := strings.CutPrefix(path, rule.From+string(filepath.Separator)); ok {
+				return strings.TrimSuffix(rule.To, "/") + "/" + filepath.ToSlash(rest), nil
+			}
+		}
+		return path, nil
+	default:
+		return path, nil
+	}
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPathMapper(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		prefixes []string
+		path     string
+		playlist string
+		want     string
+	}{
+		{"absolute", "absolute", nil, "/mnt/music/A/Song.flac", "/mnt/music/downloads/Playlists/p.m3u8", "/mnt/music/A/Song.flac"},
+		{"prefix", "prefix", []string{"/mnt/music=/music"}, "/mnt/music/A/Song.flac", "/mnt/music/downloads/Playlists/p.m3u8", "/music/A/Song.flac"},
+		{"prefix first rule wins", "prefix", []string{"/mnt/music/downloads=/dl", "/mnt/music=/music"}, "/mnt/music/downloads/Song.flac", "/p.m3u8", "/dl/Song.flac"},
+		{"prefix whole elements only", "prefix", []string{"/mnt/music=/music"}, "/mnt/musicals/Song.flac", "/p.m3u8", "/mnt/musicals/Song.flac"},
+		{"prefix trailing slashes", "prefix", []string{"/mnt/music/=/music/"}, "/mnt/music/Song.flac", "/p.m3u8", "/music/Song.flac"},
+		{"relative sibling", "relative", nil, "/mnt/music/downloads/A - Song.flac", "/mnt/music/downloads/Playlists/p.m3u8", "../A - Song.flac"},
+		{"relative library", "relative", nil, "/mnt/music/Artist/Album/Song.flac", "/mnt/music/downloads/Playlists/p.m3u8", "../../Artist/Album/Song.flac"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper, err := NewPathMapper(tt.mode, tt.prefixes)
+			if err != nil {
+				t.Fatalf("NewPathMapper failed: %v", err)
+			}
+
+			got, err := mapper.Map(tt.path, tt.playlist)
+			if err != nil {
+				t.Fatalf("Map failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Map(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPathMapper_Invalid(t *testing.T) {
+	if _, err := NewPathMapper("symlink", nil); !errors.Is(err, ErrInvalidPathMode) {
+		t.Errorf("expected ErrInvalidPathMode, got %v", err)
+	}
+	if _, err := NewPathMapper("prefix", []string{"/mnt/music"}); !errors.Is(err, ErrInvalidPathPrefix) {
+		t.Errorf("expected ErrInvalidPathPrefix, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PlaylistFormat is a playlist file format
+type PlaylistFormat string
+
+const (
+	PlaylistFormatM3U8 PlaylistFormat = "m3u8"
+	// PlaylistFormatM3U is the same playlist as m3u8 under the .m3u name older versions wrote
+	PlaylistFormatM3U  PlaylistFormat = "m3u"
+	PlaylistFormatXSPF PlaylistFormat = "xspf"
+	PlaylistFormatPLS  PlaylistFormat = "pls"
+	PlaylistFormatJSON PlaylistFormat = "json"
+)
+
+var (
+	ErrUnknownPlaylistFormat = errors.New("unknown playlist format")
+)
+
+// Playlist is a generated playlist in the order it is written
+type Playlist struct {
+	Name    string
+	Entries []PlaylistEntry
+}
+
+// PlaylistEntry is a single track of a generated playlist
+type PlaylistEntry struct {
+	Path   string
+	Artist string
+	Title  string
+	// Duration is optional, players show it before the file is opened
+	Duration time.Duration
+	// Cover is an optional URL or path of the cover art
+	Cover string
+}
+
+// PlaylistWriter renders playlists in one file format
+type PlaylistWriter interface {
+	// Extension is the file extension including the dot
+	Extension() string
+	// Write renders playlist to w, outputPath is where the file ends up and is needed for relative paths
+	Write(w io.Writer, playlist Playlist, mapper PathMapper, outputPath string) error
+}
+
+// NewPlaylistWriter returns the writer of format
+func NewPlaylistWriter(format PlaylistFormat) (PlaylistWriter, error) {
+	switch PlaylistFormat(strings.ToLower(string(format))) {
+	case PlaylistFormatM3U8:
+		return m3uWriter{extension: ".m3u8"}, nil
+	case PlaylistFormatM3U:
+		return m3uWriter{extension: ".m3u"}, nil
+	case PlaylistFormatXSPF:
+		return xspfWriter{}, nil
+	case PlaylistFormatPLS:
+		return plsWriter{}, nil
+	case PlaylistFormatJSON:
+		return jsonWriter{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPlaylistFormat, format)
+	}
+}
+
+// DefaultPlaylistWriter returns the writer of the extended M3U8 format every player understands
+func DefaultPlaylistWriter() PlaylistWriter {
+	return m3uWriter{extension: ".m3u8"}
+}
+
+// RemoveLegacyPlaylist removes the basePath.m3u file older versions wrote, unless one of writers still
+// writes it, so media servers don't show the playlist twice. It reports whether a file was removed.
+func RemoveLegacyPlaylist(basePath string, writers []PlaylistWriter) (bool, error) {
+	legacyPath := basePath + ".m3u"
+	for _, writer := range writers {
+		if basePath+writer.Extension() == legacyPath {
+			return false, nil
+		}
+	}
+
+	err := os.Remove(legacyPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// WritePlaylist atomically writes playlist to basePath plus the writer's extension and returns that path
+func WritePlaylist(writer PlaylistWriter, playlist Playlist, mapper PathMapper, basePath string) (string, error) {
+	outputPath := basePath + writer.Extension()
+	err := WriteFileAtomic(outputPath, func(w io.Writer) error {
+		return writer.Write(w, playlist, mapper, outputPath)
+	})
+	return outputPath, err
+}
+
+// entryTitle is the "Artist - Title" display name of entry, falling back to the file name
+func entryTitle(entry PlaylistEntry) string {
+	switch {
+	case entry.Artist != "" && entry.Title != "":
+		return entry.Artist + " - " + entry.Title
+	case entry.Title != "":
+		return entry.Title
+	default:
+		return strings.TrimSuffix(filepath.Base(entry.Path), filepath.Ext(entry.Path))
+	}
+}
+
+// durationSeconds is the rounded duration in seconds, -1 if unknown as M3U and PLS expect it
+func durationSeconds(d time.Duration) int {
+	if d <= 0 {
+		return -1
+	}
+	return int(d.Round(time.Second) / time.Second)
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPlaylist() Playlist {
+	return Playlist{
+		Name: "Road & Trip",
+		Entries: []PlaylistEntry{
+			{Path: "/mnt/music/Björk - Jóga.flac", Artist: "Björk", Title: "Jóga", Duration: 305400 * time.Millisecond, Cover: "https://i.scdn.co/image/cover"},
+			{Path: "/mnt/music/Artist2 - Song2.flac"},
+		},
+	}
+}
+
+func TestXSPFWriter(t *testing.T) {
+	mapper, err := NewPathMapper("prefix", []string{"/mnt/music=/music"})
+	if err != nil {
+		t.Fatalf("NewPathMapper failed: %v", err)
+	}
+
+	var b strings.Builder
+	if err := (xspfWriter{}).Write(&b, testPlaylist(), mapper, "/srv/Playlists/test.xspf"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	var doc xspfPlaylist
+	if err := xml.Unmarshal([]byte(b.String()), &doc); err != nil {
+		t.Fatalf("output is not valid XML: %v\n%s", err, b.String())
+	}
+
+	if doc.Title != "Road & Trip" || len(doc.Tracks) != 2 {
+		t.Fatalf("unexpected playlist: %+v", doc)
+	}
+
+	first := doc.Tracks[0]
+	if first.Location != "file:///music/Bj%C3%B6rk%20-%20J%C3%B3ga.flac" {
+		t.Errorf("unexpected location %q", first.Location)
+	}
+	if first.Title != "Jóga" || first.Creator != "Björk" || first.Duration != 305400 || first.Image != "https://i.scdn.co/image/cover" {
+		t.Errorf("unexpected track %+v", first)
+	}
+
+	// without tags the file name is the title
+	if doc.Tracks[1].Title != "Artist2 - Song2" || doc.Tracks[1].Duration != 0 {
+		t.Errorf("unexpected track %+v", doc.Tracks[1])
+	}
+}
+
+func TestPLSWriter(t *testing.T) {
+	var b strings.Builder
+	if err := (plsWriter{}).Write(&b, testPlaylist(), PathMapper{}, "/srv/Playlists/test.pls"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	want := "[playlist]\n" +
+		"File1=/mnt/music/Björk - Jóga.flac\n" +
+		"Title1=Björk - Jóga\n" +
+		"Length1=305\n" +
+		"File2=/mnt/music/Artist2 - Song2.flac\n" +
+		"Title2=Artist2 - Song2\n" +
+		"Length2=-1\n" +
+		"NumberOfEntries=2\n" +
+		"Version=2\n"
+	if b.String() != want {
+		t.Errorf("unexpected playlist:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestJSONWriter(t *testing.T) {
+	var b strings.Builder
+	if err := (jsonWriter{}).Write(&b, testPlaylist(), PathMapper{}, "/srv/Playlists/test.json"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	var doc jsonPlaylist
+	if err := json.Unmarshal([]byte(b.String()), &doc); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+
+	want := jsonPlaylist{
+		Name: "Road & Trip",
+		Tracks: []jsonTrack{
+			{Path: "/mnt/music/Björk - Jóga.flac", Artist: "Björk", Title: "Jóga", DurationMs: 305400, Cover: "https://i.scdn.co/image/cover"},
+			{Path: "/mnt/music/Artist2 - Song2.flac"},
+		},
+	}
+	if len(doc.Tracks) != len(want.Tracks) || doc.Name != want.Name {
+		t.Fatalf("unexpected manifest %+v", doc)
+	}
+	for i := range want.Tracks {
+		if doc.Tracks[i] != want.Tracks[i] {
+			t.Errorf("track %d: got %+v, want %+v", i, doc.Tracks[i], want.Tracks[i])
+		}
+	}
+}
+
+func TestWritePlaylist(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "Road Trip")
+
+	for _, format := range []PlaylistFormat{PlaylistFormatM3U8, PlaylistFormatM3U, PlaylistFormatXSPF, PlaylistFormatPLS, PlaylistFormatJSON} {
+		writer, err := NewPlaylistWriter(format)
+		if err != nil {
+			t.Fatalf("NewPlaylistWriter(%q) failed: %v", format, err)
+		}
+
+		outputPath, err := WritePlaylist(writer, testPlaylist(), PathMapper{}, basePath)
+		if err != nil {
+			t.Fatalf("WritePlaylist(%q) failed: %v", format, err)
+		}
+		if outputPath != basePath+"."+string(format) {
+			t.Errorf("unexpected output path %q", outputPath)
+		}
+		if _, err := os.Stat(outputPath); err != nil {
+			t.Errorf("playlist %q not written: %v", outputPath, err)
+		}
+	}
+
+	if _, err := NewPlaylistWriter("wpl"); !errors.Is(err, ErrUnknownPlaylistFormat) {
+		t.Errorf("expected ErrUnknownPlaylistFormat, got %v", err)
+	}
+}
+
+func TestRemoveLegacyPlaylist(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "Road Trip")
+	if err := os.WriteFile(basePath+".m3u", []byte("#EXTM3U\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m3u, _ := NewPlaylistWriter(PlaylistFormatM3U)
+	if removed, err := RemoveLegacyPlaylist(basePath, []PlaylistWriter{DefaultPlaylistWriter(), m3u}); err != nil || removed {
+		t.Fatalf("expected the .m3u file to be kept while it is written, got %v, %v", removed, err)
+	}
+
+	if removed, err := RemoveLegacyPlaylist(basePath, []PlaylistWriter{DefaultPlaylistWriter()}); err != nil || !removed {
+		t.Fatalf("expected the .m3u file to be removed, got %v, %v", removed, err)
+	}
+	if _, err := os.Stat(basePath + ".m3u"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("legacy playlist still there: %v", err)
+	}
+
+	if removed, err := RemoveLegacyPlaylist(basePath, []PlaylistWriter{DefaultPlaylistWriter()}); err != nil || removed {
+		t.Errorf("expected nothing to remove, got %v, %v", removed, err)
+	}
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// plsWriter writes PLS (version 2) playlists
+type plsWriter struct{}
+
+func (plsWriter) Extension() string {
+	return ".pls"
+}
+
+func (plsWriter) Write(w io.Writer, playlist Playlist, mapper PathMapper, outputPath string) error {
+	if _, err := io.WriteString(w, "[playlist]\n"); err != nil {
+		return err
+	}
+
+	for i, entry := range playlist.Entries {
+		path, err := mapper.Map(entry.Path, outputPath)
+		if err != nil {
+			return err
+		}
+
+		n := i + 1
+		title := strings.Join(strings.Fields(entryTitle(entry)), " ")
+		if _, err := fmt.Fprintf(w, "File%d=%s\nTitle%d=%s\nLength%d=%d\n", n, path, n, title, n, durationSeconds(entry.Duration)); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "NumberOfEntries=%d\nVersion=2\n", len(playlist.Entries))
+	return err
+}
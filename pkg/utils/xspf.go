@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"path"
+)
+
+// xspfWriter writes XSPF (XML shareable playlist format) playlists
+type xspfWriter struct{}
+
+type xspfPlaylist struct {
+	XMLName   xml.Name    `xml:"playlist"`
+	Version   string      `xml:"version,attr"`
+	Namespace string      `xml:"xmlns,attr"`
+	Title     string      `xml:"title,omitempty"`
+	Tracks    []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	// Duration is in milliseconds
+	Duration int64  `xml:"duration,omitempty"`
+	Image    string `xml:"image,omitempty"`
+}
+
+func (xspfWriter) Extension() string {
+	return ".xspf"
+}
+
+func (xspfWriter) Write(w io.Writer, playlist Playlist, mapper PathMapper, outputPath string) error {
+	doc := xspfPlaylist{
+		Version:   "1",
+		Namespace: "http://xspf.org/ns/0/",
+		Title:     playlist.Name,
+		Tracks:    make([]xspfTrack, 0, len(playlist.Entries)),
+	}
+
+	for _, entry := range playlist.Entries {
+		location, err := mapper.Map(entry.Path, outputPath)
+		if err != nil {
+			return err
+		}
+
+		title := entry.Title
+		if title == "" {
+			title = entryTitle(entry)
+		}
+
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Location: xspfLocation(location),
+			Title:    title,
+			Creator:  entry.Artist,
+			Duration: entry.Duration.Milliseconds(),
+			Image:    entry.Cover,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// xspfLocation turns a playlist path into the URI XSPF requires, absolute paths become file URIs
+func xspfLocation(p string) string {
+	if path.IsAbs(p) {
+		return (&url.URL{Scheme: "file", Path: p}).String()
+	}
+	return (&url.URL{Path: p}).String()
+}